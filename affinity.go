@@ -0,0 +1,302 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// AffinityKind is the affinity block a term comes from
+type AffinityKind string
+
+const (
+	NodeAffinity    AffinityKind = "nodeAffinity"
+	PodAffinity     AffinityKind = "podAffinity"
+	PodAntiAffinity AffinityKind = "podAntiAffinity"
+)
+
+// AffinityResult is one affinity term of a workload checked against every
+// scheduled pod of the workload. Required terms must hold for every pod,
+// preferred ones are reported with their satisfaction percentage.
+type AffinityResult struct {
+	Kind         AffinityKind `json:"kind"`
+	Required     bool         `json:"required"`
+	Weight       int32        `json:"weight,omitempty"`
+	Term         string       `json:"term"`
+	Checked      int          `json:"checked"`
+	Satisfied    int          `json:"satisfied"`
+	Unsatisfied  []string     `json:"unsatisfied,omitempty"`
+	MatchingPods int          `json:"matching_pods"`
+}
+
+func (r *AffinityResult) record(pod corev1.Pod, satisfied bool) {
+	r.Checked++
+	if satisfied {
+		r.Satisfied++
+	} else {
+		r.Unsatisfied = append(r.Unsatisfied, fmt.Sprintf("%s (node %s)", pod.Name, pod.Spec.NodeName))
+	}
+}
+
+// Satisfaction is the percentage of the checked pods satisfying the term
+func (r AffinityResult) Satisfaction() float64 {
+	if r.Checked == 0 {
+		return 0
+	}
+	return float64(r.Satisfied) * 100 / float64(r.Checked)
+}
+
+// Violated tells whether a required term does not hold for some pod
+func (r AffinityResult) Violated() bool {
+	return r.Required && len(r.Unsatisfied) > 0
+}
+
+func (r AffinityResult) String() string {
+	mode := "required"
+	if !r.Required {
+		mode = fmt.Sprintf("preferred (weight %d)", r.Weight)
+	}
+	result := fmt.Sprintf("%s %s %s: %d/%d pods satisfied (%.2f%%)",
+		mode, r.Kind, r.Term, r.Satisfied, r.Checked, r.Satisfaction())
+	if len(r.Unsatisfied) > 0 {
+		result += fmt.Sprintf(", unsatisfied: [%s]", strings.Join(r.Unsatisfied, ", "))
+	}
+	return result
+}
+
+// VerifyAffinity checks the node affinity (nodeSelector included), pod
+// affinity and pod anti-affinity terms of a pod spec, required and preferred,
+// against the scheduled workload pods. pods are the live pods of every
+// namespace a term may select, namespaces are needed for namespaceSelector.
+func VerifyAffinity(spec corev1.PodSpec, workloadPods []corev1.Pod, nodes []corev1.Node, pods []corev1.Pod, namespaces []corev1.Namespace) ([]AffinityResult, error) {
+	nodesByName := make(map[string]corev1.Node)
+	for _, node := range nodes {
+		nodesByName[node.Name] = node
+	}
+
+	// Pods being deleted or not placed on a known node are not checked
+	var scheduled []corev1.Pod
+	for _, pod := range workloadPods {
+		if _, ok := nodesByName[pod.Spec.NodeName]; ok && pod.DeletionTimestamp == nil {
+			scheduled = append(scheduled, pod)
+		}
+	}
+	var live []corev1.Pod
+	for _, pod := range pods {
+		if _, ok := nodesByName[pod.Spec.NodeName]; ok && pod.DeletionTimestamp == nil {
+			live = append(live, pod)
+		}
+	}
+
+	var results []AffinityResult
+	affinity := spec.Affinity
+	if affinity == nil {
+		affinity = &corev1.Affinity{}
+	}
+
+	if nodeAffinity := affinity.NodeAffinity; len(spec.NodeSelector) > 0 ||
+		(nodeAffinity != nil && nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil) {
+		result := AffinityResult{Kind: NodeAffinity, Required: true, Term: "nodeSelector and node selector terms"}
+		for _, pod := range scheduled {
+			satisfied, err := matchesRequiredNodeAffinity(nodesByName[pod.Spec.NodeName], spec)
+			if err != nil {
+				return nil, err
+			}
+			result.record(pod, satisfied)
+		}
+		results = append(results, result)
+	}
+	if affinity.NodeAffinity != nil {
+		for _, term := range affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			result := AffinityResult{Kind: NodeAffinity, Weight: term.Weight, Term: nodeSelectorTermString(term.Preference)}
+			for _, pod := range scheduled {
+				satisfied, err := matchesNodeSelectorTerm(nodesByName[pod.Spec.NodeName], term.Preference)
+				if err != nil {
+					return nil, err
+				}
+				result.record(pod, satisfied)
+			}
+			results = append(results, result)
+		}
+	}
+
+	check := func(kind AffinityKind, required []corev1.PodAffinityTerm, preferred []corev1.WeightedPodAffinityTerm) error {
+		for _, term := range required {
+			result, err := checkPodAffinityTerm(kind, term, scheduled, live, nodesByName, namespaces)
+			if err != nil {
+				return err
+			}
+			result.Required = true
+			results = append(results, result)
+		}
+		for _, weighted := range preferred {
+			result, err := checkPodAffinityTerm(kind, weighted.PodAffinityTerm, scheduled, live, nodesByName, namespaces)
+			if err != nil {
+				return err
+			}
+			result.Weight = weighted.Weight
+			results = append(results, result)
+		}
+		return nil
+	}
+	if podAffinity := affinity.PodAffinity; podAffinity != nil {
+		if err := check(PodAffinity, podAffinity.RequiredDuringSchedulingIgnoredDuringExecution,
+			podAffinity.PreferredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, err
+		}
+	}
+	if podAntiAffinity := affinity.PodAntiAffinity; podAntiAffinity != nil {
+		if err := check(PodAntiAffinity, podAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution,
+			podAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+// checkPodAffinityTerm checks a pod (anti-)affinity term the way the scheduler
+// does: affinity needs another matching pod in the topology domain of the
+// pod's node, unless no pod matches at all and the pod matches its own term,
+// anti-affinity needs none
+func checkPodAffinityTerm(kind AffinityKind, term corev1.PodAffinityTerm, scheduled, live []corev1.Pod, nodesByName map[string]corev1.Node, namespaces []corev1.Namespace) (AffinityResult, error) {
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return AffinityResult{}, fmt.Errorf("invalid labelSelector of the %s term on %s: %w", kind, term.TopologyKey, err)
+	}
+	result := AffinityResult{Kind: kind, Term: fmt.Sprintf("%q on %s", selector.String(), term.TopologyKey)}
+
+	for _, pod := range scheduled {
+		inNamespaces, err := termNamespaces(term, pod.Namespace, namespaces)
+		if err != nil {
+			return AffinityResult{}, err
+		}
+		domain, hasDomain := nodesByName[pod.Spec.NodeName].Labels[term.TopologyKey]
+
+		matching, matchingInDomain := 0, 0
+		for _, other := range live {
+			if other.Namespace == pod.Namespace && other.Name == pod.Name {
+				continue
+			}
+			if !inNamespaces[other.Namespace] || !selector.Matches(labels.Set(other.Labels)) {
+				continue
+			}
+			matching++
+			if otherDomain, ok := nodesByName[other.Spec.NodeName].Labels[term.TopologyKey]; hasDomain && ok && otherDomain == domain {
+				matchingInDomain++
+			}
+		}
+		if matching > result.MatchingPods {
+			result.MatchingPods = matching
+		}
+
+		satisfied := matchingInDomain == 0
+		if kind == PodAffinity {
+			selfMatch := inNamespaces[pod.Namespace] && selector.Matches(labels.Set(pod.Labels))
+			satisfied = matchingInDomain > 0 || (matching == 0 && selfMatch)
+		}
+		result.record(pod, satisfied)
+	}
+	return result, nil
+}
+
+// termNamespaces resolves the namespaces of a term, the pod's own namespace
+// when neither namespaces nor namespaceSelector is set
+func termNamespaces(term corev1.PodAffinityTerm, podNamespace string, namespaces []corev1.Namespace) (map[string]bool, error) {
+	selected := make(map[string]bool)
+	for _, namespace := range term.Namespaces {
+		selected[namespace] = true
+	}
+
+	if term.NamespaceSelector == nil {
+		if len(selected) == 0 {
+			selected[podNamespace] = true
+		}
+		return selected, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(term.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespaceSelector of the term on %s: %w", term.TopologyKey, err)
+	}
+	for _, namespace := range namespaces {
+		if selector.Matches(labels.Set(namespace.Labels)) {
+			selected[namespace.Name] = true
+		}
+	}
+	return selected, nil
+}
+
+func nodeSelectorTermString(term corev1.NodeSelectorTerm) string {
+	var requirements []string
+	for _, expression := range term.MatchExpressions {
+		requirements = append(requirements, fmt.Sprintf("%s %s %v", expression.Key, expression.Operator, expression.Values))
+	}
+	for _, field := range term.MatchFields {
+		requirements = append(requirements, fmt.Sprintf("%s %s %v", field.Key, field.Operator, field.Values))
+	}
+	return fmt.Sprintf("%q", strings.Join(requirements, ", "))
+}
+
+// CheckAffinity lists the workload pods by selector, the nodes, the pods of
+// every namespace and, when a term has a namespaceSelector, the namespaces,
+// then verifies the affinity of a Deployment or StatefulSet template
+func CheckAffinity(ctx context.Context, clientset kubernetes.Interface, namespace string, template corev1.PodTemplateSpec, selector *metav1.LabelSelector) ([]AffinityResult, error) {
+	workloadPods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: metav1.FormatLabelSelector(selector),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workload pods in namespace %s: %w", namespace, err)
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	var namespaces []corev1.Namespace
+	if hasNamespaceSelector(template.Spec.Affinity) {
+		list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list namespaces: %w", err)
+		}
+		namespaces = list.Items
+	}
+
+	return VerifyAffinity(template.Spec, workloadPods.Items, nodes.Items, pods.Items, namespaces)
+}
+
+func hasNamespaceSelector(affinity *corev1.Affinity) bool {
+	if affinity == nil {
+		return false
+	}
+	var terms []corev1.PodAffinityTerm
+	if affinity.PodAffinity != nil {
+		terms = append(terms, affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, weighted := range affinity.PodAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, weighted.PodAffinityTerm)
+		}
+	}
+	if affinity.PodAntiAffinity != nil {
+		terms = append(terms, affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution...)
+		for _, weighted := range affinity.PodAntiAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			terms = append(terms, weighted.PodAffinityTerm)
+		}
+	}
+	for _, term := range terms {
+		if term.NamespaceSelector != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -1,195 +1,118 @@
 package example_test
 
 import (
-	"context"
+	"fmt"
 	"time"
 
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"github.com/rs/zerolog"
-	"gopkg.in/yaml.v2"
-	v1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	"example"
 )
 
-var _ = ginkgo.Describe("Deployment Affinity E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), func() {
+var _ = ginkgo.Describe("Deployment Affinity E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), example.TestTag("DeploymentAffinityTest"), example.Requires(example.RequirementZones, example.RequirementMetricsAPI, example.RequirementCPUHeadroom, example.RequirementRBAC), func() {
 	var (
-		clientset      *kubernetes.Clientset
+		clientset      kubernetes.Interface
+		manifestClient *example.ManifestClient
+		values         example.ManifestValues
+		namespace      string
 		hpaMaxReplicas int32
 		logger         zerolog.Logger
 		testTag        = "DeploymentAffinityTest"
 	)
 
-	ginkgo.BeforeAll(func() {
+	ginkgo.BeforeAll(func(ctx ginkgo.SpecContext) {
 
-		var err error
-		clientset, err = example.GetClient()
+		suite, err := example.Config()
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		clientset, manifestClient = suite.Clientset, suite.ManifestClient
 
 		logger = example.GetLogger(testTag)
 
-		// Namespace setup
-		logger.Info().Msgf("=== Ensuring test-ns exists ===")
-		_, err = clientset.CoreV1().Namespaces().Get(
-			context.TODO(),
-			"test-ns",
-			metav1.GetOptions{},
-		)
+		values = suite.Values
+		logger.Info().Msgf("=== Manifest values: %s ===", values)
 
-		if apierrors.IsNotFound(err) {
-			logger.Info().Msgf("Creating test-ns namespace\n")
-			ns := &v1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-ns",
-				},
-			}
-			_, err = clientset.CoreV1().Namespaces().Create(
-				context.TODO(),
-				ns,
-				metav1.CreateOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		} else {
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		}
+		// Skip before creating anything when the cluster lacks a declared requirement
+		err = example.SkipUnmetRequirements(ctx, logger, clientset, values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		// Namespace setup
+		namespace, err = example.CreateTestNamespace(ctx, logger, clientset, testTag)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		values.Namespace = namespace
 	})
 
-	ginkgo.AfterEach(func() {
-		clientset.CoreV1().RESTClient().(*rest.RESTClient).Client.CloseIdleConnections()
+	ginkgo.AfterEach(func(ctx ginkgo.SpecContext) {
+		// Capture the evidence before AfterAll clears the namespace
 		if ginkgo.CurrentSpecReport().Failed() {
-			logger.Error().Msgf("%s:TEST_FAILED", testTag)
+			example.CollectFailureDiagnostics(ctx, logger, clientset, namespace, testTag)
 		}
 	})
 
-	ginkgo.AfterAll(func() {
-		example.ClearNamespace(logger, clientset)
+	ginkgo.AfterAll(func(ctx ginkgo.SpecContext) {
+		example.ClearNamespace(ctx, logger, clientset, namespace)
 	})
 
-	ginkgo.It("should apply affinity manifests", func() {
+	ginkgo.It("should apply affinity manifests", func(ctx ginkgo.SpecContext) {
 		logger.Info().Msgf("=== Starting Deployment Affinity E2E test ===")
 		logger.Info().Msgf("=== tag: %s, allowed to fail: %t", testTag, example.IsTestAllowedToFail(testTag))
 
-		hpaYAML, zoneYAML, depYAML, err := example.GetAffinityDeploymentTestFiles()
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		// Parse HPA YAML to extract maxReplicas
-		type hpaSpec struct {
-			Spec struct {
-				MaxReplicas int32 `yaml:"maxReplicas"`
-			} `yaml:"spec"`
-		}
-
-		var hpaConfig hpaSpec
-		err = yaml.Unmarshal([]byte(hpaYAML), &hpaConfig)
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		hpaMaxReplicas = hpaConfig.Spec.MaxReplicas
-
-		logger.Info().Msgf("=== Applying Zone Marker manifest ===")
-		err = example.ApplyRawManifest(clientset, zoneYAML)
+		fixtures, err := example.LoadFixtureBundle(testTag, values)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		logger.Info().Msgf("=== Applying Affinity-Deployment manifest ===")
-		err = example.ApplyRawManifest(clientset, depYAML)
+		hpaMaxReplicas, err = fixtures.Int32Param("maxReplicas")
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		logger.Info().Msgf("=== HPA maxReplicas: %d ===", hpaMaxReplicas)
 
-		logger.Info().Msgf("=== Applying HPA manifest (maxReplicas: %d) ===", hpaMaxReplicas)
-		err = example.ApplyRawManifest(clientset, hpaYAML)
+		err = example.ApplyFixtureBundle(ctx, logger, manifestClient, fixtures)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		logger.Info().Msgf("=== Wait for HPA to trigger scaling ===")
-		deadline := time.Now().Add(5 * time.Minute)
-		pollInterval := 5 * time.Second
-
-		for {
-			// Get current pod count for StatefulSet
-			currentPods, err := clientset.CoreV1().Pods("test-ns").List(
-				context.TODO(),
+		gomega.Eventually(ctx, func() error {
+			currentPods, err := clientset.CoreV1().Pods(namespace).List(
+				ctx,
 				metav1.ListOptions{
 					LabelSelector: "app=dependent-app",
 					FieldSelector: "status.phase=Running",
 				},
 			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			if err != nil {
+				return err
+			}
 
 			runningCount := len(currentPods.Items)
 			logger.Info().Msgf("Waiting for HPA, Current running pods: %d/%d\n", runningCount, hpaMaxReplicas)
-
-			if runningCount >= int(hpaMaxReplicas) {
-				logger.Info().Msgf("Waiting for HPA, Reached required pod count of %d\n", hpaMaxReplicas)
-				break
-			}
-
-			if time.Now().After(deadline) {
-				ginkgo.Fail("Failed to wait for the HPA to get to the maximum required pods")
+			if runningCount < int(hpaMaxReplicas) {
+				return fmt.Errorf("%d/%d pods running", runningCount, hpaMaxReplicas)
 			}
-
-			time.Sleep(pollInterval)
-		}
+			return nil
+		}, 5*time.Minute, 5*time.Second).Should(gomega.Succeed(), "Failed to wait for the HPA to get to the maximum required pods")
+		logger.Info().Msgf("Waiting for HPA, Reached required pod count of %d\n", hpaMaxReplicas)
 	})
 
-	ginkgo.It("should ensure dependent pods are in same zone as zone-marker", func() {
-
-		// Get zone-marker pod details using correct label selector
-		logger.Info().Msgf("=== Getting zone-marker pod details ===")
-		markerPods, err := clientset.CoreV1().Pods("test-ns").List(
-			context.TODO(),
-			metav1.ListOptions{
-				LabelSelector: "app=desired-zone-for-affinity", // Updated to match YAML labels
-			},
-		)
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		gomega.Expect(markerPods.Items).To(gomega.HaveLen(1),
-			"Should have exactly one zone-marker pod. Check deployment labels.")
+	ginkgo.It("should ensure dependent pods are in same zone as zone-marker", func(ctx ginkgo.SpecContext) {
 
-		markerPod := markerPods.Items[0]
-		markerNode, err := clientset.CoreV1().Nodes().Get(
-			context.TODO(),
-			markerPod.Spec.NodeName,
+		logger.Info().Msgf("=== Verifying dependent-app pods against the manifest affinity terms ===")
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(
+			ctx,
+			"dependent-app",
 			metav1.GetOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		markerZone := markerNode.Labels["topology.kubernetes.io/zone"]
-		logger.Info().Msgf("Zone-Marker Pod: %s\nNode: %s\nZone: %s\n",
-			markerPod.Name, markerPod.Spec.NodeName, markerZone)
-
-		// Get dependent-app pods details
-		logger.Info().Msgf("=== Getting dependent-app pods details ===")
-		depPods, err := clientset.CoreV1().Pods("test-ns").List(
-			context.TODO(),
-			metav1.ListOptions{
-				LabelSelector: "app=dependent-app",
-			},
-		)
+		results, err := example.CheckAffinity(ctx, clientset, namespace, deployment.Spec.Template, deployment.Spec.Selector)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		gomega.Expect(depPods.Items).NotTo(gomega.BeEmpty(),
-			"No dependent-app pods found. Check deployment status.")
-
-		var depZones []string
-		for _, pod := range depPods.Items {
-			node, err := clientset.CoreV1().Nodes().Get(
-				context.TODO(),
-				pod.Spec.NodeName,
-				metav1.GetOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-			zone := node.Labels["topology.kubernetes.io/zone"]
-			depZones = append(depZones, zone)
-			logger.Info().Msgf("Dependent Pod: %s\nNode: %s\nZone: %s\n",
-				pod.Name, pod.Spec.NodeName, zone)
+		gomega.Expect(results).NotTo(gomega.BeEmpty(), "The manifest declares no affinity terms")
+
+		for _, result := range results {
+			logger.Info().Msgf("Affinity: %s", result)
+			gomega.Expect(result.Checked).To(gomega.BeNumerically(">", 0), "No scheduled dependent-app pod to check")
+			// Without a zone-marker pod the term would hold vacuously
+			gomega.Expect(result.MatchingPods).To(gomega.BeNumerically(">", 0), "No zone-marker pod matches %s", result.Term)
+			gomega.Expect(result.Violated()).To(gomega.BeFalse(), "Affinity violation: %s", result)
 		}
-
-		// Validate all zones match
-		logger.Info().Msgf("=== Validating zone consistency ===")
-		logger.Info().Msgf("Zone-Marker Zone: %s\nDependent Pod Zones: %v\n", markerZone, depZones)
-		gomega.Expect(depZones).To(gomega.HaveEach(markerZone),
-			"All dependent pods should be in the same zone as zone-marker")
 	})
 
 })
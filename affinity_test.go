@@ -0,0 +1,119 @@
+package example_test
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"example"
+)
+
+func affinityPod(name, namespace, node, app string) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: map[string]string{"app": app}},
+		Spec:       v1.PodSpec{NodeName: node},
+	}
+}
+
+func appTerm(app string) v1.PodAffinityTerm {
+	return v1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}},
+		TopologyKey:   zoneKey,
+	}
+}
+
+var _ = ginkgo.Describe("VerifyAffinity", ginkgo.Label("unit"), func() {
+	var nodes []v1.Node
+
+	ginkgo.BeforeEach(func() {
+		nodes = []v1.Node{
+			*zonedNode("node-a1", "zone-a", "2"),
+			*zonedNode("node-a2", "zone-a", "2"),
+			*zonedNode("node-b1", "zone-b", "2"),
+		}
+		nodes[0].Labels["disk"] = "ssd"
+	})
+
+	ginkgo.It("should check required pod affinity and anti-affinity on the topology domain", func() {
+		workload := []v1.Pod{
+			affinityPod("dependent-0", "app-ns", "node-a2", "dependent-app"),
+			affinityPod("dependent-1", "app-ns", "node-b1", "dependent-app"),
+			affinityPod("pending", "app-ns", "", "dependent-app"),
+		}
+		pods := append([]v1.Pod{affinityPod("marker", "app-ns", "node-a1", "marker")}, workload...)
+		spec := v1.PodSpec{Affinity: &v1.Affinity{
+			PodAffinity:     &v1.PodAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{appTerm("marker")}},
+			PodAntiAffinity: &v1.PodAntiAffinity{RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{appTerm("marker")}},
+		}}
+
+		results, err := example.VerifyAffinity(spec, workload, nodes, pods, nil)
+
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(results).To(gomega.HaveLen(2))
+		gomega.Expect(results[0].Kind).To(gomega.Equal(example.PodAffinity))
+		gomega.Expect(results[0].Checked).To(gomega.Equal(2))
+		gomega.Expect(results[0].MatchingPods).To(gomega.Equal(1))
+		gomega.Expect(results[0].Unsatisfied).To(gomega.Equal([]string{"dependent-1 (node node-b1)"}))
+		gomega.Expect(results[0].Violated()).To(gomega.BeTrue())
+		gomega.Expect(results[1].Kind).To(gomega.Equal(example.PodAntiAffinity))
+		gomega.Expect(results[1].Unsatisfied).To(gomega.Equal([]string{"dependent-0 (node node-a2)"}))
+		gomega.Expect(results[1].String()).To(gomega.HavePrefix(`required podAntiAffinity "app=marker" on topology.kubernetes.io/zone: 1/2 pods satisfied (50.00%)`))
+	})
+
+	ginkgo.It("should report the satisfaction of preferred node affinity", func() {
+		workload := []v1.Pod{
+			affinityPod("app-0", "app-ns", "node-a1", "app"),
+			affinityPod("app-1", "app-ns", "node-a2", "app"),
+			affinityPod("app-2", "app-ns", "node-b1", "app"),
+			affinityPod("app-3", "app-ns", "node-a1", "app"),
+		}
+		spec := v1.PodSpec{
+			NodeSelector: map[string]string{zoneKey: "zone-a"},
+			Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{{
+					Weight: 50,
+					Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+						{Key: "disk", Operator: v1.NodeSelectorOpIn, Values: []string{"ssd"}},
+					}},
+				}},
+			}},
+		}
+
+		results, err := example.VerifyAffinity(spec, workload, nodes, workload, nil)
+
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(results).To(gomega.HaveLen(2))
+		gomega.Expect(results[0].Required).To(gomega.BeTrue())
+		gomega.Expect(results[0].Unsatisfied).To(gomega.Equal([]string{"app-2 (node node-b1)"}))
+		gomega.Expect(results[1].Weight).To(gomega.Equal(int32(50)))
+		gomega.Expect(results[1].Satisfaction()).To(gomega.Equal(50.0))
+		// Preferred terms are reported, never violated
+		gomega.Expect(results[1].Violated()).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should resolve namespaceSelector and let the first self-affine pod in", func() {
+		namespaces := []v1.Namespace{
+			{ObjectMeta: metav1.ObjectMeta{Name: "app-ns"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "cache-ns", Labels: map[string]string{"team": "cache"}}},
+		}
+		cacheTerm := appTerm("cache")
+		cacheTerm.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "cache"}}
+		workload := []v1.Pod{affinityPod("app-0", "app-ns", "node-b1", "app")}
+		pods := append([]v1.Pod{
+			affinityPod("cache-0", "cache-ns", "node-b1", "cache"),
+			affinityPod("cache-1", "app-ns", "node-a1", "cache"),
+		}, workload...)
+		spec := v1.PodSpec{Affinity: &v1.Affinity{PodAffinity: &v1.PodAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{cacheTerm, appTerm("app")},
+		}}}
+
+		results, err := example.VerifyAffinity(spec, workload, nodes, pods, namespaces)
+
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(results[0].MatchingPods).To(gomega.Equal(1))
+		gomega.Expect(results[0].Violated()).To(gomega.BeFalse())
+		gomega.Expect(results[1].MatchingPods).To(gomega.Equal(0))
+		gomega.Expect(results[1].Violated()).To(gomega.BeFalse())
+	})
+})
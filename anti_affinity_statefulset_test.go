@@ -1,222 +1,118 @@
 package example_test
 
 import (
-	"context"
+	"fmt"
 	"time"
 
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"github.com/rs/zerolog"
-	"gopkg.in/yaml.v2"
-	v1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	"example"
 )
 
-var _ = ginkgo.Describe("StatefulSet Anti Affinity E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), func() {
+var _ = ginkgo.Describe("StatefulSet Anti Affinity E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), example.TestTag("StatefulSetAntiAffinityTest"), example.Requires(example.RequirementZones, example.RequirementMetricsAPI, example.RequirementCPUHeadroom, example.RequirementRBAC), func() {
 	var (
-		clientset      *kubernetes.Clientset
+		clientset      kubernetes.Interface
+		manifestClient *example.ManifestClient
+		values         example.ManifestValues
+		namespace      string
 		hpaMaxReplicas int32
 		logger         zerolog.Logger
 		testTag        = "StatefulSetAntiAffinityTest"
 	)
 
-	ginkgo.BeforeAll(func() {
+	ginkgo.BeforeAll(func(ctx ginkgo.SpecContext) {
 
-		var err error
-		clientset, err = example.GetClient()
+		suite, err := example.Config()
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		clientset, manifestClient = suite.Clientset, suite.ManifestClient
 
 		logger = example.GetLogger(testTag)
 
-		// Namespace setup
-		logger.Info().Msgf("=== Ensuring test-ns exists ===")
-		_, err = clientset.CoreV1().Namespaces().Get(
-			context.TODO(),
-			"test-ns",
-			metav1.GetOptions{},
-		)
+		values = suite.Values
+		logger.Info().Msgf("=== Manifest values: %s ===", values)
 
-		if apierrors.IsNotFound(err) {
-			logger.Info().Msgf("Creating test-ns namespace\n")
-			ns := &v1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-ns",
-				},
-			}
-			_, err = clientset.CoreV1().Namespaces().Create(
-				context.TODO(),
-				ns,
-				metav1.CreateOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		} else {
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		}
+		// Skip before creating anything when the cluster lacks a declared requirement
+		err = example.SkipUnmetRequirements(ctx, logger, clientset, values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		// Namespace setup
+		namespace, err = example.CreateTestNamespace(ctx, logger, clientset, testTag)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		values.Namespace = namespace
 	})
 
-	ginkgo.AfterEach(func() {
-		clientset.CoreV1().RESTClient().(*rest.RESTClient).Client.CloseIdleConnections()
+	ginkgo.AfterEach(func(ctx ginkgo.SpecContext) {
+		// Capture the evidence before AfterAll clears the namespace
 		if ginkgo.CurrentSpecReport().Failed() {
-			logger.Error().Msgf("%s:TEST_FAILED", testTag)
+			example.CollectFailureDiagnostics(ctx, logger, clientset, namespace, testTag)
 		}
-
 	})
 
-	ginkgo.AfterAll(func() {
-		example.ClearNamespace(logger, clientset)
+	ginkgo.AfterAll(func(ctx ginkgo.SpecContext) {
+		example.ClearNamespace(ctx, logger, clientset, namespace)
 	})
 
-	ginkgo.It("should apply anti affinity manifests", func() {
+	ginkgo.It("should apply anti affinity manifests", func(ctx ginkgo.SpecContext) {
 		logger.Info().Msgf("=== Starting StatefulSet Anti Affinity E2E test ===")
 		logger.Info().Msgf("=== tag: %s, allowed to fail: %t", testTag, example.IsTestAllowedToFail(testTag))
 
-		hpaYAML, zoneYAML, ssYAML, err := example.GetAntiAffinityStatefulSetTestFiles()
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		// Parse HPA YAML to extract maxReplicas
-		type hpaSpec struct {
-			Spec struct {
-				MaxReplicas int32 `yaml:"maxReplicas"`
-			} `yaml:"spec"`
-		}
-
-		var hpaConfig hpaSpec
-		err = yaml.Unmarshal([]byte(hpaYAML), &hpaConfig)
+		fixtures, err := example.LoadFixtureBundle(testTag, values)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		hpaMaxReplicas = hpaConfig.Spec.MaxReplicas
-
-		logger.Info().Msgf("=== Applying Zone Marker manifest ===")
-		err = example.ApplyRawManifest(clientset, zoneYAML)
+		hpaMaxReplicas, err = fixtures.Int32Param("maxReplicas")
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		logger.Info().Msgf("=== HPA maxReplicas: %d ===", hpaMaxReplicas)
 
-		logger.Info().Msgf("=== Applying Anti Affinity StatefulSet and Service manifest ===")
-		err = example.ApplyRawManifest(clientset, ssYAML)
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		logger.Info().Msgf("=== Applying HPA manifest (maxReplicas: %d) ===", hpaMaxReplicas)
-		err = example.ApplyRawManifest(clientset, hpaYAML)
+		err = example.ApplyFixtureBundle(ctx, logger, manifestClient, fixtures)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		logger.Info().Msgf("=== Wait for HPA to trigger scaling ===")
-		deadline := time.Now().Add(5 * time.Minute)
-		pollInterval := 5 * time.Second
-
-		for {
-			// Get current pod count for StatefulSet
-			currentPods, err := clientset.CoreV1().Pods("test-ns").List(
-				context.TODO(),
+		gomega.Eventually(ctx, func() error {
+			currentPods, err := clientset.CoreV1().Pods(namespace).List(
+				ctx,
 				metav1.ListOptions{
 					LabelSelector: "app=dependent-app",
 					FieldSelector: "status.phase=Running",
 				},
 			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			if err != nil {
+				return err
+			}
 
 			runningCount := len(currentPods.Items)
 			logger.Info().Msgf("Waiting for HPA, Current running pods: %d/%d\n", runningCount, hpaMaxReplicas)
-
-			if runningCount >= int(hpaMaxReplicas) {
-				logger.Info().Msgf("Waiting for HPA, Reached required pod count of %d\n", hpaMaxReplicas)
-				break
+			if runningCount < int(hpaMaxReplicas) {
+				return fmt.Errorf("%d/%d pods running", runningCount, hpaMaxReplicas)
 			}
-
-			if time.Now().After(deadline) {
-				ginkgo.Fail("Failed to wait for the HPA to get to the maximum required pods")
-			}
-
-			time.Sleep(pollInterval)
-		}
+			return nil
+		}, 5*time.Minute, 5*time.Second).Should(gomega.Succeed(), "Failed to wait for the HPA to get to the maximum required pods")
+		logger.Info().Msgf("Waiting for HPA, Reached required pod count of %d\n", hpaMaxReplicas)
 	})
 
-	ginkgo.It("should enforce zone separation between zone-marker and dependent-app", func() {
+	ginkgo.It("should enforce zone separation between zone-marker and dependent-app", func(ctx ginkgo.SpecContext) {
 
-		// Get zone-marker pod information
-		logger.Info().Msgf("=== Getting zone-marker pod details ===")
-		zoneMarkerPods, err := clientset.CoreV1().Pods("test-ns").List(
-			context.TODO(),
-			metav1.ListOptions{LabelSelector: "app=desired-zone-for-anti-affinity"},
+		logger.Info().Msgf("=== Verifying dependent-app pods against the manifest anti affinity terms ===")
+		statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(
+			ctx,
+			"dependent-app",
+			metav1.GetOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		gomega.Expect(zoneMarkerPods.Items).NotTo(gomega.BeEmpty(), "No zone-marker pods found")
-
-		// Collect unique forbidden zones using a map
-		forbiddenZones := make(map[string]struct{})
-		type podInfo struct{ name, node, zone string }
-		var zoneMarkerDetails []podInfo
-
-		for _, zmPod := range zoneMarkerPods.Items {
-			node, err := clientset.CoreV1().Nodes().Get(
-				context.TODO(),
-				zmPod.Spec.NodeName,
-				metav1.GetOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-			zone := node.Labels["topology.kubernetes.io/zone"]
-			gomega.Expect(zone).NotTo(gomega.BeEmpty(),
-				"Zone label missing on node %s", zmPod.Spec.NodeName)
-
-			forbiddenZones[zone] = struct{}{}
-			zoneMarkerDetails = append(zoneMarkerDetails, podInfo{
-				name: zmPod.Name,
-				node: zmPod.Spec.NodeName,
-				zone: zone,
-			})
-		}
-
-		// Log zone-marker details
-		for _, detail := range zoneMarkerDetails {
-			logger.Info().Msgf("Zone-Marker Pod: %-20s Node: %-15s Zone: %s\n",
-				detail.name, detail.node, detail.zone)
-		}
 
-		// Get dependent-app pods
-		logger.Info().Msgf("=== Getting dependent-app pods details ===")
-		dependentPods, err := clientset.CoreV1().Pods("test-ns").List(
-			context.TODO(),
-			metav1.ListOptions{LabelSelector: "app=dependent-app"},
-		)
+		results, err := example.CheckAffinity(ctx, clientset, namespace, statefulSet.Spec.Template, statefulSet.Spec.Selector)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		gomega.Expect(dependentPods.Items).NotTo(gomega.BeEmpty(), "No dependent-app pods found")
-
-		// Verify zone separation
-		logger.Info().Msgf("=== Validating zone constraints ===")
-		var dependentAppZones []string
-		for _, depPod := range dependentPods.Items {
-			node, err := clientset.CoreV1().Nodes().Get(
-				context.TODO(),
-				depPod.Spec.NodeName,
-				metav1.GetOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-			podZone := node.Labels["topology.kubernetes.io/zone"]
-			gomega.Expect(podZone).NotTo(gomega.BeEmpty(),
-				"Zone label missing on node %s", depPod.Spec.NodeName)
-
-			logger.Info().Msgf("Dependent Pod: %-20s Node: %-15s Zone: %s\n",
-				depPod.Name, depPod.Spec.NodeName, podZone)
-
-			dependentAppZones = append(dependentAppZones, podZone)
-
-			// Check against deduplicated forbidden zones
-			gomega.Expect(forbiddenZones).NotTo(gomega.HaveKey(podZone),
-				"Pod %s in prohibited zone %s", depPod.Name, podZone)
+		gomega.Expect(results).NotTo(gomega.BeEmpty(), "The manifest declares no affinity terms")
+
+		for _, result := range results {
+			logger.Info().Msgf("Affinity: %s", result)
+			gomega.Expect(result.Checked).To(gomega.BeNumerically(">", 0), "No scheduled dependent-app pod to check")
+			// Without a zone-marker pod the term would hold vacuously
+			gomega.Expect(result.MatchingPods).To(gomega.BeNumerically(">", 0), "No zone-marker pod matches %s", result.Term)
+			gomega.Expect(result.Violated()).To(gomega.BeFalse(), "Affinity violation: %s", result)
 		}
-
-		// Convert forbidden zones map to slice for logging
-		forbiddenZonesSlice := make([]string, 0, len(forbiddenZones))
-		for zone := range forbiddenZones {
-			forbiddenZonesSlice = append(forbiddenZonesSlice, zone)
-		}
-
-		logger.Info().Msgf("Zone-Marker Zones (forbidden): %v\nDependent Pod Zones: %v\n",
-			forbiddenZonesSlice, dependentAppZones)
 	})
 
 })
@@ -0,0 +1,198 @@
+package example_test
+
+import (
+	"errors"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("ApplyRawManifest", ginkgo.Label("unit"), func() {
+	var (
+		manifestClient *example.ManifestClient
+		dynamicClient  *dynamicfake.FakeDynamicClient
+		configMapGVR   = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+		jobGVR         = schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}
+		clusterRoleGVR = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}
+	)
+
+	ginkgo.BeforeEach(func() {
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+		mapper.Add(schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}, meta.RESTScopeNamespace)
+		mapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot)
+
+		dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+		// The fake tracker cannot server-side apply unstructured objects, emulate it
+		// by creating or replacing the object with the applied configuration
+		dynamicClient.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			patch := action.(clienttesting.PatchAction)
+			if patch.GetPatchType() != types.ApplyPatchType {
+				return false, nil, nil
+			}
+			obj := &unstructured.Unstructured{}
+			if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+				return true, nil, err
+			}
+			_, err := dynamicClient.Tracker().Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+			if apierrors.IsNotFound(err) {
+				return true, obj, dynamicClient.Tracker().Create(patch.GetResource(), obj, patch.GetNamespace())
+			}
+			return true, obj, dynamicClient.Tracker().Update(patch.GetResource(), obj, patch.GetNamespace())
+		})
+
+		manifestClient = &example.ManifestClient{
+			Dynamic: dynamicClient,
+			Mapper:  mapper,
+		}
+	})
+
+	ginkgo.It("should create every document of a multi-document manifest", func(ctx ginkgo.SpecContext) {
+		manifest := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: test-ns
+data:
+  key: value
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: once
+  namespace: test-ns
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: main
+        image: busybox
+`)
+		gomega.Expect(example.ApplyRawManifest(ctx, manifestClient, manifest)).To(gomega.Succeed())
+
+		cm, err := manifestClient.Dynamic.Resource(configMapGVR).Namespace("test-ns").Get(
+			ctx, "settings", metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(cm.Object["data"]).To(gomega.HaveKeyWithValue("key", "value"))
+
+		_, err = manifestClient.Dynamic.Resource(jobGVR).Namespace("test-ns").Get(
+			ctx, "once", metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should default namespaced objects without a namespace to default", func(ctx ginkgo.SpecContext) {
+		manifest := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+`)
+		gomega.Expect(example.ApplyRawManifest(ctx, manifestClient, manifest)).To(gomega.Succeed())
+
+		_, err := manifestClient.Dynamic.Resource(configMapGVR).Namespace(metav1.NamespaceDefault).Get(
+			ctx, "settings", metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should create cluster-scoped objects without a namespace", func(ctx ginkgo.SpecContext) {
+		manifest := []byte(`apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: reader
+  namespace: test-ns
+rules: []
+`)
+		gomega.Expect(example.ApplyRawManifest(ctx, manifestClient, manifest)).To(gomega.Succeed())
+
+		role, err := manifestClient.Dynamic.Resource(clusterRoleGVR).Get(
+			ctx, "reader", metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(role.GetNamespace()).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should report kinds unknown to the RESTMapper and keep applying the rest", func(ctx ginkgo.SpecContext) {
+		manifest := []byte(`apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: unknown
+  namespace: test-ns
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: test-ns
+`)
+		err := example.ApplyRawManifest(ctx, manifestClient, manifest)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("document 1: cannot resolve")))
+
+		_, err = manifestClient.Dynamic.Resource(configMapGVR).Namespace("test-ns").Get(
+			ctx, "settings", metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should re-apply existing objects and return the live objects", func(ctx ginkgo.SpecContext) {
+		manifest := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: test-ns
+data:
+  key: value
+`)
+		gomega.Expect(example.ApplyRawManifest(ctx, manifestClient, manifest)).To(gomega.Succeed())
+
+		updated := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: test-ns
+data:
+  key: changed
+`)
+		live, err := example.ServerSideApply(ctx, manifestClient, updated, false)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(live).To(gomega.HaveLen(1))
+		gomega.Expect(live[0].Object["data"]).To(gomega.HaveKeyWithValue("key", "changed"))
+
+		for _, action := range dynamicClient.Actions() {
+			gomega.Expect(action.GetVerb()).To(gomega.Equal("patch"))
+		}
+	})
+
+	ginkgo.It("should report field manager conflicts", func(ctx ginkgo.SpecContext) {
+		dynamicClient.PrependReactor("patch", "configmaps", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewApplyConflict([]metav1.StatusCause{{
+				Type:    metav1.CauseTypeFieldManagerConflict,
+				Message: `conflict with "kubectl-edit"`,
+				Field:   ".data.key",
+			}}, "Apply failed with 1 conflict")
+		})
+
+		manifest := []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  namespace: test-ns
+data:
+  key: value
+`)
+		_, err := example.ServerSideApply(ctx, manifestClient, manifest, false)
+
+		var conflict *example.ApplyConflictError
+		gomega.Expect(errors.As(err, &conflict)).To(gomega.BeTrue())
+		gomega.Expect(conflict.Object).To(gomega.Equal("ConfigMap test-ns/settings"))
+		gomega.Expect(conflict.Conflicts).To(gomega.ConsistOf(`.data.key (conflict with "kubectl-edit")`))
+	})
+})
@@ -0,0 +1,276 @@
+package example
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/rest"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Exec credential plugin API versions client-go accepts
+var execAPIVersions = []string{"client.authentication.k8s.io/v1", "client.authentication.k8s.io/v1beta1"}
+
+// DefaultServiceAccountDir is where the service account of a pod is mounted
+const DefaultServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// getLocalClusterAPICreds builds the LOCAL_K8S_API client config from the
+// service account of the pod, K8S_SERVICE_ACCOUNT_DIR overrides its mount.
+// The API server is the one of KUBERNETES_SERVICE_HOST/PORT, set by the
+// kubelet, kubernetes.default.svc without them.
+func getLocalClusterAPICreds() (*rest.Config, string, error) {
+	dir := getEnvOrDefault("K8S_SERVICE_ACCOUNT_DIR", DefaultServiceAccountDir)
+	tokenPath := filepath.Join(dir, "token")
+	caPath := filepath.Join(dir, "ca.crt")
+
+	token, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return nil, "", &ConfigError{Setting: tokenPath, Err: ErrMissingCredential, Detail: "failed reading token", Cause: err}
+	}
+	if strings.TrimSpace(string(token)) == "" {
+		return nil, "", &ConfigError{Setting: tokenPath, Err: ErrMissingCredential, Detail: tokenPath + " is empty"}
+	}
+
+	caCert, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, "", &ConfigError{Setting: caPath, Err: ErrMissingCredential, Detail: "failed reading CA cert", Cause: err}
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(caCert) {
+		return nil, "", &ConfigError{Setting: caPath, Err: ErrInvalidCredential, Detail: "no PEM certificate in the CA"}
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	apiURL := "https://kubernetes.default.svc"
+	switch {
+	case host != "" && port != "":
+		apiURL = "https://" + net.JoinHostPort(host, port)
+	case host != "" || port != "":
+		return nil, "", &ConfigError{Setting: "KUBERNETES_SERVICE_HOST, KUBERNETES_SERVICE_PORT", Err: ErrInvalidSetting, Detail: "set both or neither"}
+	}
+
+	config := &rest.Config{
+		Host: apiURL,
+		TLSClientConfig: rest.TLSClientConfig{
+			CAData: caCert,
+		},
+		// client-go reads the file again periodically, the rotated token is picked up
+		BearerTokenFile: tokenPath,
+	}
+	return config, fmt.Sprintf("service account token from file %s, API server %s", tokenPath, apiURL), nil
+}
+
+// getExternalClusterAPICreds builds the EXTERNAL_K8S_API client config from
+// the environment. Exactly one credential authenticates: K8S_TOKEN,
+// K8S_TOKEN_FILE, a client certificate or an exec plugin. It returns a
+// description of the credentials for the logs, without any secret.
+func getExternalClusterAPICreds() (*rest.Config, string, error) {
+	apiURL := os.Getenv("K8S_API_URL")
+	if apiURL == "" {
+		return nil, "", &ConfigError{Setting: "K8S_API_URL", Err: ErrMissingCredential, Detail: "environment variable not set"}
+	}
+	if parsed, err := url.Parse(apiURL); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, "", &ConfigError{Setting: "K8S_API_URL", Err: ErrInvalidSetting, Detail: fmt.Sprintf("must be an https:// URL, got %q", apiURL)}
+	}
+
+	config := &rest.Config{Host: apiURL}
+	auth, err := setExternalAuth(config)
+	if err != nil {
+		return nil, "", err
+	}
+	described := []string{auth}
+
+	caData, caFile, caSetting, err := credentialSource("K8S_CA_CERT", "K8S_CA_FILE")
+	if err != nil {
+		return nil, "", err
+	}
+	if caSetting == "" {
+		return nil, "", &ConfigError{Setting: "K8S_CA_CERT", Err: ErrMissingCredential, Detail: "set K8S_CA_CERT (base64) or K8S_CA_FILE"}
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(caData) {
+		return nil, "", &ConfigError{Setting: caSetting, Err: ErrInvalidCredential, Detail: "no PEM certificate in the CA"}
+	}
+	if caFile != "" {
+		config.TLSClientConfig.CAFile = caFile
+	} else {
+		config.TLSClientConfig.CAData = caData
+	}
+	described = append(described, "CA from "+describeSource(caSetting, caFile))
+
+	if serverName := os.Getenv("K8S_TLS_SERVER_NAME"); serverName != "" {
+		config.TLSClientConfig.ServerName = serverName
+		described = append(described, "TLS server name "+serverName)
+	}
+
+	if proxy := os.Getenv("K8S_PROXY_URL"); proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" ||
+			(proxyURL.Scheme != "http" && proxyURL.Scheme != "https" && proxyURL.Scheme != "socks5") {
+			// The URL may hold a password, it is not echoed
+			return nil, "", &ConfigError{Setting: "K8S_PROXY_URL", Err: ErrInvalidSetting, Detail: "must be an http://, https:// or socks5:// URL"}
+		}
+		config.Proxy = http.ProxyURL(proxyURL)
+		described = append(described, "proxy "+proxyURL.Redacted())
+	}
+
+	return config, strings.Join(described, ", "), nil
+}
+
+// setExternalAuth sets the one credential of the environment on config
+func setExternalAuth(config *rest.Config) (string, error) {
+	var methods []string
+	for _, name := range []string{"K8S_TOKEN", "K8S_TOKEN_FILE", "K8S_EXEC_COMMAND"} {
+		if os.Getenv(name) != "" {
+			methods = append(methods, name)
+		}
+	}
+	for _, name := range []string{"K8S_CLIENT_CERT", "K8S_CLIENT_CERT_FILE", "K8S_CLIENT_KEY", "K8S_CLIENT_KEY_FILE"} {
+		if os.Getenv(name) != "" {
+			methods = append(methods, "K8S_CLIENT_CERT")
+			break
+		}
+	}
+
+	switch {
+	case len(methods) == 0:
+		return "", &ConfigError{Setting: "K8S_TOKEN", Err: ErrMissingCredential,
+			Detail: "no credential set, use K8S_TOKEN, K8S_TOKEN_FILE, K8S_CLIENT_CERT(_FILE) with K8S_CLIENT_KEY(_FILE), or K8S_EXEC_COMMAND"}
+	case len(methods) > 1:
+		return "", &ConfigError{Setting: strings.Join(methods, ", "), Err: ErrInvalidSetting, Detail: "set only one credential"}
+	}
+
+	switch methods[0] {
+	case "K8S_TOKEN":
+		config.BearerToken = os.Getenv("K8S_TOKEN")
+		return "bearer token from K8S_TOKEN", nil
+
+	case "K8S_TOKEN_FILE":
+		// client-go reads the file again periodically, a rotated token is picked up
+		path := os.Getenv("K8S_TOKEN_FILE")
+		token, err := os.ReadFile(path)
+		if err != nil {
+			return "", &ConfigError{Setting: "K8S_TOKEN_FILE", Err: ErrMissingCredential, Detail: "failed reading token", Cause: err}
+		}
+		if strings.TrimSpace(string(token)) == "" {
+			return "", &ConfigError{Setting: "K8S_TOKEN_FILE", Err: ErrInvalidCredential, Detail: path + " is empty"}
+		}
+		config.BearerTokenFile = path
+		return "bearer token from file " + path, nil
+
+	case "K8S_EXEC_COMMAND":
+		return setExecAuth(config)
+
+	default:
+		return setClientCertAuth(config)
+	}
+}
+
+func setExecAuth(config *rest.Config) (string, error) {
+	command := os.Getenv("K8S_EXEC_COMMAND")
+	if _, err := exec.LookPath(command); err != nil {
+		return "", &ConfigError{Setting: "K8S_EXEC_COMMAND", Err: ErrMissingCredential, Detail: "credential plugin not found", Cause: err}
+	}
+
+	apiVersion := getEnvOrDefault("K8S_EXEC_API_VERSION", execAPIVersions[0])
+	if !slices.Contains(execAPIVersions, apiVersion) {
+		return "", &ConfigError{Setting: "K8S_EXEC_API_VERSION", Err: ErrInvalidSetting,
+			Detail: fmt.Sprintf("%q, must be one of %s", apiVersion, strings.Join(execAPIVersions, ", "))}
+	}
+
+	config.ExecProvider = &clientcmdapi.ExecConfig{
+		Command:         command,
+		Args:            strings.Fields(os.Getenv("K8S_EXEC_ARGS")),
+		APIVersion:      apiVersion,
+		InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+	}
+	// The arguments may carry secrets, only the command is logged
+	return fmt.Sprintf("exec plugin %s (%s)", command, apiVersion), nil
+}
+
+func setClientCertAuth(config *rest.Config) (string, error) {
+	certData, certFile, certSetting, err := credentialSource("K8S_CLIENT_CERT", "K8S_CLIENT_CERT_FILE")
+	if err != nil {
+		return "", err
+	}
+	keyData, keyFile, keySetting, err := credentialSource("K8S_CLIENT_KEY", "K8S_CLIENT_KEY_FILE")
+	if err != nil {
+		return "", err
+	}
+	if certSetting == "" {
+		return "", &ConfigError{Setting: "K8S_CLIENT_CERT", Err: ErrMissingCredential, Detail: "a client key needs K8S_CLIENT_CERT or K8S_CLIENT_CERT_FILE"}
+	}
+	if keySetting == "" {
+		return "", &ConfigError{Setting: "K8S_CLIENT_KEY", Err: ErrMissingCredential, Detail: "a client certificate needs K8S_CLIENT_KEY or K8S_CLIENT_KEY_FILE"}
+	}
+
+	pair, err := tls.X509KeyPair(certData, keyData)
+	if err != nil {
+		return "", &ConfigError{Setting: certSetting, Err: ErrInvalidCredential, Detail: "client certificate and key do not load", Cause: err}
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return "", &ConfigError{Setting: certSetting, Err: ErrInvalidCredential, Detail: "client certificate does not parse", Cause: err}
+	}
+	if time.Now().After(leaf.NotAfter) {
+		return "", &ConfigError{Setting: certSetting, Err: ErrInvalidCredential,
+			Detail: fmt.Sprintf("client certificate CN=%s expired on %s", leaf.Subject.CommonName, leaf.NotAfter.Format(time.RFC3339))}
+	}
+
+	if certFile != "" {
+		config.TLSClientConfig.CertFile = certFile
+	} else {
+		config.TLSClientConfig.CertData = certData
+	}
+	if keyFile != "" {
+		config.TLSClientConfig.KeyFile = keyFile
+	} else {
+		config.TLSClientConfig.KeyData = keyData
+	}
+	return fmt.Sprintf("client certificate CN=%s from %s (expires %s)",
+		leaf.Subject.CommonName, describeSource(certSetting, certFile), leaf.NotAfter.Format(time.RFC3339)), nil
+}
+
+// credentialSource reads a PEM credential given either base64 encoded in
+// dataVar or as a path in fileVar. file is set when it came from a file,
+// setting names the variable it came from, empty when neither is set.
+func credentialSource(dataVar, fileVar string) (data []byte, file, setting string, err error) {
+	encoded, path := os.Getenv(dataVar), os.Getenv(fileVar)
+	switch {
+	case encoded != "" && path != "":
+		return nil, "", "", &ConfigError{Setting: dataVar + ", " + fileVar, Err: ErrInvalidSetting, Detail: "set only one of them"}
+
+	case encoded != "":
+		// Process escaped newlines
+		encoded = strings.ReplaceAll(encoded, "\\n", "\n")
+		data, err = base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, "", "", &ConfigError{Setting: dataVar, Err: ErrInvalidCredential, Detail: "base64 decoding failed", Cause: err}
+		}
+		return data, "", dataVar, nil
+
+	case path != "":
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return nil, "", "", &ConfigError{Setting: fileVar, Err: ErrMissingCredential, Detail: "failed reading " + path, Cause: err}
+		}
+		return data, path, fileVar, nil
+	}
+	return nil, "", "", nil
+}
+
+func describeSource(setting, file string) string {
+	if file == "" {
+		return setting
+	}
+	return setting + " " + file
+}
@@ -0,0 +1,271 @@
+package example
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// BundleDescriptorFile is the index of a fixture bundle, rendered like the
+// manifests it lists
+const BundleDescriptorFile = "bundle.yaml"
+
+const (
+	defaultGateTimeout = 5 * time.Minute
+	gatePollInterval   = 5 * time.Second
+)
+
+// GateReady is the readiness gate condition of workloads: every replica of the
+// current generation is ready. Any other condition is looked up in
+// status.conditions and must be True.
+const GateReady = "Ready"
+
+// BundleDescriptor lists the manifests of a bundle in apply order, the gates
+// each step waits for, and the parameters the test reads from the manifests
+type BundleDescriptor struct {
+	Apply  []ApplyStep         `json:"apply"`
+	Params map[string]ParamRef `json:"params,omitempty"`
+}
+
+// ApplyStep applies one file, then waits for its gates and its pause
+type ApplyStep struct {
+	File  string          `json:"file"`
+	Wait  []ReadinessGate `json:"wait,omitempty"`
+	Pause metav1.Duration `json:"pause,omitempty"`
+}
+
+// ReadinessGate waits until an object of the bundle reaches a condition
+type ReadinessGate struct {
+	Kind      string          `json:"kind"`
+	Name      string          `json:"name"`
+	Condition string          `json:"condition"`
+	Timeout   metav1.Duration `json:"timeout,omitempty"`
+}
+
+func (g ReadinessGate) String() string {
+	return fmt.Sprintf("%s %s %s", g.Kind, g.Name, g.Condition)
+}
+
+// ParamRef points at a field of an object of the bundle, e.g.
+// spec.maxReplicas of the HorizontalPodAutoscaler in hpa-trigger.yaml
+type ParamRef struct {
+	File string `json:"file"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// parseBundleDescriptor decodes a rendered descriptor and checks it against
+// the files of the bundle
+func parseBundleDescriptor(content []byte, files map[string][]byte) (BundleDescriptor, error) {
+	var descriptor BundleDescriptor
+	if err := utilyaml.Unmarshal(content, &descriptor); err != nil {
+		return BundleDescriptor{}, fmt.Errorf("invalid %s: %w", BundleDescriptorFile, err)
+	}
+	if len(descriptor.Apply) == 0 {
+		return BundleDescriptor{}, fmt.Errorf("%s lists no file to apply", BundleDescriptorFile)
+	}
+
+	for _, step := range descriptor.Apply {
+		if _, ok := files[step.File]; !ok {
+			return BundleDescriptor{}, fmt.Errorf("%s applies missing file %s", BundleDescriptorFile, step.File)
+		}
+		for _, gate := range step.Wait {
+			if gate.Condition == "" {
+				return BundleDescriptor{}, fmt.Errorf("%s: gate on %s %s has no condition", BundleDescriptorFile, gate.Kind, gate.Name)
+			}
+			if _, err := findBundleObject(files, "", gate.Kind, gate.Name); err != nil {
+				return BundleDescriptor{}, fmt.Errorf("%s: gate %s: %w", BundleDescriptorFile, gate, err)
+			}
+		}
+	}
+	return descriptor, nil
+}
+
+// findBundleObject decodes the object of the given kind and name from a file
+// of the bundle, or from any of them when file is empty
+func findBundleObject(files map[string][]byte, file, kind, name string) (*unstructured.Unstructured, error) {
+	for fileName, content := range files {
+		if file != "" && fileName != file {
+			continue
+		}
+		documents, err := splitManifest(content)
+		if err != nil {
+			return nil, fmt.Errorf("manifest split of %s failed: %w", fileName, err)
+		}
+		for _, doc := range documents {
+			if len(bytes.TrimSpace(doc)) == 0 {
+				continue
+			}
+			obj := &unstructured.Unstructured{}
+			if _, _, err := yamlSerializer.Decode(doc, nil, obj); err != nil {
+				return nil, fmt.Errorf("decode of %s failed: %w", fileName, err)
+			}
+			if obj.GetKind() == kind && obj.GetName() == name {
+				return obj, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no %s %s in the bundle", kind, name)
+}
+
+// resolveParams reads every parameter of the descriptor from the rendered manifests
+func resolveParams(descriptor BundleDescriptor, files map[string][]byte) (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+	for name, ref := range descriptor.Params {
+		if _, ok := files[ref.File]; !ok {
+			return nil, fmt.Errorf("param %s: missing file %s", name, ref.File)
+		}
+		obj, err := findBundleObject(files, ref.File, ref.Kind, ref.Name)
+		if err != nil {
+			return nil, fmt.Errorf("param %s: %w", name, err)
+		}
+		value, found, err := unstructured.NestedFieldNoCopy(obj.Object, strings.Split(ref.Path, ".")...)
+		if err != nil || !found {
+			return nil, fmt.Errorf("param %s: %s not set on %s %s", name, ref.Path, ref.Kind, ref.Name)
+		}
+		params[name] = value
+	}
+	return params, nil
+}
+
+// Int32Param returns an integer parameter of the bundle descriptor
+func (b *FixtureBundle) Int32Param(name string) (int32, error) {
+	value, ok := b.Params[name]
+	if !ok {
+		return 0, fmt.Errorf("param %s not declared in the %s bundle", name, b.Test)
+	}
+	var number int64
+	switch v := value.(type) {
+	case int64:
+		number = v
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("param %s is not an integer: %v", name, v)
+		}
+		number = int64(v)
+	default:
+		return 0, fmt.Errorf("param %s is not an integer: %v", name, value)
+	}
+	if number < math.MinInt32 || number > math.MaxInt32 {
+		return 0, fmt.Errorf("param %s is out of range: %d", name, number)
+	}
+	return int32(number), nil
+}
+
+// ApplyFixtureBundle applies the files of a bundle in the descriptor order,
+// waiting after each step for its readiness gates and its pause. A cancelled
+// ctx stops it at the next request or wait.
+func ApplyFixtureBundle(ctx context.Context, logger zerolog.Logger, mc *ManifestClient, bundle *FixtureBundle) error {
+	for _, step := range bundle.Descriptor.Apply {
+		logger.Info().Msgf("=== Applying %s ===", step.File)
+		if err := ApplyRawManifest(ctx, mc, bundle.Files[step.File]); err != nil {
+			return fmt.Errorf("apply of %s failed: %w", step.File, err)
+		}
+
+		for _, gate := range step.Wait {
+			obj, err := findBundleObject(bundle.Files, "", gate.Kind, gate.Name)
+			if err != nil {
+				return fmt.Errorf("gate %s: %w", gate, err)
+			}
+			if err := waitForGate(ctx, logger, mc, obj, gate); err != nil {
+				return err
+			}
+		}
+
+		if step.Pause.Duration > 0 {
+			logger.Info().Msgf("=== Pausing %s after %s ===", step.Pause.Duration, step.File)
+			if err := sleepContext(ctx, step.Pause.Duration); err != nil {
+				return fmt.Errorf("pause after %s: %w", step.File, err)
+			}
+		}
+	}
+	return nil
+}
+
+// waitForGate polls the live copy of obj until it reaches the gate condition
+func waitForGate(ctx context.Context, logger zerolog.Logger, mc *ManifestClient, obj *unstructured.Unstructured, gate ReadinessGate) error {
+	resource, err := mc.resourceFor(obj)
+	if err != nil {
+		return fmt.Errorf("gate %s: cannot resolve %s: %w", gate, obj.GroupVersionKind(), err)
+	}
+
+	timeout := gate.Timeout.Duration
+	if timeout == 0 {
+		timeout = defaultGateTimeout
+	}
+	logger.Info().Msgf("=== Waiting up to %s for %s ===", timeout, gate)
+
+	status := "not checked yet"
+	err = wait.PollUntilContextTimeout(ctx, gatePollInterval, timeout, true, func(ctx context.Context) (bool, error) {
+		live, err := resource.Get(ctx, gate.Name, metav1.GetOptions{})
+		if err != nil {
+			// A get cut short by the timeout keeps the last status
+			if ctx.Err() == nil {
+				status = fmt.Sprintf("get failed: %v", err)
+				logger.Info().Msgf("Waiting for %s: %s", gate, status)
+			}
+			return false, nil
+		}
+		var ready bool
+		if ready, status = GateSatisfied(live, gate.Condition); !ready {
+			logger.Info().Msgf("Waiting for %s: %s", gate, status)
+		}
+		return ready, nil
+	})
+	switch {
+	case err == nil:
+		logger.Info().Msgf("Gate %s passed: %s", gate, status)
+		return nil
+	case ctx.Err() != nil:
+		return fmt.Errorf("gate %s not reached: %w: %s", gate, ctx.Err(), status)
+	}
+	return fmt.Errorf("gate %s not reached within %s: %s", gate, timeout, status)
+}
+
+// GateSatisfied tells whether a live object reached a gate condition, with a
+// short status for the logs
+func GateSatisfied(live *unstructured.Unstructured, condition string) (bool, string) {
+	if condition == GateReady {
+		if replicas, found, _ := unstructured.NestedInt64(live.Object, "spec", "replicas"); found || isWorkloadKind(live.GetKind()) {
+			if !found {
+				replicas = 1
+			}
+			ready, _, _ := unstructured.NestedInt64(live.Object, "status", "readyReplicas")
+			observed, _, _ := unstructured.NestedInt64(live.Object, "status", "observedGeneration")
+			status := fmt.Sprintf("%d/%d replicas ready, generation %d observed %d", ready, replicas, live.GetGeneration(), observed)
+			return ready >= replicas && observed >= live.GetGeneration(), status
+		}
+	}
+
+	conditions, _, _ := unstructured.NestedSlice(live.Object, "status", "conditions")
+	for _, item := range conditions {
+		entry, ok := item.(map[string]interface{})
+		if !ok || entry["type"] != condition {
+			continue
+		}
+		status := fmt.Sprintf("condition %s=%v", condition, entry["status"])
+		if message, ok := entry["message"].(string); ok && message != "" {
+			status += fmt.Sprintf(" (%s)", message)
+		}
+		return entry["status"] == "True", status
+	}
+	return false, fmt.Sprintf("condition %s not reported yet", condition)
+}
+
+func isWorkloadKind(kind string) bool {
+	switch kind {
+	case "Deployment", "StatefulSet", "ReplicaSet":
+		return true
+	}
+	return false
+}
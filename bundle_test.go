@@ -0,0 +1,192 @@
+package example_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"example"
+)
+
+func liveObject(kind string, generation int64, spec, status map[string]interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"name": "app", "generation": generation},
+		"spec":       spec,
+		"status":     status,
+	}}
+}
+
+var _ = ginkgo.Describe("Fixture bundles", ginkgo.Label("unit"), func() {
+	values := example.ManifestValues{
+		Namespace:   "bundle-ns",
+		Image:       "nginx:alpine",
+		TopologyKey: "topology.kubernetes.io/zone",
+	}
+
+	ginkgo.It("should load the descriptor and params of every embedded bundle", func() {
+		for _, testTag := range []string{
+			"DeploymentAffinityTest", "StatefulSetAffinityTest",
+			"DeploymentAntiAffinityTest", "StatefulSetAntiAffinityTest",
+			"DeploymentPDBTest", "StatefulSetPDBTest",
+			"DeploymentRollingUpdateTest", "StatefulSetRollingUpdateTest",
+			"DeploymentTopologyConstraitTest", "StatefulSetTopologyConstraitTest",
+		} {
+			fixtures, err := example.LoadFixtureBundle(testTag, values)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred(), testTag)
+			gomega.Expect(fixtures.Files).NotTo(gomega.HaveKey(example.BundleDescriptorFile), testTag)
+			gomega.Expect(fixtures.Descriptor.Apply).NotTo(gomega.BeEmpty(), testTag)
+		}
+
+		fixtures, err := example.LoadFixtureBundle("StatefulSetPDBTest", values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(fixtures.Descriptor.Apply[1].Wait).To(gomega.HaveLen(1))
+		gomega.Expect(fixtures.Descriptor.Apply[1].Wait[0].String()).To(gomega.Equal("StatefulSet app Ready"))
+		gomega.Expect(fixtures.Int32Param("minAvailable")).To(gomega.BeNumerically(">", 0))
+
+		_, err = fixtures.Int32Param("maxReplicas")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("param maxReplicas not declared in the StatefulSetPDBTest bundle")))
+	})
+
+	ginkgo.It("should reject descriptors pointing outside the bundle", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		bundleDir := filepath.Join(dir, "rolling_update_deployment_test_yamls")
+		gomega.Expect(os.Mkdir(bundleDir, 0755)).To(gomega.Succeed())
+		descriptor := filepath.Join(bundleDir, example.BundleDescriptorFile)
+		ginkgo.GinkgoT().Setenv("FIXTURES_DIR", dir)
+
+		gomega.Expect(os.WriteFile(descriptor, []byte("apply:\n- file: missing.yaml\n"), 0644)).To(gomega.Succeed())
+		_, err := example.LoadFixtureBundle("DeploymentRollingUpdateTest", values)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("bundle.yaml applies missing file missing.yaml")))
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(descriptor)))
+
+		gomega.Expect(os.WriteFile(descriptor, []byte("apply:\n- file: deployment_start.yaml\n  wait:\n  - kind: Deployment\n    name: other\n    condition: Available\n"), 0644)).To(gomega.Succeed())
+		_, err = example.LoadFixtureBundle("DeploymentRollingUpdateTest", values)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("gate Deployment other Available: no Deployment other in the bundle")))
+
+		gomega.Expect(os.WriteFile(descriptor, []byte("apply:\n- file: deployment_start.yaml\nparams:\n  replicas:\n    file: deployment_start.yaml\n    kind: Deployment\n    name: app\n    path: spec.paused\n"), 0644)).To(gomega.Succeed())
+		_, err = example.LoadFixtureBundle("DeploymentRollingUpdateTest", values)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("param replicas: spec.paused not set on Deployment app")))
+	})
+
+	ginkgo.It("should tell when a live object reached its gate", func() {
+		ready, status := example.GateSatisfied(liveObject("StatefulSet", 2,
+			map[string]interface{}{"replicas": int64(3)},
+			map[string]interface{}{"readyReplicas": int64(3), "observedGeneration": int64(1)}), example.GateReady)
+		gomega.Expect(ready).To(gomega.BeFalse())
+		gomega.Expect(status).To(gomega.Equal("3/3 replicas ready, generation 2 observed 1"))
+
+		ready, _ = example.GateSatisfied(liveObject("StatefulSet", 2,
+			map[string]interface{}{"replicas": int64(3)},
+			map[string]interface{}{"readyReplicas": int64(3), "observedGeneration": int64(2)}), example.GateReady)
+		gomega.Expect(ready).To(gomega.BeTrue())
+
+		deployment := liveObject("Deployment", 1, map[string]interface{}{}, map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Progressing", "status": "True"},
+				map[string]interface{}{"type": "Available", "status": "False", "message": "Deployment does not have minimum availability."},
+			},
+		})
+		ready, status = example.GateSatisfied(deployment, "Available")
+		gomega.Expect(ready).To(gomega.BeFalse())
+		gomega.Expect(status).To(gomega.Equal("condition Available=False (Deployment does not have minimum availability.)"))
+
+		ready, status = example.GateSatisfied(deployment, "Complete")
+		gomega.Expect(ready).To(gomega.BeFalse())
+		gomega.Expect(status).To(gomega.Equal("condition Complete not reported yet"))
+	})
+
+	// bundleClient applies with a fake server-side apply and reports the live
+	// Deployment with the given status, it returns the applied objects in order
+	bundleClient := func(available string) (*example.ManifestClient, *[]string) {
+		deploymentGVR := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+		mapper := meta.NewDefaultRESTMapper(nil)
+		mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, meta.RESTScopeNamespace)
+		mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+
+		dynamicClient := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+		var applied []string
+		dynamicClient.PrependReactor("patch", "*", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			patch := action.(clienttesting.PatchAction)
+			if patch.GetPatchType() != types.ApplyPatchType {
+				return false, nil, nil
+			}
+			obj := &unstructured.Unstructured{}
+			if err := obj.UnmarshalJSON(patch.GetPatch()); err != nil {
+				return true, nil, err
+			}
+			applied = append(applied, obj.GetKind()+" "+obj.GetName())
+			_, err := dynamicClient.Tracker().Get(patch.GetResource(), patch.GetNamespace(), patch.GetName())
+			if apierrors.IsNotFound(err) {
+				return true, obj, dynamicClient.Tracker().Create(patch.GetResource(), obj, patch.GetNamespace())
+			}
+			return true, obj, dynamicClient.Tracker().Update(patch.GetResource(), obj, patch.GetNamespace())
+		})
+		// The gate reads the live Deployment
+		dynamicClient.PrependReactor("get", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			live, err := dynamicClient.Tracker().Get(deploymentGVR, action.GetNamespace(), "app")
+			if err != nil {
+				return true, nil, err
+			}
+			obj := live.(*unstructured.Unstructured).DeepCopy()
+			err = unstructured.SetNestedSlice(obj.Object, []interface{}{
+				map[string]interface{}{"type": "Available", "status": available},
+			}, "status", "conditions")
+			return true, obj, err
+		})
+
+		return &example.ManifestClient{Dynamic: dynamicClient, Mapper: mapper}, &applied
+	}
+
+	ginkgo.It("should apply the steps in order and wait for their gates", func(ctx ginkgo.SpecContext) {
+		manifestClient, applied := bundleClient("True")
+
+		fixtures := &example.FixtureBundle{
+			Test: "OrderTest",
+			Files: map[string][]byte{
+				"app.yaml":    []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: bundle-ns\n"),
+				"config.yaml": []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n  namespace: bundle-ns\n"),
+			},
+			Descriptor: example.BundleDescriptor{Apply: []example.ApplyStep{
+				{File: "config.yaml"},
+				{File: "app.yaml", Wait: []example.ReadinessGate{{Kind: "Deployment", Name: "app", Condition: "Available"}}},
+			}},
+		}
+
+		gomega.Expect(example.ApplyFixtureBundle(ctx, zerolog.Nop(), manifestClient, fixtures)).To(gomega.Succeed())
+		gomega.Expect(*applied).To(gomega.Equal([]string{"ConfigMap config", "Deployment app"}))
+	})
+
+	ginkgo.It("should stop waiting for a gate when the context is done", func(ctx ginkgo.SpecContext) {
+		manifestClient, _ := bundleClient("False")
+		fixtures := &example.FixtureBundle{
+			Test:  "GateTest",
+			Files: map[string][]byte{"app.yaml": []byte("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: app\n  namespace: bundle-ns\n")},
+			Descriptor: example.BundleDescriptor{Apply: []example.ApplyStep{
+				{File: "app.yaml", Wait: []example.ReadinessGate{{Kind: "Deployment", Name: "app", Condition: "Available"}}},
+			}},
+		}
+
+		cancelled, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		started := time.Now()
+		err := example.ApplyFixtureBundle(cancelled, zerolog.Nop(), manifestClient, fixtures)
+		gomega.Expect(err).To(gomega.MatchError(context.DeadlineExceeded))
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("condition Available=False")))
+		gomega.Expect(time.Since(started)).To(gomega.BeNumerically("<", 5*time.Second))
+	})
+})
@@ -0,0 +1,448 @@
+package example
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// ClusterPlan lists the kubeconfig contexts of KUBE_CONTEXTS, the test binary
+// runs the suite once per context, one after the other unless Parallel
+type ClusterPlan struct {
+	Contexts []string
+	Parallel bool
+	// Timeout is the SUITE_TIMEOUT budget of the whole fan-out, each run gets
+	// what is left of it
+	Timeout time.Duration
+}
+
+// LoadClusterPlan reads KUBE_CONTEXTS, KUBE_CONTEXTS_PARALLEL and SUITE_TIMEOUT from envFile and the environment
+func LoadClusterPlan(envFile string) (ClusterPlan, error) {
+	err := godotenv.Load(envFile)
+	if err != nil && !os.IsNotExist(err) {
+		return ClusterPlan{}, fmt.Errorf("error loading %s file: %w", envFile, err)
+	}
+	return clusterPlanFromEnv()
+}
+
+func clusterPlanFromEnv() (ClusterPlan, error) {
+	plan := ClusterPlan{Contexts: splitList(os.Getenv("KUBE_CONTEXTS"))}
+	if value := os.Getenv("KUBE_CONTEXTS_PARALLEL"); value != "" {
+		parallel, err := strconv.ParseBool(value)
+		if err != nil {
+			return ClusterPlan{}, &ConfigError{Setting: "KUBE_CONTEXTS_PARALLEL", Err: ErrInvalidSetting,
+				Detail: fmt.Sprintf("must be true or false, got %q", value)}
+		}
+		plan.Parallel = parallel
+	}
+	timeout, err := getEnvDuration("SUITE_TIMEOUT")
+	if err != nil {
+		return ClusterPlan{}, err
+	}
+	plan.Timeout = timeout
+	seen := make(map[string]bool)
+	for _, context := range plan.Contexts {
+		if seen[context] {
+			return ClusterPlan{}, &ConfigError{Setting: "KUBE_CONTEXTS", Err: ErrInvalidSetting,
+				Detail: fmt.Sprintf("context %q is listed twice", context)}
+		}
+		seen[context] = true
+	}
+	return plan, nil
+}
+
+// ClusterResult is the outcome of the suite on one cluster
+type ClusterResult struct {
+	Context    string       `json:"context"`
+	ExitCode   int          `json:"exit_code"`
+	ReportFile string       `json:"report_file,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Report     *FinalReport `json:"report,omitempty"`
+}
+
+// ClustersReport keys the final report of every cluster by its context. Tests
+// maps each test tag to its result per context, so the clusters compare side
+// by side.
+type ClustersReport struct {
+	TestTimestamp string                       `json:"test_timestamp"`
+	Outcome       string                       `json:"outcome"`
+	Contexts      []string                     `json:"contexts"`
+	Tests         map[string]map[string]string `json:"tests"`
+	Clusters      map[string]*ClusterResult    `json:"clusters"`
+}
+
+// Results of a test on one cluster, in the tests field of the clusters report
+const (
+	ClusterTestPassed        = "passed"
+	ClusterTestFailed        = "failed"
+	ClusterTestAllowedToFail = "allowed to fail"
+	ClusterTestSkipped       = "skipped"
+)
+
+// BuildClustersReport compares the results of the clusters, in plan order
+func BuildClustersReport(results []*ClusterResult) ClustersReport {
+	clustersReport := ClustersReport{
+		TestTimestamp: time.Now().Format("01/02/2006 15:04:05"),
+		Outcome:       OutcomePassed,
+		Contexts:      []string{},
+		Tests:         make(map[string]map[string]string),
+		Clusters:      make(map[string]*ClusterResult),
+	}
+
+	for _, result := range results {
+		clustersReport.Contexts = append(clustersReport.Contexts, result.Context)
+		clustersReport.Clusters[result.Context] = result
+		if result.ExitCode != 0 || result.Report == nil {
+			clustersReport.Outcome = OutcomeFailed
+		}
+		if result.Report == nil {
+			continue
+		}
+
+		for state, tags := range map[string][]string{
+			ClusterTestPassed:        result.Report.SucceedingTests,
+			ClusterTestFailed:        result.Report.FailedButNotAllowed,
+			ClusterTestAllowedToFail: result.Report.AllowedToFailTests,
+			ClusterTestSkipped:       result.Report.SkippedTests,
+		} {
+			for _, tag := range tags {
+				if clustersReport.Tests[tag] == nil {
+					clustersReport.Tests[tag] = make(map[string]string)
+				}
+				clustersReport.Tests[tag][result.Context] = state
+			}
+		}
+	}
+
+	return clustersReport
+}
+
+// RunClusterPlan runs command once per context of the plan, each run writing
+// its reports to its own directory under ReportDir, then compares them in a
+// clusters report. It returns the report and the exit code of the fan-out,
+// non-zero when any cluster run failed. SIGTERM is forwarded to the running
+// runs so they clean up and write their reports, no run starts after a signal.
+func RunClusterPlan(plan ClusterPlan, command func(context string) *exec.Cmd) (ClustersReport, int) {
+	logger := GetLogger(SetupTag)
+	results := make([]*ClusterResult, len(plan.Contexts))
+	var output sync.Mutex
+	var deadline time.Time
+	if plan.Timeout > 0 {
+		deadline = time.Now().Add(plan.Timeout)
+	}
+
+	runs := &clusterRuns{running: make(map[*os.Process]bool)}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	defer func() {
+		signal.Stop(signals)
+		close(done)
+	}()
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				logger.Warn().Msgf("Received %v, stopping the cluster runs", sig)
+				runs.stop(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	run := func(i int) {
+		results[i] = runCluster(plan.Contexts[i], command, deadline, runs, &output)
+	}
+
+	if plan.Parallel {
+		var wg sync.WaitGroup
+		for i := range plan.Contexts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				run(i)
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i := range plan.Contexts {
+			run(i)
+		}
+	}
+
+	clustersReport := BuildClustersReport(results)
+	filename := filepath.Join(ReportDir, fmt.Sprintf("clusters_report_%s.json", time.Now().Format("20060102-150405")))
+	jsonData, err := json.MarshalIndent(clustersReport, "", " ")
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to serialize the clusters report")
+	} else if err := os.WriteFile(filename, jsonData, 0644); err != nil {
+		logger.Error().Err(err).Msg("Failed to write the clusters report")
+	} else {
+		logger.Info().Str("file", filename).Msg("Clusters report written successfully")
+	}
+
+	printClustersReport(os.Stdout, clustersReport)
+
+	if clustersReport.Outcome != OutcomePassed {
+		return clustersReport, 1
+	}
+	return clustersReport, 0
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ClusterReportDir is the report directory of the run against context, EKS
+// style contexts (arn:aws:eks:...:cluster/name) are flattened into one name
+func ClusterReportDir(context string) string {
+	return filepath.Join(ReportDir, unsafePathChars.ReplaceAllString(context, "_"))
+}
+
+// clusterRuns tracks the running runs of a fan-out, to forward them SIGTERM
+type clusterRuns struct {
+	mu      sync.Mutex
+	running map[*os.Process]bool
+	stopped bool
+}
+
+// start starts cmd unless the fan-out was stopped
+func (r *clusterRuns) start(cmd *exec.Cmd) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return fmt.Errorf("not run, the fan-out was interrupted")
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	r.running[cmd.Process] = true
+	return nil
+}
+
+func (r *clusterRuns) done(cmd *exec.Cmd) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.running, cmd.Process)
+}
+
+// stop keeps the next runs from starting. A terminal sends Ctrl-C to the
+// running runs itself, forwarding it would skip their cleanup, so only
+// SIGTERM is forwarded.
+func (r *clusterRuns) stop(sig os.Signal) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stopped = true
+	if sig != syscall.SIGTERM {
+		return
+	}
+	for process := range r.running {
+		process.Signal(sig)
+	}
+}
+
+func runCluster(context string, command func(context string) *exec.Cmd, deadline time.Time, runs *clusterRuns, output *sync.Mutex) *ClusterResult {
+	logger := GetLogger(SetupTag)
+	result := &ClusterResult{Context: context}
+	dir := ClusterReportDir(context)
+	started := time.Now()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		result.ExitCode = 1
+		result.Error = fmt.Sprintf("report directory: %v", err)
+		return result
+	}
+
+	cmd := command(context)
+	// The run tests the one context, with its own reports
+	cmd.Env = append(os.Environ(), "KUBE_CONTEXT="+context, "KUBE_CONTEXTS=", "REPORT_DIR="+dir)
+	if !deadline.IsZero() {
+		remaining := time.Until(deadline).Round(time.Second)
+		if remaining <= 0 {
+			result.ExitCode = 1
+			result.Error = "not run, SUITE_TIMEOUT elapsed"
+			return result
+		}
+		cmd.Env = append(cmd.Env, "SUITE_TIMEOUT="+remaining.String())
+	}
+	out := &prefixWriter{out: os.Stdout, prefix: []byte("[" + context + "] "), mu: output}
+	cmd.Stdout, cmd.Stderr = out, out
+
+	logger.Info().Msgf("=== Running the suite against context %s, reports in %s ===", context, dir)
+	err := runs.start(cmd)
+	if err != nil {
+		result.ExitCode = 1
+		result.Error = err.Error()
+		return result
+	}
+	err = cmd.Wait()
+	runs.done(cmd)
+	out.Flush()
+	if err != nil {
+		result.ExitCode = 1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+		} else {
+			result.Error = err.Error()
+		}
+	}
+
+	result.ReportFile, result.Report, err = readClusterReport(dir, started)
+	if err != nil && result.Error == "" {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// readClusterReport returns the newest final report written to dir since started
+func readClusterReport(dir string, started time.Time) (string, *FinalReport, error) {
+	paths, _ := filepath.Glob(filepath.Join(dir, "test_suite_log_*.json"))
+	sort.Strings(paths)
+	for i := len(paths) - 1; i >= 0; i-- {
+		info, err := os.Stat(paths[i])
+		if err != nil || info.ModTime().Before(started.Truncate(time.Second)) {
+			continue
+		}
+		content, err := os.ReadFile(paths[i])
+		if err != nil {
+			return "", nil, err
+		}
+		var finalReport FinalReport
+		if err := json.Unmarshal(content, &finalReport); err != nil {
+			return "", nil, fmt.Errorf("%s: %w", paths[i], err)
+		}
+		return paths[i], &finalReport, nil
+	}
+	return "", nil, fmt.Errorf("no final report written to %s", dir)
+}
+
+func printClustersReport(w io.Writer, clustersReport ClustersReport) {
+	var tags []string
+	for tag := range clustersReport.Tests {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	fmt.Fprintf(w, "\n=== Clusters Summary ===\n")
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "TEST\t%s\n", strings.Join(clustersReport.Contexts, "\t"))
+	for _, tag := range tags {
+		row := []string{tag}
+		for _, context := range clustersReport.Contexts {
+			row = append(row, getOrDefault(clustersReport.Tests[tag], context, "-"))
+		}
+		fmt.Fprintln(table, strings.Join(row, "\t"))
+	}
+	row := []string{"(exit code)"}
+	for _, context := range clustersReport.Contexts {
+		row = append(row, strconv.Itoa(clustersReport.Clusters[context].ExitCode))
+	}
+	fmt.Fprintln(table, strings.Join(row, "\t"))
+	table.Flush()
+
+	for _, context := range clustersReport.Contexts {
+		if result := clustersReport.Clusters[context]; result.Error != "" {
+			fmt.Fprintf(w, "%s: %s\n", context, result.Error)
+		} else if result.Report != nil && result.Report.SetupError != "" {
+			fmt.Fprintf(w, "%s: %s\n", context, result.Report.SetupError)
+		}
+	}
+}
+
+func getOrDefault(values map[string]string, key, fallback string) string {
+	if value, ok := values[key]; ok {
+		return value
+	}
+	return fallback
+}
+
+// prefixWriter prefixes every line with the context it comes from, the runs
+// of a parallel fan-out share one mutex so their lines do not interleave
+type prefixWriter struct {
+	out    io.Writer
+	prefix []byte
+	mu     *sync.Mutex
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.writeLine(w.buf[:i+1])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// Flush writes the last line when it has no newline
+func (w *prefixWriter) Flush() {
+	if len(w.buf) > 0 {
+		w.writeLine(append(w.buf, '\n'))
+		w.buf = nil
+	}
+}
+
+func (w *prefixWriter) writeLine(line []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out.Write(append(append([]byte{}, w.prefix...), line...))
+}
+
+// FanOutClusters runs the test binary once per context of KUBE_CONTEXTS, with
+// the same arguments. ran is false when there is no context to fan out to,
+// under ginkgo -p where each parallel process would fan out again, and for
+// dry runs and label filters that select the unit specs only, which never
+// talk to a cluster.
+func FanOutClusters(args []string) (code int, ran bool) {
+	var labelFilter string
+	for i := 1; i < len(args); i++ {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		switch {
+		case strings.HasPrefix(name, "ginkgo.parallel.process"):
+			return 0, false
+		case name == "ginkgo.dry-run":
+			if dryRun, err := strconv.ParseBool(value); !hasValue || (err == nil && dryRun) {
+				return 0, false
+			}
+		case name == "ginkgo.label-filter":
+			if !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			labelFilter = value
+		}
+	}
+	if !needsCluster(labelFilter) {
+		return 0, false
+	}
+	// An invalid plan is reported by the suite configuration as a setup failure
+	plan, err := LoadClusterPlan(".env")
+	if err != nil || len(plan.Contexts) == 0 {
+		return 0, false
+	}
+
+	_, code = RunClusterPlan(plan, func(string) *exec.Cmd {
+		return exec.Command(args[0], args[1:]...)
+	})
+	return code, true
+}
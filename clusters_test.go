@@ -0,0 +1,124 @@
+package example_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("Cluster fan-out", ginkgo.Label("unit"), func() {
+	ginkgo.BeforeEach(func() {
+		reportDir := example.ReportDir
+		example.ReportDir = ginkgo.GinkgoT().TempDir()
+		ginkgo.DeferCleanup(func() { example.ReportDir = reportDir })
+	})
+
+	ginkgo.It("should read the contexts to fan out to", func() {
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXTS", "staging, prod-eu,,prod-us")
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXTS_PARALLEL", "true")
+
+		plan, err := example.LoadClusterPlan(filepath.Join(example.ReportDir, ".env"))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(plan).To(gomega.Equal(example.ClusterPlan{Contexts: []string{"staging", "prod-eu", "prod-us"}, Parallel: true}))
+
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXTS", "staging,staging")
+		_, err = example.LoadClusterPlan(filepath.Join(example.ReportDir, ".env"))
+		gomega.Expect(err).To(gomega.MatchError(example.ErrInvalidSetting))
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(`context "staging" is listed twice`)))
+	})
+
+	ginkgo.It("should not fan out the runs that never talk to a cluster", func() {
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXTS", "staging,prod-eu")
+
+		for _, args := range [][]string{
+			{"cluster-tester", "-ginkgo.label-filter=unit"},
+			{"cluster-tester", "--ginkgo.label-filter", "unit && !slow"},
+			{"cluster-tester", "-ginkgo.dry-run", "-ginkgo.label-filter=!unit"},
+			{"cluster-tester", "-ginkgo.parallel.process=2"},
+		} {
+			_, ran := example.FanOutClusters(args)
+			gomega.Expect(ran).To(gomega.BeFalse(), "%v", args)
+		}
+	})
+
+	ginkgo.It("should run once per context and key the reports by cluster", func() {
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXTS", "staging,prod-eu")
+		scripts := map[string]string{
+			"staging": `printf '{"outcome":"passed","succeeding_tests":["DeploymentPDBTest"],"skipped_tests":["SimpleConnectivityTest"]}' > "$REPORT_DIR/test_suite_log_1.json"`,
+			"arn:aws:eks:eu-west-1:1234:cluster/prod-eu": `printf '{"outcome":"failed","failed_but_not_allowed_to_fail":["DeploymentPDBTest"],"succeeding_tests":["SimpleConnectivityTest"]}' > "$REPORT_DIR/test_suite_log_1.json"; exit 1`,
+			"prod-us": `exit 3`,
+		}
+		plan := example.ClusterPlan{
+			Contexts: []string{"staging", "arn:aws:eks:eu-west-1:1234:cluster/prod-eu", "prod-us"},
+			Parallel: true,
+		}
+
+		clustersReport, code := example.RunClusterPlan(plan, func(context string) *exec.Cmd {
+			// Every run tests only its own context
+			return exec.Command("sh", "-c", `[ "$KUBE_CONTEXT" = "`+context+`" ] && [ -z "$KUBE_CONTEXTS" ] || exit 9; `+scripts[context])
+		})
+
+		gomega.Expect(code).To(gomega.Equal(1))
+		gomega.Expect(clustersReport.Outcome).To(gomega.Equal(example.OutcomeFailed))
+		gomega.Expect(clustersReport.Contexts).To(gomega.Equal(plan.Contexts))
+		gomega.Expect(clustersReport.Tests).To(gomega.Equal(map[string]map[string]string{
+			"DeploymentPDBTest": {
+				"staging": example.ClusterTestPassed,
+				"arn:aws:eks:eu-west-1:1234:cluster/prod-eu": example.ClusterTestFailed,
+			},
+			"SimpleConnectivityTest": {
+				"staging": example.ClusterTestSkipped,
+				"arn:aws:eks:eu-west-1:1234:cluster/prod-eu": example.ClusterTestPassed,
+			},
+		}))
+
+		staging := clustersReport.Clusters["staging"]
+		gomega.Expect(staging.ExitCode).To(gomega.Equal(0))
+		gomega.Expect(staging.ReportFile).To(gomega.Equal(filepath.Join(example.ReportDir, "staging", "test_suite_log_1.json")))
+		gomega.Expect(clustersReport.Clusters["arn:aws:eks:eu-west-1:1234:cluster/prod-eu"].ReportFile).
+			To(gomega.HavePrefix(filepath.Join(example.ReportDir, "arn_aws_eks_eu-west-1_1234_cluster_prod-eu")))
+
+		prodUS := clustersReport.Clusters["prod-us"]
+		gomega.Expect(prodUS.ExitCode).To(gomega.Equal(3))
+		gomega.Expect(prodUS.Report).To(gomega.BeNil())
+		gomega.Expect(prodUS.Error).To(gomega.ContainSubstring("no final report written"))
+
+		reports, err := filepath.Glob(filepath.Join(example.ReportDir, "clusters_report_*.json"))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(reports).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("should ignore the reports of earlier runs", func() {
+		dir := example.ClusterReportDir("staging")
+		gomega.Expect(os.MkdirAll(dir, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(dir, "test_suite_log_0.json"), []byte(`{"outcome":"passed"}`), 0644)).To(gomega.Succeed())
+		old := filepath.Join(dir, "test_suite_log_0.json")
+		gomega.Expect(os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))).To(gomega.Succeed())
+
+		clustersReport, code := example.RunClusterPlan(example.ClusterPlan{Contexts: []string{"staging"}}, func(string) *exec.Cmd {
+			return exec.Command("sh", "-c", "exit 0")
+		})
+
+		gomega.Expect(code).To(gomega.Equal(1))
+		gomega.Expect(clustersReport.Clusters["staging"].Report).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should share SUITE_TIMEOUT between the runs", func() {
+		plan := example.ClusterPlan{Contexts: []string{"staging", "prod-eu"}, Timeout: time.Second}
+
+		clustersReport, code := example.RunClusterPlan(plan, func(string) *exec.Cmd {
+			// The first run gets the whole budget and spends it
+			return exec.Command("sh", "-c", `[ "$SUITE_TIMEOUT" = "1s" ] || exit 9; sleep 1`)
+		})
+
+		gomega.Expect(code).To(gomega.Equal(1))
+		gomega.Expect(clustersReport.Clusters["staging"].ExitCode).To(gomega.Equal(0))
+		gomega.Expect(clustersReport.Clusters["prod-eu"].Error).To(gomega.Equal("not run, SUITE_TIMEOUT elapsed"))
+	})
+})
@@ -0,0 +1,439 @@
+package example
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/homedir"
+)
+
+// Configuration errors, returned wrapped in a ConfigError naming the setting at fault
+var (
+	ErrInvalidAccessMode  = errors.New("invalid access mode")
+	ErrMissingCredential  = errors.New("missing credential")
+	ErrInvalidCredential  = errors.New("invalid credential")
+	ErrKubeconfigNotFound = errors.New("kubeconfig not found")
+	ErrInvalidSetting     = errors.New("invalid setting")
+)
+
+// ConfigError is a configuration problem found before any test could run.
+// Setting is the environment variable or file at fault, errors.Is matches
+// both the Err sentinel and the underlying Cause.
+type ConfigError struct {
+	Setting string
+	Err     error
+	Detail  string
+	Cause   error
+}
+
+func (e *ConfigError) Error() string {
+	message := fmt.Sprintf("%s: %v", e.Setting, e.Err)
+	if e.Detail != "" {
+		message += ": " + e.Detail
+	}
+	if e.Cause != nil {
+		message += ": " + e.Cause.Error()
+	}
+	return message
+}
+
+func (e *ConfigError) Unwrap() []error {
+	if e.Cause == nil {
+		return []error{e.Err}
+	}
+	return []error{e.Err, e.Cause}
+}
+
+// SuiteConfig is the configuration of a run, read once per process from the
+// environment and .env. Every spec shares its clients.
+type SuiteConfig struct {
+	AccessMode string
+	// KubeconfigPath and Context are the kubeconfig and context used in
+	// KUBECONFIG access mode, an empty Context is the current context
+	KubeconfigPath string
+	Context        string
+	// Auth describes the credentials in use, without any secret
+	Auth string
+	// Rest is the API server and credentials of the access mode, with QPS,
+	// Burst, Timeout and MaxRetries applied
+	Rest *rest.Config
+	// QPS and Burst limit the client side request rate, zero keeps the client-go defaults
+	QPS   float32
+	Burst int
+	// Timeout bounds every API request but watches and followed logs, retries
+	// included, zero means no timeout
+	Timeout time.Duration
+	// MaxRetries is the number of retries of a transiently failed API request, zero disables them
+	MaxRetries int
+	// SuiteTimeout is the SUITE_TIMEOUT budget of the whole suite, zero keeps the Ginkgo default
+	SuiteTimeout  time.Duration
+	AllowedToFail []string
+	// Values are the manifest values read from the environment, Namespace is left empty
+	Values ManifestValues
+
+	Clientset      kubernetes.Interface
+	ManifestClient *ManifestClient
+}
+
+var (
+	configMu     sync.Mutex
+	loadedConfig *SuiteConfig
+	configErr    error
+	configLoaded bool
+)
+
+// Config returns the suite configuration, loaded from .env and the
+// environment on first use. Later calls return the same configuration, or
+// the same error.
+func Config() (*SuiteConfig, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	if !configLoaded {
+		loadedConfig, configErr = LoadSuiteConfig(".env")
+		configLoaded = true
+		if configErr == nil {
+			AllowedToFailTags = loadedConfig.AllowedToFail
+		}
+	}
+	return loadedConfig, configErr
+}
+
+// SetSuiteConfig replaces the configuration returned by Config, so specs can
+// run against a fake clientset. A nil config makes the next Config call load
+// it again.
+func SetSuiteConfig(config *SuiteConfig) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	loadedConfig, configErr, configLoaded = config, nil, config != nil
+}
+
+// CheckConfiguration loads the suite configuration, returning the first configuration error
+func CheckConfiguration() error {
+	_, err := Config()
+	return err
+}
+
+// LoadSuiteTimeout reads SUITE_TIMEOUT from envFile and the environment, the
+// suite configuration reports an invalid value as a setup failure
+func LoadSuiteTimeout(envFile string) (time.Duration, error) {
+	err := godotenv.Load(envFile)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("error loading %s file: %w", envFile, err)
+	}
+	return getEnvDuration("SUITE_TIMEOUT")
+}
+
+// LoadSuiteConfig reads the configuration from envFile and the environment,
+// the environment takes precedence. It validates every setting and builds
+// the clients, without contacting the cluster.
+func LoadSuiteConfig(envFile string) (*SuiteConfig, error) {
+	err := godotenv.Load(envFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error loading %s file: %w", envFile, err)
+	}
+	envFileFound := err == nil
+
+	config := &SuiteConfig{
+		AccessMode:    getEnvOrDefault("ACCESS_MODE", AccessModeAuto),
+		Context:       os.Getenv("KUBE_CONTEXT"),
+		AllowedToFail: splitList(os.Getenv("ALLOWED_TO_FAIL")),
+	}
+	// The fan-out clears KUBE_CONTEXTS in the runs it starts, one per context
+	plan, err := clusterPlanFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.Contexts) > 0 {
+		return nil, &ConfigError{Setting: "KUBE_CONTEXTS", Err: ErrInvalidSetting,
+			Detail: "the test binary runs once per context itself, it cannot run under ginkgo -p, set KUBE_CONTEXT to test one cluster"}
+	}
+	config.SuiteTimeout = plan.Timeout
+	if config.Context != "" && config.AccessMode != "KUBECONFIG" && config.AccessMode != AccessModeAuto {
+		return nil, &ConfigError{Setting: "KUBE_CONTEXT", Err: ErrInvalidSetting, Detail: "only used with ACCESS_MODE=KUBECONFIG"}
+	}
+	if config.QPS, err = getEnvFloat32("K8S_QPS"); err != nil {
+		return nil, err
+	}
+	burst, err := getEnvInt32("K8S_BURST")
+	if err != nil {
+		return nil, err
+	}
+	config.Burst = int(burst)
+	if config.Timeout, err = getEnvDuration("K8S_TIMEOUT"); err != nil {
+		return nil, err
+	}
+	config.MaxRetries = DefaultMaxRetries
+	if os.Getenv("K8S_MAX_RETRIES") != "" {
+		maxRetries, err := getEnvInt32("K8S_MAX_RETRIES")
+		if err != nil {
+			return nil, err
+		}
+		config.MaxRetries = int(maxRetries)
+	}
+	if config.Values, err = manifestValuesFromEnv(); err != nil {
+		return nil, err
+	}
+
+	if config.AccessMode == AccessModeAuto {
+		err = detectAccessMode(config)
+	} else {
+		err = setAccessMode(config, envFileFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if config.QPS > 0 {
+		config.Rest.QPS = config.QPS
+	}
+	if config.Burst > 0 {
+		config.Rest.Burst = config.Burst
+	}
+	// The transport retries connection errors and 5xx responses, client-go
+	// keeps retrying the responses that carry a Retry-After by itself
+	if config.MaxRetries > 0 {
+		config.Rest.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return &retryTransport{base: rt, maxRetries: config.MaxRetries}
+		})
+	}
+	// Not rest.Config.Timeout, which would also cut the watches of the pod
+	// monitors and the log streams. Wrapped last, it bounds the retries too.
+	if config.Timeout > 0 {
+		config.Rest.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+			return &timeoutTransport{base: rt, timeout: config.Timeout}
+		})
+	}
+
+	if config.Clientset, err = kubernetes.NewForConfig(config.Rest); err != nil {
+		return nil, fmt.Errorf("clientset creation error: %w", err)
+	}
+	if config.ManifestClient, err = NewManifestClient(config.Rest); err != nil {
+		return nil, err
+	}
+
+	logger := GetLogger(SetupTag)
+	logger.Info().Msgf("Running test with access mode %s, %s (context=%s qps=%s burst=%s timeout=%s retries=%s)",
+		config.AccessMode, config.Auth, clientDefault(config.Context), clientDefault(config.QPS), clientDefault(config.Burst), clientDefault(config.Timeout),
+		describeRetries(config.MaxRetries))
+	return config, nil
+}
+
+// AccessModeAuto picks the first access mode with usable credentials, in
+// autoAccessModes order. It is the default when ACCESS_MODE is unset.
+const AccessModeAuto = "AUTO"
+
+// In-cluster credentials first, so the CronJob never tests another cluster
+// through a kubeconfig baked into the image
+var autoAccessModes = []string{"LOCAL_K8S_API", "EXTERNAL_K8S_API", "KUBECONFIG"}
+
+// setAccessMode builds the client config of config.AccessMode
+func setAccessMode(config *SuiteConfig, envFileFound bool) error {
+	var err error
+	switch config.AccessMode {
+	case "KUBECONFIG":
+		if config.KubeconfigPath, err = findKubeconfig(envFileFound); err != nil {
+			return err
+		}
+		if config.Rest, err = kubeconfigRestConfig(config.KubeconfigPath, config.Context); err != nil {
+			return err
+		}
+		config.Auth = "kubeconfig " + config.KubeconfigPath
+
+	case "EXTERNAL_K8S_API":
+		if config.Rest, config.Auth, err = getExternalClusterAPICreds(); err != nil {
+			return fmt.Errorf("API credentials error: %w", err)
+		}
+
+	case "LOCAL_K8S_API":
+		if config.Rest, config.Auth, err = getLocalClusterAPICreds(); err != nil {
+			return fmt.Errorf("API credentials error: %w", err)
+		}
+
+	default:
+		return &ConfigError{Setting: "ACCESS_MODE", Err: ErrInvalidAccessMode,
+			Detail: fmt.Sprintf("%q, must be AUTO, KUBECONFIG, LOCAL_K8S_API or EXTERNAL_K8S_API", config.AccessMode)}
+	}
+	return nil
+}
+
+// detectAccessMode sets config to the first access mode of autoAccessModes
+// whose credentials load, logging why the ones before it were rejected. The
+// kubeconfig is KUBECONFIG, or ~/.kube/config when unset.
+func detectAccessMode(config *SuiteConfig) error {
+	logger := GetLogger(SetupTag)
+	var rejected []error
+
+	for _, mode := range autoAccessModes {
+		candidate := *config
+		candidate.AccessMode = mode
+		var err error
+		if config.Context != "" && mode != "KUBECONFIG" {
+			// A context only exists in a kubeconfig
+			err = fmt.Errorf("KUBE_CONTEXT=%s is set", config.Context)
+		} else {
+			err = setAccessMode(&candidate, false)
+		}
+		if err == nil {
+			logger.Info().Msgf("ACCESS_MODE=AUTO selected %s", mode)
+			*config = candidate
+			return nil
+		}
+		logger.Info().Msgf("ACCESS_MODE=AUTO rejected %s: %v", mode, err)
+		rejected = append(rejected, fmt.Errorf("%s: %w", mode, err))
+	}
+
+	return &ConfigError{Setting: "ACCESS_MODE", Err: ErrMissingCredential,
+		Detail: "AUTO found no usable credentials", Cause: errors.Join(rejected...)}
+}
+
+func clientDefault[T string | float32 | int | time.Duration](value T) string {
+	var zero T
+	if value == zero {
+		return "default"
+	}
+	return fmt.Sprint(value)
+}
+
+// splitList splits a comma separated setting, dropping the empty entries
+func splitList(value string) []string {
+	var tags []string
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// findKubeconfig returns KUBECONFIG, or ~/.kube/config when there is no .env
+func findKubeconfig(envFileFound bool) (string, error) {
+	path := os.Getenv("KUBECONFIG")
+
+	// Fallback to default if not set
+	if path == "" {
+		if !envFileFound {
+			home := homedir.HomeDir()
+			if home == "" {
+				return "", &ConfigError{Setting: "KUBECONFIG", Err: ErrKubeconfigNotFound, Detail: "not set and no home directory found"}
+			}
+			path = filepath.Join(home, ".kube", "config")
+		} else { // .env exists but KUBECONFIG is empty
+			return "", &ConfigError{Setting: "KUBECONFIG", Err: ErrKubeconfigNotFound,
+				Detail: ".env file format error, please use KUBECONFIG=/path/to/.kube/config"}
+		}
+	}
+
+	// Verify kubeconfig file exists
+	if _, err := os.Stat(path); err != nil {
+		return "", &ConfigError{Setting: "KUBECONFIG", Err: ErrKubeconfigNotFound, Detail: "checked: " + path, Cause: err}
+	}
+
+	return path, nil
+}
+
+// kubeconfigRestConfig builds the client config of a kubeconfig context, the
+// current context when context is empty
+func kubeconfigRestConfig(path, context string) (*rest.Config, error) {
+	if context != "" {
+		kubeconfig, err := clientcmd.LoadFromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config creation error: %w", err)
+		}
+		if _, ok := kubeconfig.Contexts[context]; !ok {
+			var contexts []string
+			for name := range kubeconfig.Contexts {
+				contexts = append(contexts, name)
+			}
+			sort.Strings(contexts)
+			return nil, &ConfigError{Setting: "KUBE_CONTEXT", Err: ErrInvalidSetting,
+				Detail: fmt.Sprintf("no context %q in %s, it has: %s", context, path, strings.Join(contexts, ", "))}
+		}
+	}
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+		&clientcmd.ConfigOverrides{CurrentContext: context},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("config creation error: %w", err)
+	}
+	return config, nil
+}
+
+// manifestValuesFromEnv resolves the fixture template values from the environment
+func manifestValuesFromEnv() (ManifestValues, error) {
+	values := ManifestValues{
+		Image:          getEnvOrDefault("TEST_IMAGE", "nginx:alpine"),
+		RegistryPrefix: os.Getenv("REGISTRY_PREFIX"),
+		TopologyKey:    getEnvOrDefault("TOPOLOGY_KEY", "topology.kubernetes.io/zone"),
+	}
+	var err error
+	if values.Replicas, err = getEnvInt32("TEST_REPLICAS"); err != nil {
+		return ManifestValues{}, err
+	}
+	if values.MaxReplicas, err = getEnvInt32("TEST_MAX_REPLICAS"); err != nil {
+		return ManifestValues{}, err
+	}
+	if values.RegistryPrefix != "" && !strings.HasSuffix(values.RegistryPrefix, "/") {
+		values.RegistryPrefix += "/"
+	}
+
+	return values, nil
+}
+
+func getEnvInt32(name string) (int32, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || parsed < 0 {
+		return 0, &ConfigError{Setting: name, Err: ErrInvalidSetting, Detail: fmt.Sprintf("must be a non-negative integer, got %q", value)}
+	}
+	return int32(parsed), nil
+}
+
+func getEnvFloat32(name string) (float32, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := strconv.ParseFloat(value, 32)
+	if err != nil || parsed < 0 {
+		return 0, &ConfigError{Setting: name, Err: ErrInvalidSetting, Detail: fmt.Sprintf("must be a non-negative number, got %q", value)}
+	}
+	return float32(parsed), nil
+}
+
+func getEnvDuration(name string) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return 0, &ConfigError{Setting: name, Err: ErrInvalidSetting, Detail: fmt.Sprintf("must be a non-negative duration like 30s, got %q", value)}
+	}
+	return parsed, nil
+}
+
+func getEnvOrDefault(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,246 @@
+package example_test
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("Configuration", ginkgo.Label("unit"), func() {
+	ginkgo.BeforeEach(func() {
+		// Run from an empty directory so the repo .env does not leak in
+		dir := ginkgo.GinkgoT().TempDir()
+		wd, err := os.Getwd()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(os.Chdir(dir)).To(gomega.Succeed())
+		ginkgo.DeferCleanup(os.Chdir, wd)
+	})
+
+	expectConfigError := func(err error, sentinel error, setting string) {
+		gomega.Expect(errors.Is(err, sentinel)).To(gomega.BeTrue(), err.Error())
+		var configErr *example.ConfigError
+		gomega.Expect(errors.As(err, &configErr)).To(gomega.BeTrue())
+		gomega.Expect(configErr.Setting).To(gomega.Equal(setting))
+	}
+
+	ginkgo.It("should load every setting once and build the clients", func() {
+		server := httptest.NewTLSServer(http.NotFoundHandler())
+		ginkgo.DeferCleanup(server.Close)
+		caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+		ginkgo.GinkgoT().Setenv("ACCESS_MODE", "EXTERNAL_K8S_API")
+		ginkgo.GinkgoT().Setenv("K8S_API_URL", server.URL)
+		ginkgo.GinkgoT().Setenv("K8S_TOKEN", "token")
+		ginkgo.GinkgoT().Setenv("K8S_CA_CERT", base64.StdEncoding.EncodeToString(caCert))
+		ginkgo.GinkgoT().Setenv("K8S_QPS", "50")
+		ginkgo.GinkgoT().Setenv("K8S_BURST", "100")
+		ginkgo.GinkgoT().Setenv("K8S_TIMEOUT", "30s")
+		ginkgo.GinkgoT().Setenv("ALLOWED_TO_FAIL", " DeploymentPDBTest, StatefulSetPDBTest,")
+		ginkgo.GinkgoT().Setenv("TEST_REPLICAS", "4")
+
+		config, err := example.LoadSuiteConfig(".env")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(config.AccessMode).To(gomega.Equal("EXTERNAL_K8S_API"))
+		gomega.Expect(config.Rest.Host).To(gomega.Equal(server.URL))
+		gomega.Expect(config.Rest.QPS).To(gomega.Equal(float32(50)))
+		gomega.Expect(config.Rest.Burst).To(gomega.Equal(100))
+		gomega.Expect(config.Timeout).To(gomega.Equal(30 * time.Second))
+		// Applied per request, not to the watches
+		gomega.Expect(config.Rest.Timeout).To(gomega.BeZero())
+		gomega.Expect(config.AllowedToFail).To(gomega.Equal([]string{"DeploymentPDBTest", "StatefulSetPDBTest"}))
+		gomega.Expect(config.Values.Replicas).To(gomega.Equal(int32(4)))
+		gomega.Expect(config.Clientset).NotTo(gomega.BeNil())
+		gomega.Expect(config.ManifestClient).NotTo(gomega.BeNil())
+
+		ginkgo.GinkgoT().Setenv("K8S_TIMEOUT", "30")
+		_, err = example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrInvalidSetting, "K8S_TIMEOUT")
+	})
+
+	ginkgo.It("should hand the shared clients to every caller", func(ctx ginkgo.SpecContext) {
+		ginkgo.DeferCleanup(example.SetSuiteConfig, (*example.SuiteConfig)(nil))
+		example.SetSuiteConfig(&example.SuiteConfig{
+			Clientset: fake.NewSimpleClientset(),
+			Values:    example.ManifestValues{Image: "nginx:alpine"},
+		})
+
+		first, err := example.Config()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		second, err := example.Config()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(second).To(gomega.BeIdenticalTo(first))
+
+		namespace, err := example.CreateTestNamespace(ctx, zerolog.Nop(), first.Clientset, "FakeTest")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		_, err = second.Clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should use the kubeconfig context named by KUBE_CONTEXT", func() {
+		kubeconfig := filepath.Join(ginkgo.GinkgoT().TempDir(), "config")
+		gomega.Expect(os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+current-context: staging
+clusters:
+- name: staging
+  cluster: {server: "https://staging.example:6443"}
+- name: prod-eu
+  cluster: {server: "https://prod-eu.example:6443"}
+contexts:
+- name: staging
+  context: {cluster: staging, user: tester}
+- name: prod-eu
+  context: {cluster: prod-eu, user: tester}
+users:
+- name: tester
+  user: {token: token}
+`), 0644)).To(gomega.Succeed())
+		ginkgo.GinkgoT().Setenv("ACCESS_MODE", "KUBECONFIG")
+		ginkgo.GinkgoT().Setenv("KUBECONFIG", kubeconfig)
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXTS", "")
+
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXT", "")
+		config, err := example.LoadSuiteConfig(".env")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(config.Rest.Host).To(gomega.Equal("https://staging.example:6443"))
+
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXT", "prod-eu")
+		config, err = example.LoadSuiteConfig(".env")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(config.Context).To(gomega.Equal("prod-eu"))
+		gomega.Expect(config.Rest.Host).To(gomega.Equal("https://prod-eu.example:6443"))
+
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXT", "prod-us")
+		_, err = example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrInvalidSetting, "KUBE_CONTEXT")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(`no context "prod-us" in ` + kubeconfig + ", it has: prod-eu, staging")))
+
+		// The fan-out runs the binary per context, ginkgo -p cannot
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXTS", "staging,prod-eu")
+		_, err = example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrInvalidSetting, "KUBE_CONTEXTS")
+
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXTS", "")
+		ginkgo.GinkgoT().Setenv("ACCESS_MODE", "LOCAL_K8S_API")
+		_, err = example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrInvalidSetting, "KUBE_CONTEXT")
+	})
+
+	ginkgo.It("should detect the access mode, in-cluster credentials first", func() {
+		serviceAccount := ginkgo.GinkgoT().TempDir()
+		kubeconfig := filepath.Join(ginkgo.GinkgoT().TempDir(), "config")
+		gomega.Expect(os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+current-context: laptop
+clusters:
+- name: laptop
+  cluster: {server: "https://laptop.example:6443"}
+contexts:
+- name: laptop
+  context: {cluster: laptop, user: tester}
+users:
+- name: tester
+  user: {token: token}
+`), 0644)).To(gomega.Succeed())
+		caCert, _ := clientCertificate("cluster-ca", time.Now().Add(time.Hour))
+		for name, value := range map[string]string{
+			"ACCESS_MODE": "", "KUBE_CONTEXT": "", "KUBE_CONTEXTS": "", "KUBECONFIG": kubeconfig,
+			"K8S_SERVICE_ACCOUNT_DIR": serviceAccount, "KUBERNETES_SERVICE_HOST": "", "KUBERNETES_SERVICE_PORT": "",
+			"K8S_API_URL": "", "K8S_TOKEN": "", "K8S_TOKEN_FILE": "", "K8S_CLIENT_CERT": "", "K8S_CLIENT_CERT_FILE": "",
+			"K8S_EXEC_COMMAND": "", "K8S_CA_CERT": base64.StdEncoding.EncodeToString(caCert), "K8S_CA_FILE": "",
+		} {
+			ginkgo.GinkgoT().Setenv(name, value)
+		}
+
+		// A laptop: no service account, no external API
+		logged := example.LogBuffer.Len()
+		config, err := example.LoadSuiteConfig(".env")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(config.AccessMode).To(gomega.Equal("KUBECONFIG"))
+		gomega.Expect(config.Rest.Host).To(gomega.Equal("https://laptop.example:6443"))
+		logs := example.LogBuffer.String()[logged:]
+		gomega.Expect(logs).To(gomega.ContainSubstring("ACCESS_MODE=AUTO rejected LOCAL_K8S_API: API credentials error: " + filepath.Join(serviceAccount, "token")))
+		gomega.Expect(logs).To(gomega.ContainSubstring("ACCESS_MODE=AUTO rejected EXTERNAL_K8S_API: API credentials error: K8S_API_URL: missing credential"))
+		gomega.Expect(logs).To(gomega.ContainSubstring("ACCESS_MODE=AUTO selected KUBECONFIG"))
+
+		ginkgo.GinkgoT().Setenv("K8S_API_URL", "https://external.example:6443")
+		ginkgo.GinkgoT().Setenv("K8S_TOKEN", "token")
+		config, err = example.LoadSuiteConfig(".env")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(config.AccessMode).To(gomega.Equal("EXTERNAL_K8S_API"))
+
+		// A pod, even with the external variables of the same .env
+		gomega.Expect(os.WriteFile(filepath.Join(serviceAccount, "token"), []byte("token"), 0600)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(serviceAccount, "ca.crt"), caCert, 0600)).To(gomega.Succeed())
+		ginkgo.GinkgoT().Setenv("ACCESS_MODE", "AUTO")
+		config, err = example.LoadSuiteConfig(".env")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(config.AccessMode).To(gomega.Equal("LOCAL_K8S_API"))
+
+		// A context names a kubeconfig context
+		ginkgo.GinkgoT().Setenv("KUBE_CONTEXT", "laptop")
+		config, err = example.LoadSuiteConfig(".env")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(config.AccessMode).To(gomega.Equal("KUBECONFIG"))
+
+		ginkgo.GinkgoT().Setenv("KUBECONFIG", filepath.Join(serviceAccount, "missing"))
+		_, err = example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrMissingCredential, "ACCESS_MODE")
+		gomega.Expect(errors.Is(err, example.ErrKubeconfigNotFound)).To(gomega.BeTrue())
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("AUTO found no usable credentials")))
+	})
+
+	ginkgo.It("should reject an unknown access mode", func() {
+		ginkgo.GinkgoT().Setenv("ACCESS_MODE", "IN_CLUSTER")
+
+		_, err := example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrInvalidAccessMode, "ACCESS_MODE")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(`"IN_CLUSTER", must be AUTO, KUBECONFIG`)))
+	})
+
+	ginkgo.It("should name the missing or invalid external API credential", func() {
+		ginkgo.GinkgoT().Setenv("ACCESS_MODE", "EXTERNAL_K8S_API")
+		ginkgo.GinkgoT().Setenv("K8S_API_URL", "https://cluster.example:6443")
+		ginkgo.GinkgoT().Setenv("K8S_TOKEN", "")
+		ginkgo.GinkgoT().Setenv("K8S_CA_CERT", "")
+
+		_, err := example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrMissingCredential, "K8S_TOKEN")
+
+		ginkgo.GinkgoT().Setenv("K8S_TOKEN", "token")
+		ginkgo.GinkgoT().Setenv("K8S_CA_CERT", "not base64!")
+		_, err = example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrInvalidCredential, "K8S_CA_CERT")
+	})
+
+	ginkgo.It("should report a missing kubeconfig instead of panicking", func() {
+		ginkgo.GinkgoT().Setenv("ACCESS_MODE", "KUBECONFIG")
+		ginkgo.GinkgoT().Setenv("KUBECONFIG", "")
+		gomega.Expect(os.WriteFile(".env", []byte("ACCESS_MODE=KUBECONFIG\n"), 0644)).To(gomega.Succeed())
+
+		_, err := example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrKubeconfigNotFound, "KUBECONFIG")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(".env file format error")))
+
+		missing := filepath.Join(ginkgo.GinkgoT().TempDir(), "config")
+		ginkgo.GinkgoT().Setenv("KUBECONFIG", missing)
+		_, err = example.LoadSuiteConfig(".env")
+		expectConfigError(err, example.ErrKubeconfigNotFound, "KUBECONFIG")
+		gomega.Expect(errors.Is(err, fs.ErrNotExist)).To(gomega.BeTrue())
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(missing)))
+	})
+})
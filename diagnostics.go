@@ -0,0 +1,237 @@
+package example
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/rs/zerolog"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// diagnosticsLogTailLines bounds the container logs kept per container
+const diagnosticsLogTailLines = int64(200)
+
+// EventRecord is a namespace Event reduced to what helps diagnosing a failure
+type EventRecord struct {
+	Time    string `json:"time"`
+	Type    string `json:"type"`
+	Reason  string `json:"reason"`
+	Object  string `json:"object"`
+	Message string `json:"message"`
+	Count   int32  `json:"count"`
+}
+
+// DiagnosticsBundle is the state of a test namespace captured when a spec
+// failed, before the namespace is cleared. Collection is best effort, what
+// could not be read is listed in Errors.
+type DiagnosticsBundle struct {
+	Tag            string                                                 `json:"tag"`
+	Spec           string                                                 `json:"spec"`
+	Namespace      string                                                 `json:"namespace"`
+	CollectedAt    string                                                 `json:"collected_at"`
+	Events         []EventRecord                                          `json:"events"`
+	Pods           []corev1.Pod                                           `json:"pods"`
+	ContainerLogs  map[string]string                                      `json:"container_logs"`
+	NodeConditions map[string][]corev1.NodeCondition                      `json:"node_conditions"`
+	HPAs           map[string]autoscalingv2.HorizontalPodAutoscalerStatus `json:"hpas"`
+	PDBs           map[string]policyv1.PodDisruptionBudgetStatus          `json:"pdbs"`
+	Errors         []string                                               `json:"errors,omitempty"`
+}
+
+func (b *DiagnosticsBundle) addError(format string, args ...interface{}) {
+	b.Errors = append(b.Errors, fmt.Sprintf(format, args...))
+}
+
+// CollectDiagnostics captures the events, pods, container logs (current and
+// previous), the conditions of the nodes hosting the pods, and the HPA and
+// PDB status of a namespace.
+func CollectDiagnostics(ctx context.Context, clientset kubernetes.Interface, namespace string) *DiagnosticsBundle {
+	bundle := &DiagnosticsBundle{
+		Namespace:      namespace,
+		CollectedAt:    time.Now().Format(time.RFC3339),
+		ContainerLogs:  make(map[string]string),
+		NodeConditions: make(map[string][]corev1.NodeCondition),
+		HPAs:           make(map[string]autoscalingv2.HorizontalPodAutoscalerStatus),
+		PDBs:           make(map[string]policyv1.PodDisruptionBudgetStatus),
+	}
+
+	events, err := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		bundle.addError("events: %v", err)
+	} else {
+		for _, event := range events.Items {
+			bundle.Events = append(bundle.Events, EventRecord{
+				Time:    eventTime(event).Format(time.RFC3339),
+				Type:    event.Type,
+				Reason:  event.Reason,
+				Object:  fmt.Sprintf("%s/%s", strings.ToLower(event.InvolvedObject.Kind), event.InvolvedObject.Name),
+				Message: event.Message,
+				Count:   event.Count,
+			})
+		}
+		sort.SliceStable(bundle.Events, func(i, j int) bool {
+			return bundle.Events[i].Time < bundle.Events[j].Time
+		})
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		bundle.addError("pods: %v", err)
+	} else {
+		nodes := make(map[string]bool)
+		for _, pod := range pods.Items {
+			pod.ManagedFields = nil
+			bundle.Pods = append(bundle.Pods, pod)
+			if pod.Spec.NodeName != "" {
+				nodes[pod.Spec.NodeName] = true
+			}
+			collectContainerLogs(ctx, clientset, bundle, &pod)
+		}
+
+		for nodeName := range nodes {
+			node, err := clientset.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+			if err != nil {
+				bundle.addError("node %s: %v", nodeName, err)
+				continue
+			}
+			bundle.NodeConditions[nodeName] = node.Status.Conditions
+		}
+	}
+
+	hpas, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		bundle.addError("hpas: %v", err)
+	} else {
+		for _, hpa := range hpas.Items {
+			bundle.HPAs[hpa.Name] = hpa.Status
+		}
+	}
+
+	pdbs, err := clientset.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		bundle.addError("pdbs: %v", err)
+	} else {
+		for _, pdb := range pdbs.Items {
+			bundle.PDBs[pdb.Name] = pdb.Status
+		}
+	}
+
+	return bundle
+}
+
+func eventTime(event corev1.Event) time.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp.Time
+	case !event.EventTime.IsZero():
+		return event.EventTime.Time
+	default:
+		return event.CreationTimestamp.Time
+	}
+}
+
+// collectContainerLogs keeps the tail of the current logs of every container,
+// and of the previous instance of the containers that restarted
+func collectContainerLogs(ctx context.Context, clientset kubernetes.Interface, bundle *DiagnosticsBundle, pod *corev1.Pod) {
+	restarts := make(map[string]int32)
+	statuses := append(append([]corev1.ContainerStatus(nil),
+		pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		restarts[status.Name] = status.RestartCount
+	}
+
+	containers := append(append([]corev1.Container(nil),
+		pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		key := fmt.Sprintf("%s/%s", pod.Name, container.Name)
+		tailLines := diagnosticsLogTailLines
+
+		logs, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: container.Name,
+			TailLines: &tailLines,
+		}).DoRaw(ctx)
+		if err != nil {
+			bundle.addError("logs %s: %v", key, err)
+		} else {
+			bundle.ContainerLogs[key] = string(logs)
+		}
+
+		if restarts[container.Name] == 0 {
+			continue
+		}
+		previous, err := clientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: container.Name,
+			TailLines: &tailLines,
+			Previous:  true,
+		}).DoRaw(ctx)
+		if err != nil {
+			bundle.addError("previous logs %s: %v", key, err)
+		} else {
+			bundle.ContainerLogs[key+" (previous)"] = string(previous)
+		}
+	}
+}
+
+// diagnosticsPath is the sidecar file of a test tag, next to the final report
+func diagnosticsPath(dir, testTag string) string {
+	return filepath.Join(dir, fmt.Sprintf("diagnostics_%s_%s.json", testTag, RunID))
+}
+
+// WriteDiagnostics stores the bundle of this run in dir and returns its path
+func WriteDiagnostics(dir string, bundle *DiagnosticsBundle) (string, error) {
+	data, err := json.MarshalIndent(bundle, "", " ")
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize diagnostics of %s: %w", bundle.Tag, err)
+	}
+
+	path := diagnosticsPath(dir, bundle.Tag)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write diagnostics of %s: %w", bundle.Tag, err)
+	}
+	return path, nil
+}
+
+// CollectFailureDiagnostics is called from AfterEach when a spec failed, so
+// the evidence is captured before AfterAll clears the namespace
+func CollectFailureDiagnostics(ctx context.Context, logger zerolog.Logger, clientset kubernetes.Interface, namespace, testTag string) {
+	if namespace == "" {
+		return
+	}
+
+	logger.Info().Msgf("=== Collecting failure diagnostics of namespace %s ===", namespace)
+	bundle := CollectDiagnostics(ctx, clientset, namespace)
+	bundle.Tag = testTag
+	bundle.Spec = ginkgo.CurrentSpecReport().FullText()
+
+	path, err := WriteDiagnostics(ReportDir, bundle)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to store failure diagnostics")
+		return
+	}
+	logger.Info().Str("file", path).Msgf("Failure diagnostics stored (%d events, %d pods, %d collection errors)",
+		len(bundle.Events), len(bundle.Pods), len(bundle.Errors))
+}
+
+// DiagnosticsFiles returns the diagnostics files of this run in dir by test tag
+func DiagnosticsFiles(dir string) map[string]string {
+	files := make(map[string]string)
+	suffix := fmt.Sprintf("_%s.json", RunID)
+
+	paths, _ := filepath.Glob(filepath.Join(dir, "diagnostics_*"+suffix))
+	for _, path := range paths {
+		tag := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "diagnostics_"), suffix)
+		files[tag] = path
+	}
+	return files
+}
@@ -0,0 +1,107 @@
+package example_test
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("CollectDiagnostics", ginkgo.Label("unit"), func() {
+	var clientset *fake.Clientset
+
+	ginkgo.BeforeEach(func() {
+		clientset = fake.NewSimpleClientset(
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "diag-ns"},
+				Spec: v1.PodSpec{
+					NodeName:   "node-a",
+					Containers: []v1.Container{{Name: "app"}},
+				},
+				Status: v1.PodStatus{
+					Phase:             v1.PodRunning,
+					ContainerStatuses: []v1.ContainerStatus{{Name: "app", RestartCount: 2}},
+				},
+			},
+			&v1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-1", Namespace: "diag-ns"},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "app"}}},
+				Status:     v1.PodStatus{Phase: v1.PodPending},
+			},
+			&v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+				Status: v1.NodeStatus{Conditions: []v1.NodeCondition{
+					{Type: v1.NodeMemoryPressure, Status: v1.ConditionTrue},
+				}},
+			},
+			&v1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "app-1.1", Namespace: "diag-ns"},
+				InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "app-1"},
+				Type:           v1.EventTypeWarning,
+				Reason:         "FailedScheduling",
+				Message:        "0/3 nodes are available",
+				Count:          4,
+			},
+			&autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-hpa", Namespace: "diag-ns"},
+				Status:     autoscalingv2.HorizontalPodAutoscalerStatus{CurrentReplicas: 1, DesiredReplicas: 3},
+			},
+			&policyv1.PodDisruptionBudget{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-pdb", Namespace: "diag-ns"},
+				Status:     policyv1.PodDisruptionBudgetStatus{CurrentHealthy: 1, DesiredHealthy: 2},
+			},
+		)
+	})
+
+	ginkgo.It("should capture the state of the namespace", func(ctx ginkgo.SpecContext) {
+		bundle := example.CollectDiagnostics(ctx, clientset, "diag-ns")
+
+		gomega.Expect(bundle.Errors).To(gomega.BeEmpty())
+		gomega.Expect(bundle.Events).To(gomega.ConsistOf(gomega.And(
+			gomega.HaveField("Reason", "FailedScheduling"),
+			gomega.HaveField("Object", "pod/app-1"),
+			gomega.HaveField("Count", int32(4)),
+		)))
+		gomega.Expect(bundle.Pods).To(gomega.HaveLen(2))
+		gomega.Expect(bundle.NodeConditions).To(gomega.HaveKey("node-a"))
+		gomega.Expect(bundle.NodeConditions["node-a"][0].Type).To(gomega.Equal(v1.NodeMemoryPressure))
+		gomega.Expect(bundle.HPAs["app-hpa"].DesiredReplicas).To(gomega.Equal(int32(3)))
+		gomega.Expect(bundle.PDBs["app-pdb"].CurrentHealthy).To(gomega.Equal(int32(1)))
+	})
+
+	ginkgo.It("should keep the previous logs of restarted containers only", func(ctx ginkgo.SpecContext) {
+		bundle := example.CollectDiagnostics(ctx, clientset, "diag-ns")
+
+		gomega.Expect(bundle.ContainerLogs).To(gomega.HaveKey("app-0/app"))
+		gomega.Expect(bundle.ContainerLogs).To(gomega.HaveKey("app-0/app (previous)"))
+		gomega.Expect(bundle.ContainerLogs).To(gomega.HaveKey("app-1/app"))
+		gomega.Expect(bundle.ContainerLogs).NotTo(gomega.HaveKey("app-1/app (previous)"))
+	})
+
+	ginkgo.It("should store the bundle as a sidecar file of the run", func(ctx ginkgo.SpecContext) {
+		dir := ginkgo.GinkgoT().TempDir()
+		bundle := example.CollectDiagnostics(ctx, clientset, "diag-ns")
+		bundle.Tag = "DeploymentPDBTest"
+
+		path, err := example.WriteDiagnostics(dir, bundle)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(example.DiagnosticsFiles(dir)).To(gomega.Equal(map[string]string{
+			"DeploymentPDBTest": path,
+		}))
+
+		data, err := os.ReadFile(path)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		var stored example.DiagnosticsBundle
+		gomega.Expect(json.Unmarshal(data, &stored)).To(gomega.Succeed())
+		gomega.Expect(stored.Namespace).To(gomega.Equal("diag-ns"))
+		gomega.Expect(stored.Pods).To(gomega.HaveLen(2))
+	})
+})
@@ -0,0 +1,58 @@
+package example_test
+
+import (
+	"errors"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("EvictPods", ginkgo.Label("unit"), func() {
+	ginkgo.It("should sort every pod into evicted, blocked by PDB or failed", func(ctx ginkgo.SpecContext) {
+		var pods []v1.Pod
+		for _, name := range []string{"evicted", "blocked", "blocked-by-cause", "throttled", "failed"} {
+			pods = append(pods, v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "evict-ns"}})
+		}
+		clientset := fake.NewSimpleClientset(&pods[0], &pods[1], &pods[2], &pods[3], &pods[4])
+
+		failure := apierrors.NewInternalError(errors.New("etcd unavailable"))
+		budgetCause := apierrors.NewTooManyRequests("Cannot evict pod", 0)
+		budgetCause.ErrStatus.Details.Causes = []metav1.StatusCause{{Type: policyv1.DisruptionBudgetCause, Message: "The disruption budget app needs 2 healthy pods and has 2 currently"}}
+		// API Priority and Fairness throttling answers 429 too, with a Retry-After
+		throttled := apierrors.NewTooManyRequests("too many requests, please try again later", 1)
+		clientset.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "eviction" {
+				return false, nil, nil
+			}
+			eviction := action.(clienttesting.CreateAction).GetObject().(*policyv1.Eviction)
+			switch eviction.Name {
+			case "blocked":
+				return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+			case "blocked-by-cause":
+				return true, nil, budgetCause
+			case "throttled":
+				return true, nil, throttled
+			case "failed":
+				return true, nil, failure
+			}
+			return true, nil, nil
+		})
+
+		result := example.EvictPods(ctx, zerolog.Nop(), clientset, pods)
+		gomega.Expect(result.Evicted).To(gomega.Equal([]string{"evicted"}))
+		gomega.Expect(result.Blocked).To(gomega.Equal([]string{"blocked", "blocked-by-cause"}))
+		gomega.Expect(result.Failed).To(gomega.HaveLen(2))
+		gomega.Expect(result.Failed).To(gomega.HaveKeyWithValue("throttled", gomega.MatchError(throttled)))
+		gomega.Expect(result.Failed).To(gomega.HaveKeyWithValue("failed", gomega.MatchError(failure)))
+	})
+})
@@ -0,0 +1,195 @@
+package example_test
+
+import (
+	"bytes"
+	"sync"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
+	"github.com/onsi/gomega"
+
+	"example"
+)
+
+func specReport(tag, text string, state types.SpecState) types.SpecReport {
+	return types.SpecReport{
+		ContainerHierarchyTexts:  []string{tag + " E2E test"},
+		ContainerHierarchyLabels: [][]string{{"safe-in-production", example.TestTagPrefix + tag}},
+		LeafNodeType:             types.NodeTypeIt,
+		LeafNodeText:             text,
+		LeafNodeLocation:         types.CodeLocation{FileName: "spec_test.go", LineNumber: 10},
+		State:                    state,
+		RunTime:                  1500 * time.Millisecond,
+	}
+}
+
+var _ = ginkgo.Describe("BuildFinalReport", ginkgo.Label("unit"), func() {
+	ginkgo.BeforeEach(func() {
+		allowedToFail := example.AllowedToFailTags
+		example.AllowedToFailTags = []string{"FlakyTest"}
+		ginkgo.DeferCleanup(func() { example.AllowedToFailTags = allowedToFail })
+	})
+
+	ginkgo.It("should decide every test from its spec states", func() {
+		skipped := specReport("SkippedTest", "should apply manifests", types.SpecStateSkipped)
+		skipped.Failure = types.Failure{Message: "Cluster has a single zone"}
+
+		failed := specReport("FlakyTest", "should apply manifests", types.SpecStateFailed)
+		failed.Failure = types.Failure{
+			Message:  "Expected <int>: 3 to be >= 5",
+			Location: types.CodeLocation{FileName: "flaky_test.go", LineNumber: 42},
+		}
+
+		report := ginkgo.Report{SpecReports: types.SpecReports{
+			specReport("PassingTest", "should apply manifests", types.SpecStatePassed),
+			specReport("PassingTest", "should scale", types.SpecStatePassed),
+			failed,
+			specReport("FlakyTest", "should scale", types.SpecStateSkipped),
+			specReport("BrokenTest", "should apply manifests", types.SpecStatePanicked),
+			specReport("BrokenTest", "should scale", types.SpecStateSkipped),
+			skipped,
+			specReport("SlowTest", "should apply manifests", types.SpecStateTimedout),
+			specReport("FilteredTest", "should apply manifests", types.SpecStateSkipped),
+		}}
+
+		finalReport := example.BuildFinalReport(report, nil)
+
+		// FilteredTest was left out by the filters, it is not part of the run
+		gomega.Expect(finalReport.SucceedingTests).To(gomega.Equal([]string{"PassingTest"}))
+		gomega.Expect(finalReport.FailingTests).To(gomega.Equal([]string{"FlakyTest", "BrokenTest", "SlowTest"}))
+		gomega.Expect(finalReport.SkippedTests).To(gomega.Equal([]string{"SkippedTest"}))
+		gomega.Expect(finalReport.SkipReasons).To(gomega.Equal(map[string]string{"SkippedTest": "Cluster has a single zone"}))
+		gomega.Expect(finalReport.AllowedToFailTests).To(gomega.Equal([]string{"FlakyTest"}))
+		gomega.Expect(finalReport.FailedButNotAllowed).To(gomega.Equal([]string{"BrokenTest", "SlowTest"}))
+		gomega.Expect(finalReport.SuccessRatio).To(gomega.Equal("25.00%"))
+		gomega.Expect(finalReport.Outcome).To(gomega.Equal(example.OutcomeFailed))
+
+		gomega.Expect(finalReport.Specs).To(gomega.HaveLen(8))
+		gomega.Expect(finalReport.Specs[2]).To(gomega.Equal(example.SpecResult{
+			Tag:      "FlakyTest",
+			Name:     "FlakyTest E2E test should apply manifests",
+			State:    "failed",
+			Failure:  "Expected <int>: 3 to be >= 5",
+			Location: "flaky_test.go:42",
+			Duration: "1.5s",
+		}))
+		gomega.Expect(finalReport.Specs[3].State).To(gomega.Equal("skipped"))
+	})
+
+	ginkgo.It("should report failed suite nodes under Setup", func() {
+		report := ginkgo.Report{SpecReports: types.SpecReports{
+			{LeafNodeType: types.NodeTypeSynchronizedBeforeSuite, State: types.SpecStatePassed},
+			{LeafNodeType: types.NodeTypeSynchronizedAfterSuite, State: types.SpecStateFailed},
+			specReport("PassingTest", "should apply manifests", types.SpecStatePassed),
+		}}
+
+		finalReport := example.BuildFinalReport(report, nil)
+
+		gomega.Expect(finalReport.FailingTests).To(gomega.Equal([]string{example.SetupTag}))
+		gomega.Expect(finalReport.Specs).To(gomega.HaveLen(2))
+		gomega.Expect(finalReport.Specs[0].Name).To(gomega.Equal("SynchronizedAfterSuite"))
+	})
+
+	ginkgo.It("should tell a setup failure from failed tests", func() {
+		setup := types.SpecReport{LeafNodeType: types.NodeTypeSynchronizedBeforeSuite, State: types.SpecStateFailed}
+		setup.Failure = types.Failure{Message: "Setup failed: ACCESS_MODE: invalid access mode"}
+		report := ginkgo.Report{SpecReports: types.SpecReports{
+			setup,
+			specReport("PassingTest", "should apply manifests", types.SpecStateSkipped),
+		}}
+
+		finalReport := example.BuildFinalReport(report, nil)
+
+		gomega.Expect(finalReport.Outcome).To(gomega.Equal(example.OutcomeSetupFailed))
+		gomega.Expect(finalReport.SetupError).To(gomega.Equal("Setup failed: ACCESS_MODE: invalid access mode"))
+		gomega.Expect(finalReport.SkippedTests).To(gomega.BeEmpty())
+
+		finalReport = example.BuildFinalReport(ginkgo.Report{SpecReports: types.SpecReports{
+			specReport("FlakyTest", "should apply manifests", types.SpecStateFailed),
+		}}, nil)
+		gomega.Expect(finalReport.Outcome).To(gomega.Equal(example.OutcomePassed))
+		gomega.Expect(finalReport.SetupError).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should fail a run stopped by a signal or SUITE_TIMEOUT", func() {
+		report := ginkgo.Report{
+			SpecReports: types.SpecReports{
+				specReport("PassingTest", "should apply manifests", types.SpecStatePassed),
+				specReport("FlakyTest", "should scale", types.SpecStateInterrupted),
+				specReport("NotRunTest", "should apply manifests", types.SpecStateSkipped),
+			},
+			SpecialSuiteFailureReasons: []string{"Interrupted by User", "Suite Timeout Elapsed", "Interrupted by User"},
+		}
+
+		finalReport := example.BuildFinalReport(report, nil)
+
+		gomega.Expect(finalReport.Interrupted).To(gomega.Equal("Interrupted by User, Suite Timeout Elapsed"))
+		gomega.Expect(finalReport.AllowedToFailTests).To(gomega.Equal([]string{"FlakyTest"}))
+		gomega.Expect(finalReport.Outcome).To(gomega.Equal(example.OutcomeFailed))
+
+		finalReport = example.BuildFinalReport(ginkgo.Report{
+			SpecialSuiteFailureReasons: []string{"Detected pending specs and --fail-on-pending is set"},
+		}, nil)
+		gomega.Expect(finalReport.Interrupted).To(gomega.BeEmpty())
+		gomega.Expect(finalReport.Outcome).To(gomega.Equal(example.OutcomePassed))
+	})
+
+	ginkgo.It("should decide the exit status of a parallel process from its own specs", func() {
+		// FlakyTest is allowed to fail, the process must not fail the ginkgo -p run
+		gomega.Expect(example.ProcessExitCode(1, types.SpecReports{
+			specReport("PassingTest", "should apply manifests", types.SpecStatePassed),
+			specReport("FlakyTest", "should scale", types.SpecStateFailed),
+		})).To(gomega.Equal(0))
+
+		gomega.Expect(example.ProcessExitCode(1, types.SpecReports{
+			specReport("FlakyTest", "should scale", types.SpecStateFailed),
+			specReport("BrokenTest", "should scale", types.SpecStatePanicked),
+		})).To(gomega.Equal(1))
+
+		// No spec ran, a flag error keeps its status
+		gomega.Expect(example.ProcessExitCode(2, nil)).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("should attach the log lines by tag as evidence only", func() {
+		report := ginkgo.Report{SpecReports: types.SpecReports{
+			specReport("PassingTest", "should apply manifests", types.SpecStatePassed),
+		}}
+		lines := [][]byte{
+			[]byte(`{"level":"error","tag":"PassingTest","message":"PassingTest:TEST_FAILED"}`),
+			[]byte(`{"level":"info","tag":"Setup","message":"Using kubeconfig"}`),
+			[]byte(`not json`),
+			{},
+		}
+
+		finalReport := example.BuildFinalReport(report, lines)
+
+		gomega.Expect(finalReport.SucceedingTests).To(gomega.Equal([]string{"PassingTest"}))
+		gomega.Expect(finalReport.FailingTests).To(gomega.BeEmpty())
+		gomega.Expect(finalReport.LogsByTags).To(gomega.HaveKey("PassingTest"))
+		gomega.Expect(finalReport.LogsByTags["PassingTest"][0]).To(gomega.Equal(map[string]interface{}{
+			"message": "PassingTest:TEST_FAILED",
+		}))
+		gomega.Expect(finalReport.LogsByTags).To(gomega.HaveKey("Setup"))
+	})
+
+	ginkgo.It("should keep every log line written concurrently", func() {
+		logger := example.GetLogger("ConcurrentTest")
+		logged := example.LogBuffer.Len()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					logger.Info().Msgf("line %d of writer %d", j, i)
+					_ = example.LogBuffer.Bytes()
+				}
+			}()
+		}
+		wg.Wait()
+
+		finalReport := example.BuildFinalReport(ginkgo.Report{}, bytes.Split(example.LogBuffer.Bytes()[logged:], []byte("\n")))
+		gomega.Expect(finalReport.LogsByTags["ConcurrentTest"]).To(gomega.HaveLen(400))
+	})
+})
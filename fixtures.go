@@ -0,0 +1,143 @@
+package example
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// embeddedFixtures holds the fixture directories, so the binary runs from any
+// working directory without the YAMLs next to it
+//
+//go:embed affinity_test_deployment_yamls affinity_test_statefulset_yamls
+//go:embed anti_affinity_test_deployment_yamls anti_affinity_statefulset_test_yamls
+//go:embed pdb_deployment_test_yamls pdb_statefulset_test_yamls
+//go:embed rolling_update_deployment_test_yamls rolling_update_sts_yamls
+//go:embed topology_test_deployment_yamls topology_test_statefulset_yamls
+var embeddedFixtures embed.FS
+
+// fixtureDirs maps every test tag to the directory of its fixtures
+var fixtureDirs = map[string]string{
+	"DeploymentAffinityTest":           "affinity_test_deployment_yamls",
+	"StatefulSetAffinityTest":          "affinity_test_statefulset_yamls",
+	"DeploymentAntiAffinityTest":       "anti_affinity_test_deployment_yamls",
+	"StatefulSetAntiAffinityTest":      "anti_affinity_statefulset_test_yamls",
+	"DeploymentPDBTest":                "pdb_deployment_test_yamls",
+	"StatefulSetPDBTest":               "pdb_statefulset_test_yamls",
+	"DeploymentRollingUpdateTest":      "rolling_update_deployment_test_yamls",
+	"StatefulSetRollingUpdateTest":     "rolling_update_sts_yamls",
+	"DeploymentTopologyConstraitTest":  "topology_test_deployment_yamls",
+	"StatefulSetTopologyConstraitTest": "topology_test_statefulset_yamls",
+}
+
+// FixtureBundle is the rendered fixtures of a test and their descriptor.
+// Sources tells where each file was read from, the embedded copy or the
+// override directory. Params are resolved from the rendered manifests.
+type FixtureBundle struct {
+	Test       string
+	Dir        string
+	Files      map[string][]byte
+	Sources    map[string]string
+	Descriptor BundleDescriptor
+	Params     map[string]interface{}
+}
+
+// File returns a rendered fixture of the bundle by file name
+func (b *FixtureBundle) File(name string) ([]byte, error) {
+	content, ok := b.Files[name]
+	if !ok {
+		return nil, fmt.Errorf("fixture %s not found in %s bundle (files: %v)", name, b.Test, b.Names())
+	}
+	return content, nil
+}
+
+// Names returns the file names of the bundle, sorted
+func (b *FixtureBundle) Names() []string {
+	names := make([]string, 0, len(b.Files))
+	for name := range b.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadFixtureBundle renders the fixtures of a test with the given values and
+// checks them against the bundle.yaml descriptor of the directory. The files
+// of FIXTURES_DIR/<fixture dir>/ take precedence over the embedded ones with
+// the same name, so fixtures can be edited without a rebuild.
+func LoadFixtureBundle(testTag string, values ManifestValues) (*FixtureBundle, error) {
+	dir, ok := fixtureDirs[testTag]
+	if !ok {
+		return nil, fmt.Errorf("no fixtures registered for test %s", testTag)
+	}
+	if values.Namespace == "" {
+		return nil, fmt.Errorf("no namespace set in manifest values")
+	}
+
+	bundle := &FixtureBundle{
+		Test:    testTag,
+		Dir:     dir,
+		Files:   make(map[string][]byte),
+		Sources: make(map[string]string),
+	}
+
+	raw := make(map[string][]byte)
+	if err := readFixtureDir(embeddedFixtures, dir, "embedded:"+dir, raw, bundle.Sources); err != nil {
+		return nil, err
+	}
+	if overrideDir := os.Getenv("FIXTURES_DIR"); overrideDir != "" {
+		err := readFixtureDir(os.DirFS(overrideDir), dir, filepath.Join(overrideDir, dir), raw, bundle.Sources)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	for name, content := range raw {
+		rendered, err := RenderManifest(name, content, values)
+		if err != nil {
+			return nil, fmt.Errorf("fixture %s error: %w (checked: %s)", name, err, bundle.Sources[name])
+		}
+		bundle.Files[name] = rendered
+	}
+
+	descriptor, ok := bundle.Files[BundleDescriptorFile]
+	if !ok {
+		return nil, fmt.Errorf("no %s in the %s bundle", BundleDescriptorFile, testTag)
+	}
+	delete(bundle.Files, BundleDescriptorFile)
+
+	var err error
+	if bundle.Descriptor, err = parseBundleDescriptor(descriptor, bundle.Files); err != nil {
+		return nil, fmt.Errorf("%w (checked: %s)", err, bundle.Sources[BundleDescriptorFile])
+	}
+	if bundle.Params, err = resolveParams(bundle.Descriptor, bundle.Files); err != nil {
+		return nil, fmt.Errorf("%s bundle: %w", testTag, err)
+	}
+	return bundle, nil
+}
+
+// readFixtureDir reads the YAML files of dir in fsys into files, recording
+// their source
+func readFixtureDir(fsys fs.FS, dir, source string, files map[string][]byte, sources map[string]string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return fmt.Errorf("failed to read fixtures of %s: %w", source, err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || (path.Ext(entry.Name()) != ".yaml" && path.Ext(entry.Name()) != ".yml") {
+			continue
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read fixture %s/%s: %w", source, entry.Name(), err)
+		}
+		files[entry.Name()] = content
+		sources[entry.Name()] = path.Join(filepath.ToSlash(source), entry.Name())
+	}
+	return nil
+}
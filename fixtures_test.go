@@ -0,0 +1,63 @@
+package example_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("LoadFixtureBundle", ginkgo.Label("unit"), func() {
+	values := example.ManifestValues{
+		Namespace:   "fixtures-ns",
+		Image:       "nginx:alpine",
+		TopologyKey: "topology.kubernetes.io/zone",
+	}
+
+	ginkgo.It("should render the embedded fixtures from any working directory", func() {
+		wd, err := os.Getwd()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(os.Chdir(ginkgo.GinkgoT().TempDir())).To(gomega.Succeed())
+		ginkgo.DeferCleanup(os.Chdir, wd)
+
+		fixtures, err := example.LoadFixtureBundle("StatefulSetAffinityTest", values)
+
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(fixtures.Names()).To(gomega.Equal([]string{"affinity-dependent-app.yaml", "hpa-trigger.yaml", "zone-marker.yaml"}))
+		gomega.Expect(fixtures.Sources["zone-marker.yaml"]).To(gomega.Equal("embedded:affinity_test_statefulset_yamls/zone-marker.yaml"))
+		zoneYAML, err := fixtures.File("zone-marker.yaml")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(string(zoneYAML)).To(gomega.ContainSubstring("namespace: fixtures-ns\n"))
+
+		_, err = fixtures.File("missing.yaml")
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("fixture missing.yaml not found in StatefulSetAffinityTest bundle")))
+	})
+
+	ginkgo.It("should prefer the files of FIXTURES_DIR", func() {
+		dir := ginkgo.GinkgoT().TempDir()
+		bundleDir := filepath.Join(dir, "pdb_deployment_test_yamls")
+		gomega.Expect(os.Mkdir(bundleDir, 0755)).To(gomega.Succeed())
+		gomega.Expect(os.WriteFile(filepath.Join(bundleDir, "pdb.yaml"),
+			[]byte("apiVersion: policy/v1\nkind: PodDisruptionBudget\nmetadata:\n  name: app-pdb\n  namespace: {{ .Namespace }}\nspec:\n  minAvailable: 2\n"), 0644)).To(gomega.Succeed())
+		ginkgo.GinkgoT().Setenv("FIXTURES_DIR", dir)
+
+		fixtures, err := example.LoadFixtureBundle("DeploymentPDBTest", values)
+
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(string(fixtures.Files["pdb.yaml"])).To(gomega.ContainSubstring("namespace: fixtures-ns\n"))
+		gomega.Expect(fixtures.Int32Param("minAvailable")).To(gomega.Equal(int32(2)))
+		gomega.Expect(fixtures.Sources["pdb.yaml"]).To(gomega.Equal(filepath.Join(bundleDir, "pdb.yaml")))
+		gomega.Expect(fixtures.Sources["deployment.yaml"]).To(gomega.HavePrefix("embedded:"))
+	})
+
+	ginkgo.It("should reject unknown tests and missing namespaces", func() {
+		_, err := example.LoadFixtureBundle("UnknownTest", values)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("no fixtures registered for test UnknownTest")))
+
+		_, err = example.LoadFixtureBundle("DeploymentPDBTest", example.ManifestValues{})
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("no namespace set")))
+	})
+})
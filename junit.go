@@ -0,0 +1,145 @@
+package example
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// JUnit groups, one testsuite each, named after the FinalReport fields
+const (
+	JUnitGroupFailedButNotAllowed = "failed_but_not_allowed_to_fail"
+	JUnitGroupAllowedToFail       = "allowed_to_fail"
+	JUnitGroupSucceeding          = "succeeding"
+	JUnitGroupSkipped             = "skipped"
+)
+
+type JUnitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []JUnitTestSuite `xml:"testsuite"`
+}
+
+type JUnitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []JUnitTestCase `xml:"testcase"`
+}
+
+type JUnitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Status    string        `xml:"status,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *JUnitFailure `xml:"failure,omitempty"`
+	Skipped   *JUnitSkipped `xml:"skipped,omitempty"`
+	SystemErr string        `xml:"system-err,omitempty"`
+}
+
+type JUnitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Content string `xml:",chardata"`
+}
+
+type JUnitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+// NewJUnitReport groups the specs of the final report by the outcome of their
+// test. Only the specs of tests not allowed to fail are reported as failures,
+// the failed specs of allowed to fail tests are reported as skipped with the
+// failure attached, so CI systems agree with ExitCode.
+func NewJUnitReport(finalReport FinalReport) JUnitTestSuites {
+	timestamp := time.Now().Format(time.RFC3339)
+	groups := []struct {
+		name string
+		tags []string
+	}{
+		{JUnitGroupFailedButNotAllowed, finalReport.FailedButNotAllowed},
+		{JUnitGroupAllowedToFail, finalReport.AllowedToFailTests},
+		{JUnitGroupSucceeding, finalReport.SucceedingTests},
+		{JUnitGroupSkipped, finalReport.SkippedTests},
+	}
+
+	suites := JUnitTestSuites{Name: "cluster-tester"}
+	for _, group := range groups {
+		suite := JUnitTestSuite{Name: group.name, Timestamp: timestamp}
+
+		for _, spec := range finalReport.Specs {
+			if !slices.Contains(group.tags, spec.Tag) {
+				continue
+			}
+			testCase := newJUnitTestCase(spec, group.name == JUnitGroupAllowedToFail)
+			switch {
+			case testCase.Failure != nil:
+				suite.Failures++
+			case testCase.Skipped != nil:
+				suite.Skipped++
+			}
+			suite.Tests++
+			suite.Time += testCase.Time
+			suite.TestCases = append(suite.TestCases, testCase)
+		}
+
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Skipped += suite.Skipped
+		suites.Time += suite.Time
+		suites.Suites = append(suites.Suites, suite)
+	}
+	return suites
+}
+
+func newJUnitTestCase(spec SpecResult, allowedToFail bool) JUnitTestCase {
+	duration, _ := time.ParseDuration(spec.Duration)
+	testCase := JUnitTestCase{
+		Name:      spec.Name,
+		ClassName: spec.Tag,
+		Status:    spec.State,
+		Time:      duration.Seconds(),
+	}
+
+	switch spec.State {
+	case "passed":
+	case "skipped", "pending":
+		testCase.Skipped = &JUnitSkipped{Message: spec.Failure}
+	default:
+		failure := fmt.Sprintf("%s\n\n%s", spec.Location, spec.Failure)
+		if allowedToFail {
+			testCase.Skipped = &JUnitSkipped{Message: fmt.Sprintf("%s, allowed to fail", spec.State)}
+			testCase.SystemErr = failure
+		} else {
+			testCase.Failure = &JUnitFailure{
+				Message: spec.Failure,
+				Type:    spec.State,
+				Content: failure,
+			}
+		}
+	}
+	return testCase
+}
+
+// WriteJUnitReport writes the final report as JUnit XML
+func WriteJUnitReport(path string, finalReport FinalReport) error {
+	data, err := xml.MarshalIndent(NewJUnitReport(finalReport), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize JUnit report: %w", err)
+	}
+
+	data = append([]byte(xml.Header), data...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write JUnit report %s: %w", path, err)
+	}
+	return nil
+}
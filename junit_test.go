@@ -0,0 +1,80 @@
+package example_test
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("NewJUnitReport", ginkgo.Label("unit"), func() {
+	finalReport := example.FinalReport{
+		FailingTests:        []string{"BrokenTest", "FlakyTest"},
+		SucceedingTests:     []string{"PassingTest"},
+		SkippedTests:        []string{"SkippedTest"},
+		AllowedToFailTests:  []string{"FlakyTest"},
+		FailedButNotAllowed: []string{"BrokenTest"},
+		Specs: []example.SpecResult{
+			{Tag: "BrokenTest", Name: "Broken should apply", State: "panicked", Failure: "boom", Location: "broken_test.go:12", Duration: "2s"},
+			{Tag: "BrokenTest", Name: "Broken should scale", State: "skipped", Failure: "earlier spec failed", Duration: "0s"},
+			{Tag: "FlakyTest", Name: "Flaky should apply", State: "failed", Failure: "3 < 5", Location: "flaky_test.go:42", Duration: "1.5s"},
+			{Tag: "PassingTest", Name: "Passing should apply", State: "passed", Duration: "500ms"},
+			{Tag: "SkippedTest", Name: "Skipped should apply", State: "skipped", Duration: "0s"},
+		},
+	}
+
+	ginkgo.It("should group the specs by the outcome of their test", func() {
+		junit := example.NewJUnitReport(finalReport)
+
+		gomega.Expect(junit.Tests).To(gomega.Equal(5))
+		gomega.Expect(junit.Failures).To(gomega.Equal(1))
+		gomega.Expect(junit.Skipped).To(gomega.Equal(3))
+		gomega.Expect(junit.Time).To(gomega.BeNumerically("~", 4.0))
+
+		var names []string
+		for _, suite := range junit.Suites {
+			names = append(names, suite.Name)
+		}
+		gomega.Expect(names).To(gomega.Equal([]string{
+			example.JUnitGroupFailedButNotAllowed,
+			example.JUnitGroupAllowedToFail,
+			example.JUnitGroupSucceeding,
+			example.JUnitGroupSkipped,
+		}))
+
+		notAllowed := junit.Suites[0]
+		gomega.Expect(notAllowed.TestCases).To(gomega.HaveLen(2))
+		gomega.Expect(notAllowed.TestCases[0].ClassName).To(gomega.Equal("BrokenTest"))
+		gomega.Expect(notAllowed.TestCases[0].Failure).NotTo(gomega.BeNil())
+		gomega.Expect(notAllowed.TestCases[0].Failure.Type).To(gomega.Equal("panicked"))
+		gomega.Expect(notAllowed.TestCases[0].Failure.Content).To(gomega.ContainSubstring("broken_test.go:12"))
+		gomega.Expect(notAllowed.TestCases[1].Skipped).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should not report the failures of allowed to fail tests as failures", func() {
+		allowed := example.NewJUnitReport(finalReport).Suites[1]
+
+		gomega.Expect(allowed.Failures).To(gomega.Equal(0))
+		gomega.Expect(allowed.TestCases).To(gomega.HaveLen(1))
+		gomega.Expect(allowed.TestCases[0].Failure).To(gomega.BeNil())
+		gomega.Expect(allowed.TestCases[0].Skipped.Message).To(gomega.Equal("failed, allowed to fail"))
+		gomega.Expect(allowed.TestCases[0].SystemErr).To(gomega.ContainSubstring("3 < 5"))
+	})
+
+	ginkgo.It("should write valid XML", func() {
+		path := filepath.Join(ginkgo.GinkgoT().TempDir(), "junit.xml")
+		gomega.Expect(example.WriteJUnitReport(path, finalReport)).To(gomega.Succeed())
+
+		data, err := os.ReadFile(path)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		var parsed example.JUnitTestSuites
+		gomega.Expect(xml.Unmarshal(data, &parsed)).To(gomega.Succeed())
+		gomega.Expect(parsed.Suites).To(gomega.HaveLen(4))
+		gomega.Expect(parsed.Failures).To(gomega.Equal(1))
+	})
+})
@@ -1,13 +1,32 @@
 package example_test
 
 import (
+	"os"
 	"testing"
 
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
+
+	"example"
 )
 
-func TestMain(t *testing.T) {
+// TestMain fails the binary only when a test that is not allowed to fail has
+// failed, so the CronJob history reflects the cluster health. With
+// KUBE_CONTEXTS it runs itself once per context instead.
+func TestMain(m *testing.M) {
+	if code, ran := example.FanOutClusters(os.Args); ran {
+		os.Exit(code)
+	}
+	os.Exit(example.ExitCode(m.Run()))
+}
+
+// TestSuite runs the specs within SUITE_TIMEOUT, when it elapses the running
+// spec is interrupted, the cleanup nodes run and the report is still written
+func TestSuite(t *testing.T) {
 	gomega.RegisterFailHandler(ginkgo.Fail)
-	ginkgo.RunSpecs(t, "All Tests Suite")
+	suiteConfig, reporterConfig := ginkgo.GinkgoConfiguration()
+	if timeout, err := example.LoadSuiteTimeout(".env"); err == nil && timeout > 0 {
+		suiteConfig.Timeout = timeout
+	}
+	ginkgo.RunSpecs(t, "All Tests Suite", suiteConfig, reporterConfig)
 }
@@ -0,0 +1,71 @@
+package example_test
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("RenderManifest", ginkgo.Label("unit"), func() {
+	type pdbSpec struct {
+		Metadata struct {
+			Namespace string `yaml:"namespace"`
+		} `yaml:"metadata"`
+		Spec struct {
+			MinAvailable int32 `yaml:"minAvailable"`
+		} `yaml:"spec"`
+	}
+
+	defaults := example.ManifestValues{
+		Namespace:   "test-ns",
+		Image:       "nginx:alpine",
+		TopologyKey: "topology.kubernetes.io/zone",
+	}
+
+	ginkgo.It("should keep the fixture defaults when no override is set", func() {
+		fixtures, err := example.LoadFixtureBundle("DeploymentPDBTest", defaults)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		pdbYAML, depYAML := fixtures.Files["pdb.yaml"], fixtures.Files["deployment.yaml"]
+
+		var pdb pdbSpec
+		gomega.Expect(yaml.Unmarshal(pdbYAML, &pdb)).To(gomega.Succeed())
+		gomega.Expect(pdb.Metadata.Namespace).To(gomega.Equal("test-ns"))
+		gomega.Expect(pdb.Spec.MinAvailable).To(gomega.Equal(int32(5)))
+
+		gomega.Expect(string(depYAML)).To(gomega.ContainSubstring("replicas: 6\n"))
+		gomega.Expect(string(depYAML)).To(gomega.ContainSubstring("image: nginx:alpine\n"))
+	})
+
+	ginkgo.It("should apply the overrides to every templated field", func() {
+		values := defaults
+		values.Namespace = "other-ns"
+		values.RegistryPrefix = "registry.example.com/mirror/"
+		values.Replicas = 4
+		values.MaxReplicas = 9
+		values.TopologyKey = "example.com/rack"
+
+		fixtures, err := example.LoadFixtureBundle("DeploymentPDBTest", values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		pdbYAML, depYAML := fixtures.Files["pdb.yaml"], fixtures.Files["deployment.yaml"]
+
+		var pdb pdbSpec
+		gomega.Expect(yaml.Unmarshal(pdbYAML, &pdb)).To(gomega.Succeed())
+		gomega.Expect(pdb.Metadata.Namespace).To(gomega.Equal("other-ns"))
+		gomega.Expect(pdb.Spec.MinAvailable).To(gomega.Equal(int32(3)))
+		gomega.Expect(string(depYAML)).To(gomega.ContainSubstring("replicas: 4\n"))
+		gomega.Expect(string(depYAML)).To(gomega.ContainSubstring("image: registry.example.com/mirror/nginx:alpine\n"))
+
+		fixtures, err = example.LoadFixtureBundle("DeploymentTopologyConstraitTest", values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		hpaYAML, topologyYAML := fixtures.Files["hpa-trigger.yaml"], fixtures.Files["topology-dep.yaml"]
+		gomega.Expect(string(hpaYAML)).To(gomega.ContainSubstring("maxReplicas: 9\n"))
+		gomega.Expect(string(topologyYAML)).To(gomega.ContainSubstring(`topologyKey: "example.com/rack"`))
+	})
+
+	ginkgo.It("should fail on unknown template values", func() {
+		_, err := example.RenderManifest("broken", []byte("namespace: {{ .Cluster }}\n"), defaults)
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("template render failed")))
+	})
+})
@@ -0,0 +1,228 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// PodCounts is the state of the monitored pods right after one event.
+// Total is the PodStateSnapshot Active count, terminating pods are not part of it.
+type PodCounts struct {
+	Total       int
+	Ready       int
+	Terminating int
+}
+
+// Invariant is evaluated against the monitored pods on every pod event,
+// Check returns an error describing the violation.
+type Invariant struct {
+	Name  string
+	Check func(counts PodCounts) error
+}
+
+func MinReady(minReady int) Invariant {
+	return Invariant{
+		Name: fmt.Sprintf("ready >= %d", minReady),
+		Check: func(counts PodCounts) error {
+			if counts.Ready < minReady {
+				return fmt.Errorf("ready pods %d < %d", counts.Ready, minReady)
+			}
+			return nil
+		},
+	}
+}
+
+func MaxSurge(replicas, maxSurge int) Invariant {
+	return Invariant{
+		Name: fmt.Sprintf("surge <= %d", maxSurge),
+		Check: func(counts PodCounts) error {
+			if surge := counts.Total - replicas; surge > maxSurge {
+				return fmt.Errorf("maxSurge violation: %d > %d", surge, maxSurge)
+			}
+			return nil
+		},
+	}
+}
+
+func MaxUnavailable(replicas, maxUnavailable int) Invariant {
+	return Invariant{
+		Name: fmt.Sprintf("unavailable <= %d", maxUnavailable),
+		Check: func(counts PodCounts) error {
+			if unavailable := replicas - counts.Ready; unavailable > maxUnavailable {
+				return fmt.Errorf("maxUnavailable violation: %d > %d", unavailable, maxUnavailable)
+			}
+			return nil
+		},
+	}
+}
+
+// Violation is the exact moment an invariant stopped holding
+type Violation struct {
+	Invariant string
+	Time      time.Time
+	Event     string
+	Counts    PodCounts
+	Err       error
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s violated at %s on %s: %v (total=%d ready=%d terminating=%d)",
+		v.Invariant, v.Time.Format("15:04:05.000"), v.Event, v.Err,
+		v.Counts.Total, v.Counts.Ready, v.Counts.Terminating)
+}
+
+// PodMonitor watches the pods matching a label selector through an informer
+// and evaluates its invariants on every pod state transition, instead of
+// sampling the pods with List calls.
+type PodMonitor struct {
+	logger     zerolog.Logger
+	factory    informers.SharedInformerFactory
+	informer   cache.SharedIndexInformer
+	invariants []Invariant
+	stopCh     chan struct{}
+	stopOnce   sync.Once
+
+	mu          sync.Mutex
+	pods        map[string]*corev1.Pod
+	synced      bool
+	transitions int
+	violations  []Violation
+}
+
+func NewPodMonitor(logger zerolog.Logger, clientset kubernetes.Interface, namespace, labelSelector string, invariants ...Invariant) *PodMonitor {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = labelSelector
+		}),
+	)
+
+	return &PodMonitor{
+		logger:     logger,
+		factory:    factory,
+		informer:   factory.Core().V1().Pods().Informer(),
+		invariants: invariants,
+		stopCh:     make(chan struct{}),
+		pods:       make(map[string]*corev1.Pod),
+	}
+}
+
+// Start begins watching and returns once the current pods are known. The
+// invariants are checked against that initial state and then on every event.
+// ctx only bounds the initial sync, Stop ends the watch.
+func (m *PodMonitor) Start(ctx context.Context) error {
+	registration, err := m.informer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				m.observe("ADDED", pod, false, !isInInitialList)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				m.observe("MODIFIED", pod, false, true)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				m.observe("DELETED", pod, true, true)
+			}
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("pod monitor handler registration failed: %w", err)
+	}
+
+	m.factory.Start(m.stopCh)
+	if !cache.WaitForCacheSync(ctx.Done(), registration.HasSynced) {
+		return fmt.Errorf("pod monitor failed to sync: %w", context.Cause(ctx))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.synced = true
+	m.evaluate("initial state")
+	return nil
+}
+
+// Stop ends the watch and returns every violation observed. It is safe to
+// call more than once and on a monitor whose Start failed.
+func (m *PodMonitor) Stop() []Violation {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+		m.factory.Shutdown()
+	})
+	return m.Violations()
+}
+
+func (m *PodMonitor) Violations() []Violation {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Violation(nil), m.violations...)
+}
+
+// Transitions is the number of pod events observed since Start
+func (m *PodMonitor) Transitions() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.transitions
+}
+
+func (m *PodMonitor) observe(eventType string, pod *corev1.Pod, deleted, evaluate bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if deleted {
+		delete(m.pods, pod.Name)
+	} else {
+		m.pods[pod.Name] = pod
+	}
+
+	if !evaluate || !m.synced {
+		return
+	}
+	m.transitions++
+	m.evaluate(fmt.Sprintf("%s pod/%s", eventType, pod.Name))
+}
+
+// evaluate must be called with m.mu held
+func (m *PodMonitor) evaluate(event string) {
+	counts := m.counts()
+	for _, invariant := range m.invariants {
+		if err := invariant.Check(counts); err != nil {
+			violation := Violation{
+				Invariant: invariant.Name,
+				Time:      time.Now(),
+				Event:     event,
+				Counts:    counts,
+				Err:       err,
+			}
+			m.logger.Error().Msgf("[InvariantViolation] %s", violation)
+			m.violations = append(m.violations, violation)
+		}
+	}
+}
+
+// counts must be called with m.mu held
+func (m *PodMonitor) counts() PodCounts {
+	snapshot := &PodStateSnapshot{Reasons: make(map[string]int)}
+	for _, pod := range m.pods {
+		snapshot.add(pod)
+	}
+	return PodCounts{
+		Total:       snapshot.Active(),
+		Ready:       snapshot.Ready,
+		Terminating: snapshot.Terminating,
+	}
+}
@@ -0,0 +1,137 @@
+package example_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"example"
+)
+
+func monitoredPod(name string, ready bool) *v1.Pod {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "monitor-ns",
+			Labels:    map[string]string{"app": "app"},
+		},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: status}},
+		},
+	}
+}
+
+var _ = ginkgo.Describe("PodMonitor", ginkgo.Label("unit"), func() {
+	var (
+		clientset *fake.Clientset
+		watching  chan struct{}
+	)
+
+	ginkgo.BeforeEach(func() {
+		clientset = fake.NewSimpleClientset(
+			monitoredPod("app-0", true),
+			monitoredPod("app-1", true),
+			monitoredPod("app-2", true),
+		)
+
+		// Signal once the informer's watch is established, so no event created
+		// by the spec can slip in between the initial list and the watch
+		watching = make(chan struct{})
+		clientset.PrependWatchReactor("pods", func(action clienttesting.Action) (bool, watch.Interface, error) {
+			watcher, err := clientset.Tracker().Watch(
+				action.GetResource(), action.GetNamespace())
+			if err != nil {
+				return false, nil, err
+			}
+			close(watching)
+			return true, watcher, nil
+		})
+	})
+
+	// The monitor logs from the informer goroutine through the shared logger,
+	// while the spec logs too
+	logger := example.GetLogger("PodMonitorTest")
+
+	startMonitor := func(ctx context.Context, invariants ...example.Invariant) *example.PodMonitor {
+		monitor := example.NewPodMonitor(logger, clientset, "monitor-ns", "app=app", invariants...)
+		gomega.Expect(monitor.Start(ctx)).To(gomega.Succeed())
+		gomega.Eventually(watching).Should(gomega.BeClosed())
+		ginkgo.DeferCleanup(func() { monitor.Stop() })
+		return monitor
+	}
+
+	ginkgo.It("should not report violations while the invariants hold", func(ctx ginkgo.SpecContext) {
+		monitor := startMonitor(ctx, example.MinReady(2), example.MaxSurge(3, 1))
+
+		_, err := clientset.CoreV1().Pods("monitor-ns").Create(ctx, monitoredPod("app-3", false), metav1.CreateOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Eventually(monitor.Transitions).Should(gomega.Equal(1))
+		gomega.Consistently(monitor.Violations, 100*time.Millisecond).Should(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should report the exact event that broke an invariant", func(ctx ginkgo.SpecContext) {
+		monitor := startMonitor(ctx, example.MinReady(2), example.MaxUnavailable(3, 1))
+
+		_, err := clientset.CoreV1().Pods("monitor-ns").UpdateStatus(ctx, monitoredPod("app-1", false), metav1.UpdateOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Eventually(monitor.Transitions).Should(gomega.Equal(1))
+		gomega.Expect(monitor.Violations()).To(gomega.BeEmpty())
+
+		logged := example.LogBuffer.Len()
+		err = clientset.CoreV1().Pods("monitor-ns").Delete(ctx, "app-2", metav1.DeleteOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		logger.Info().Msg("Deleted pod/app-2")
+
+		gomega.Eventually(monitor.Violations).Should(gomega.HaveLen(2))
+		gomega.Expect(example.LogBuffer.String()[logged:]).To(gomega.ContainSubstring("[InvariantViolation] ready >= 2"))
+		violations := monitor.Violations()
+		gomega.Expect(violations[0].Invariant).To(gomega.Equal("ready >= 2"))
+		gomega.Expect(violations[0].Event).To(gomega.Equal("DELETED pod/app-2"))
+		gomega.Expect(violations[0].Counts).To(gomega.Equal(example.PodCounts{Total: 2, Ready: 1}))
+		gomega.Expect(violations[1].Invariant).To(gomega.Equal("unavailable <= 1"))
+	})
+
+	ginkgo.It("should allow Stop to be called more than once", func(ctx ginkgo.SpecContext) {
+		monitor := startMonitor(ctx, example.MinReady(4))
+
+		gomega.Expect(monitor.Stop()).To(gomega.HaveLen(1))
+		gomega.Expect(monitor.Stop()).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("should check the invariants against the initial state", func(ctx ginkgo.SpecContext) {
+		monitor := startMonitor(ctx, example.MinReady(4))
+
+		violations := monitor.Violations()
+		gomega.Expect(violations).To(gomega.HaveLen(1))
+		gomega.Expect(violations[0].Event).To(gomega.Equal("initial state"))
+	})
+
+	ginkgo.It("should count terminating pods apart from the surge", func(ctx ginkgo.SpecContext) {
+		monitor := startMonitor(ctx, example.MaxSurge(3, 0))
+
+		terminating := monitoredPod("app-0", true)
+		now := metav1.Now()
+		terminating.DeletionTimestamp = &now
+		_, err := clientset.CoreV1().Pods("monitor-ns").Update(ctx, terminating, metav1.UpdateOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		_, err = clientset.CoreV1().Pods("monitor-ns").Create(ctx, monitoredPod("app-3", false), metav1.CreateOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Eventually(monitor.Transitions).Should(gomega.Equal(2))
+		gomega.Expect(monitor.Violations()).To(gomega.BeEmpty())
+	})
+})
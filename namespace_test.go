@@ -0,0 +1,61 @@
+package example_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("CreateTestNamespace", ginkgo.Label("unit"), func() {
+	ginkgo.It("should create a unique namespace labeled with the run ID and test tag", func(ctx ginkgo.SpecContext) {
+		clientset := fake.NewSimpleClientset()
+		logger := zerolog.Nop()
+
+		first, err := example.CreateTestNamespace(ctx, logger, clientset, "DeploymentPDBTest")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		second, err := example.CreateTestNamespace(ctx, logger, clientset, "DeploymentPDBTest")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Expect(first).To(gomega.MatchRegexp(`^ct-deploymentpdbtest-[a-z0-9]{5}$`))
+		gomega.Expect(second).NotTo(gomega.Equal(first))
+
+		ns, err := clientset.CoreV1().Namespaces().Get(ctx, first, metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(ns.Labels).To(gomega.HaveKeyWithValue(example.RunIDLabel, example.RunID))
+		gomega.Expect(ns.Labels).To(gomega.HaveKeyWithValue(example.TestTagLabel, "DeploymentPDBTest"))
+	})
+
+	ginkgo.It("should keep generated names within the namespace length limit", func() {
+		name := example.TestNamespaceName("AVeryLongTestTagThatKeepsGoingWellBeyondWhatANamespaceNameCanHold")
+		gomega.Expect(len(name)).To(gomega.BeNumerically("<=", 63))
+	})
+})
+
+var _ = ginkgo.Describe("ClearNamespace", ginkgo.Label("unit"), func() {
+	ginkgo.It("should stop waiting for the deletion when the context is done", func(ctx ginkgo.SpecContext) {
+		clientset := fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "stuck-ns"}})
+		// A finalizer keeps the namespace around
+		clientset.PrependReactor("delete", "namespaces", func(clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, nil
+		})
+
+		cancelled, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		started := time.Now()
+		example.ClearNamespace(cancelled, zerolog.Nop(), clientset, "stuck-ns")
+
+		gomega.Expect(time.Since(started)).To(gomega.BeNumerically("<", 5*time.Second))
+		_, err := clientset.CoreV1().Namespaces().Get(ctx, "stuck-ns", metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	})
+})
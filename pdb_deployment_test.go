@@ -1,268 +1,162 @@
 package example_test
 
 import (
-	"context"
 	"fmt"
 	"time"
 
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"github.com/rs/zerolog"
-	"gopkg.in/yaml.v2"
 	v1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	"example"
 )
 
-var _ = ginkgo.Describe("Deployment PDB E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), func() {
+var _ = ginkgo.Describe("Deployment PDB E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), example.TestTag("DeploymentPDBTest"), example.Requires(example.RequirementCPUHeadroom, example.RequirementRBAC), func() {
 	var (
-		clientset         *kubernetes.Clientset
+		clientset         kubernetes.Interface
+		manifestClient    *example.ManifestClient
+		values            example.ManifestValues
+		namespace         string
 		minBDPAllowedPods int32
 		logger            zerolog.Logger
 		testTag           = "DeploymentPDBTest"
 	)
-	ginkgo.BeforeAll(func() {
+	ginkgo.BeforeAll(func(ctx ginkgo.SpecContext) {
 
-		var err error
-		clientset, err = example.GetClient()
+		suite, err := example.Config()
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		clientset, manifestClient = suite.Clientset, suite.ManifestClient
 
 		logger = example.GetLogger(testTag)
 
-		// Namespace setup
-		logger.Info().Msgf("=== Ensuring test-ns exists ===")
-		_, err = clientset.CoreV1().Namespaces().Get(
-			context.TODO(),
-			"test-ns",
-			metav1.GetOptions{},
-		)
+		values = suite.Values
+		logger.Info().Msgf("=== Manifest values: %s ===", values)
 
-		if apierrors.IsNotFound(err) {
-			logger.Info().Msgf("Creating test-ns namespace\n")
-			ns := &v1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-ns",
-				},
-			}
-			_, err = clientset.CoreV1().Namespaces().Create(
-				context.TODO(),
-				ns,
-				metav1.CreateOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		} else {
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		// Skip before creating anything when the cluster lacks a declared requirement
+		err = example.SkipUnmetRequirements(ctx, logger, clientset, values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		// The PDB keeps all replicas but one available, a single replica renders
+		// minAvailable 0 and the PDB never blocks an eviction
+		if values.Replicas == 1 {
+			reason := "TEST_REPLICAS=1 renders a PDB with minAvailable 0, at least 2 replicas are needed"
+			logger.Warn().Msgf("=== Skipping: %s ===", reason)
+			ginkgo.Skip(reason)
 		}
+
+		// Namespace setup
+		namespace, err = example.CreateTestNamespace(ctx, logger, clientset, testTag)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		values.Namespace = namespace
 	})
 
-	ginkgo.AfterEach(func() {
-		clientset.CoreV1().RESTClient().(*rest.RESTClient).Client.CloseIdleConnections()
+	ginkgo.AfterEach(func(ctx ginkgo.SpecContext) {
+		// Capture the evidence before AfterAll clears the namespace
 		if ginkgo.CurrentSpecReport().Failed() {
-			logger.Error().Msgf("%s:TEST_FAILED", testTag)
+			example.CollectFailureDiagnostics(ctx, logger, clientset, namespace, testTag)
 		}
-
 	})
 
-	ginkgo.AfterAll(func() {
-		example.ClearNamespace(logger, clientset)
+	ginkgo.AfterAll(func(ctx ginkgo.SpecContext) {
+		example.ClearNamespace(ctx, logger, clientset, namespace)
 	})
 
-	ginkgo.It("should apply PDB manifests", func() {
+	ginkgo.It("should apply PDB manifests", func(ctx ginkgo.SpecContext) {
 		logger.Info().Msgf("=== Starting Deployment PDB E2E test ===")
 		logger.Info().Msgf("=== tag: %s, allowed to fail: %t", testTag, example.IsTestAllowedToFail(testTag))
 
-		pdbYAML, depYAML, err := example.GetPDBDeploymentTestFiles()
+		fixtures, err := example.LoadFixtureBundle(testTag, values)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		type pdbSpec struct {
-			Spec struct {
-				MinAvailable int32 `yaml:"minAvailable"`
-			} `yaml:"spec"`
-		}
-
-		var pdbConfig pdbSpec
-		err = yaml.Unmarshal([]byte(pdbYAML), &pdbConfig)
+		minBDPAllowedPods, err = fixtures.Int32Param("minAvailable")
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		minBDPAllowedPods = pdbConfig.Spec.MinAvailable
 		logger.Info().Msgf("=== Minimum allowed pods from PDB: %d ===", minBDPAllowedPods)
 
-		// Apply all the manifests
-		logger.Info().Msgf("=== Applying Deployment manifest ===")
-		err = example.ApplyRawManifest(clientset, depYAML)
+		err = example.ApplyFixtureBundle(ctx, logger, manifestClient, fixtures)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		logger.Info().Msgf("=== Applying PDB manifest ===")
-		err = example.ApplyRawManifest(clientset, pdbYAML)
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		logger.Info().Msgf("=== Wait for Pods to schedule ===")
-		time.Sleep(30 * time.Second)
 	})
 
-	ginkgo.It("should maintain minimum pods during rolling update", func() {
+	ginkgo.It("should maintain minimum pods during rolling update", func(ctx ginkgo.SpecContext) {
 
 		// Get existing deployment
-		currentDeployment, err := clientset.AppsV1().Deployments("test-ns").Get(
-			context.TODO(),
+		currentDeployment, err := clientset.AppsV1().Deployments(namespace).Get(
+			ctx,
 			"app",
 			metav1.GetOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		// Create modified deployment with new CPU request
-		newDeployment := currentDeployment.DeepCopy()
-		newDeployment.Spec.Template.Spec.Containers[0].Resources.Requests[v1.ResourceCPU] = resource.MustParse("100m")
+		// Watch every pod transition against the PDB minimum
+		monitor := example.NewPodMonitor(logger, clientset, namespace, "app=app",
+			example.MinReady(int(minBDPAllowedPods)),
+		)
+		ginkgo.DeferCleanup(func() { monitor.Stop() })
+		gomega.Expect(monitor.Start(ctx)).To(gomega.Succeed())
+
+		// Declaratively bump the CPU request on the fields we own
+		deploymentApply, err := appsv1ac.ExtractDeployment(currentDeployment, example.FieldManager)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		(*deploymentApply.Spec.Template.Spec.Containers[0].Resources.Requests)[v1.ResourceCPU] = resource.MustParse("100m")
 
 		logger.Info().Msgf("=== Triggering rolling update with new CPU requests ===")
-		_, err = clientset.AppsV1().Deployments("test-ns").Update(
-			context.TODO(),
-			newDeployment,
-			metav1.UpdateOptions{
-				FieldManager: "e2e-test",
+		_, err = clientset.AppsV1().Deployments(namespace).Apply(
+			ctx,
+			deploymentApply,
+			metav1.ApplyOptions{
+				FieldManager: example.FieldManager,
 			},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		// Monitoring parameters
-		const (
-			checkInterval = 15 * time.Second
-			maxAttempts   = 20
-		)
-		minObservedPods := int32(1 << 30) // Initialize with very high number
-		checkCounter := 1
-		rolloutComplete := false
-
 		logger.Info().Msgf("=== Starting rolling update monitoring ===")
-		for attempt := 1; attempt <= maxAttempts; attempt++ {
-			// Get current deployment status
-			deployment, err := clientset.AppsV1().Deployments("test-ns").Get(
-				context.TODO(),
+		gomega.Eventually(ctx, func() error {
+			deployment, err := clientset.AppsV1().Deployments(namespace).Get(
+				ctx,
 				"app",
 				metav1.GetOptions{},
 			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			if err != nil {
+				return err
+			}
 
 			// Check rollout completion
 			if deployment.Status.UpdatedReplicas == *deployment.Spec.Replicas &&
 				deployment.Status.Replicas == *deployment.Spec.Replicas &&
 				deployment.Status.AvailableReplicas == *deployment.Spec.Replicas {
-				rolloutComplete = true
 				logger.Info().Msgf("=== Rollout completed successfully ===")
-				break
+				return nil
 			}
 
-			// Get current pods
-			checkStart := time.Now()
-			runningPods, err := clientset.CoreV1().Pods("test-ns").List(
-				context.TODO(),
-				metav1.ListOptions{
-					FieldSelector: "status.phase=Running",
-					LabelSelector: "app=app",
-				},
-			)
-			checkDuration := time.Since(checkStart)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-			// Calculate pod statuses
-			var ready, runningNotReady, pending, terminating int
-			currentRunningPods := int32(len(runningPods.Items))
-			var podNames []string
-
-			for _, pod := range runningPods.Items {
-				podNames = append(podNames, pod.Name)
-				if pod.DeletionTimestamp != nil {
-					terminating++
-					continue
-				}
-
-				switch pod.Status.Phase {
-				case v1.PodPending:
-					pending++
-				case v1.PodRunning:
-					isReady := false
-					for _, cond := range pod.Status.Conditions {
-						if cond.Type == v1.PodReady && cond.Status == v1.ConditionTrue {
-							isReady = true
-							break
-						}
-					}
-					if isReady {
-						ready++
-					} else {
-						runningNotReady++
-					}
-				}
-			}
-
-			// Update minimum observed runningPods
-			if currentRunningPods < minObservedPods {
-				minObservedPods = currentRunningPods
-			}
-
-			// Get rolling update strategy parameters
-			rollingUpdate := deployment.Spec.Strategy.RollingUpdate
-			maxSurge := "0"
-			maxUnavailable := "0"
-			if rollingUpdate != nil {
-				maxSurge = rollingUpdate.MaxSurge.String()
-				maxUnavailable = rollingUpdate.MaxUnavailable.String()
-			}
-
-			// Print detailed status
-			logger.Info().Msgf("=== Check %d ===", checkCounter)
-			logger.Info().Msgf("Rollout Status:\n"+
-				"  Total Pods: %d\n"+
-				"  Surge Usage: %d/%s\n"+
-				"  Unavailable: %d/%s\n"+
-				"  Ready: %d | RunningNotReady: %d | Pending: %d | Terminating: %d\n"+
-				"  Pod Names: %v\n"+
-				"  Check Duration: %vms\n",
-				len(runningPods.Items),
-				len(runningPods.Items)-int(*deployment.Spec.Replicas), maxSurge,
-				int(*deployment.Spec.Replicas)-int(deployment.Status.AvailableReplicas), maxUnavailable,
-				ready, runningNotReady, pending, terminating,
-				podNames,
-				checkDuration.Milliseconds())
-
-			// Immediate validation
-			gomega.Expect(currentRunningPods).To(
-				gomega.BeNumerically(">=", minBDPAllowedPods),
-				fmt.Sprintf("Check %d: Running Pod count %d < PDB minimum %d",
-					checkCounter,
-					currentRunningPods,
-					minBDPAllowedPods),
-			)
-
-			checkCounter++
-			time.Sleep(checkInterval)
-		}
+			logger.Info().Msgf("Rollout in progress: updated %d/%d, available %d, pod transitions observed: %d",
+				deployment.Status.UpdatedReplicas, *deployment.Spec.Replicas,
+				deployment.Status.AvailableReplicas, monitor.Transitions())
+			return fmt.Errorf("rollout in progress")
+		}, 5*time.Minute, 5*time.Second).Should(gomega.Succeed(), "Rollout did not complete within timeout")
 
 		// Final validation
-		gomega.Expect(rolloutComplete).To(gomega.BeTrue(), "Rollout did not complete within timeout")
-		gomega.Expect(minObservedPods).To(
-			gomega.BeNumerically(">=", minBDPAllowedPods),
-			fmt.Sprintf("Minimum observed running pods (%d) violated PDB requirement (%d)",
-				minObservedPods,
-				minBDPAllowedPods),
-		)
+		violations := monitor.Stop()
+		for _, violation := range violations {
+			logger.Error().Msgf("%s", violation)
+		}
+		gomega.Expect(violations).To(gomega.BeEmpty(),
+			fmt.Sprintf("Ready pods dropped below the PDB requirement (%d) during the rollout", minBDPAllowedPods))
 
-		logger.Info().Msgf("=== Rolling update completed with minimum %d running pods (PDB requires >=%d) ===",
-			minObservedPods,
+		logger.Info().Msgf("=== Rolling update completed over %d pod transitions, ready pods never below PDB minimum %d ===",
+			monitor.Transitions(),
 			minBDPAllowedPods)
 	})
 
-	ginkgo.It("should maintain minimum pod count during deletions", func() {
+	ginkgo.It("should maintain minimum pod count during evictions", func(ctx ginkgo.SpecContext) {
 
 		// Get current pod count with proper selectors
 		labelSelector := "app=app,component=my-unique-deployment"
 
-		pods, err := clientset.CoreV1().Pods("test-ns").List(
-			context.TODO(),
+		pods, err := clientset.CoreV1().Pods(namespace).List(
+			ctx,
 			metav1.ListOptions{
 				LabelSelector: labelSelector,
 				FieldSelector: "status.phase=Running",
@@ -286,46 +180,34 @@ var _ = ginkgo.Describe("Deployment PDB E2E test", ginkgo.Ordered, ginkgo.Label(
 			fmt.Sprintf("Initial pods (%d) below PDB minimum (%d)", initialPods, minBDPAllowedPods),
 		)
 
-		// Delete all active pods
-		logger.Info().Msgf("=== Deleting all %d pods ===", initialPods)
-		for _, pod := range activePods {
-			err := clientset.CoreV1().Pods("test-ns").Delete(
-				context.TODO(),
-				pod.Name,
-				metav1.DeleteOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		}
+		// Evict all active pods, the PDB must refuse the evictions that would break minAvailable
+		logger.Info().Msgf("=== Evicting all %d pods ===", initialPods)
+		evictions := example.EvictPods(ctx, logger, clientset, activePods)
+		gomega.Expect(evictions.Failed).To(gomega.BeEmpty(), "Evictions failed for reasons other than the PDB")
+
+		allowedDisruptions := initialPods - int(minBDPAllowedPods)
+		gomega.Expect(len(evictions.Evicted)).To(
+			gomega.BeNumerically("<=", allowedDisruptions),
+			fmt.Sprintf("PDB allowed %d evictions, expected at most %d", len(evictions.Evicted), allowedDisruptions),
+		)
+		gomega.Expect(evictions.Blocked).NotTo(gomega.BeEmpty(), "PDB did not block any eviction")
 
-		// Post-deletion checks with proper filtering
-		logger.Info().Msgf("=== Performing post-deletion validation ===")
+		// Post-eviction checks with proper filtering
+		logger.Info().Msgf("=== Performing post-eviction validation ===")
 		const numAttempts = 10
 		for attempt := 1; attempt <= numAttempts; attempt++ {
 			startPostCheck := time.Now()
 
-			postDeletePods, err := clientset.CoreV1().Pods("test-ns").List(
-				context.TODO(),
-				metav1.ListOptions{
-					LabelSelector: labelSelector,
-					FieldSelector: "status.phase=Running",
-				},
-			)
+			snapshot, err := example.ListPodStateSnapshot(ctx, clientset, namespace, labelSelector)
 			postCheckDuration := time.Since(startPostCheck)
 			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			finalCount := snapshot.Running()
 
-			// Filter terminating pods
-			var currentActivePods []v1.Pod
-			for _, p := range postDeletePods.Items {
-				if p.DeletionTimestamp == nil {
-					currentActivePods = append(currentActivePods, p)
-				}
-			}
-			finalCount := len(currentActivePods)
-
-			logger.Info().Msgf("Attempt %d: Active Pods=%d, Sampling Duration=%v\n",
+			logger.Info().Msgf("Attempt %d: Running Pods=%d, Sampling Duration=%v, %s\n",
 				attempt,
 				finalCount,
-				postCheckDuration.Round(time.Millisecond))
+				postCheckDuration.Round(time.Millisecond),
+				snapshot)
 
 			gomega.Expect(int32(finalCount)).To(
 				gomega.BeNumerically(">=", minBDPAllowedPods),
@@ -336,7 +218,7 @@ var _ = ginkgo.Describe("Deployment PDB E2E test", ginkgo.Ordered, ginkgo.Label(
 			)
 		}
 
-		logger.Info().Msgf("=== All post-deletion checks passed ===")
+		logger.Info().Msgf("=== All post-eviction checks passed ===")
 	})
 
 })
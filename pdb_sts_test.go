@@ -1,114 +1,88 @@
 package example_test
 
 import (
-	"context"
 	"fmt"
 	"time"
 
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"github.com/rs/zerolog"
-	"gopkg.in/yaml.v2"
-	v1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	"example"
 )
 
-var _ = ginkgo.Describe("StatefulSet PDB E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), func() {
+var _ = ginkgo.Describe("StatefulSet PDB E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), example.TestTag("StatefulSetPDBTest"), example.Requires(example.RequirementCPUHeadroom, example.RequirementRBAC), func() {
 	var (
-		clientset         *kubernetes.Clientset
+		clientset         kubernetes.Interface
+		manifestClient    *example.ManifestClient
+		values            example.ManifestValues
+		namespace         string
 		minBDPAllowedPods int32
 		logger            zerolog.Logger
 		testTag           = "StatefulSetPDBTest"
 	)
 
-	ginkgo.BeforeAll(func() {
+	ginkgo.BeforeAll(func(ctx ginkgo.SpecContext) {
 
-		var err error
-		clientset, err = example.GetClient()
+		suite, err := example.Config()
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		clientset, manifestClient = suite.Clientset, suite.ManifestClient
 
 		logger = example.GetLogger(testTag)
 
-		// Namespace setup
-		logger.Info().Msgf("=== Ensuring test-ns exists ===")
-		_, err = clientset.CoreV1().Namespaces().Get(
-			context.TODO(),
-			"test-ns",
-			metav1.GetOptions{},
-		)
+		values = suite.Values
+		logger.Info().Msgf("=== Manifest values: %s ===", values)
 
-		if apierrors.IsNotFound(err) {
-			logger.Info().Msgf("Creating test-ns namespace\n")
-			ns := &v1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-ns",
-				},
-			}
-			_, err = clientset.CoreV1().Namespaces().Create(
-				context.TODO(),
-				ns,
-				metav1.CreateOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		} else {
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		// Skip before creating anything when the cluster lacks a declared requirement
+		err = example.SkipUnmetRequirements(ctx, logger, clientset, values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		// The PDB keeps all replicas but one available, a single replica renders
+		// minAvailable 0 and the PDB never blocks an eviction
+		if values.Replicas == 1 {
+			reason := "TEST_REPLICAS=1 renders a PDB with minAvailable 0, at least 2 replicas are needed"
+			logger.Warn().Msgf("=== Skipping: %s ===", reason)
+			ginkgo.Skip(reason)
 		}
+
+		// Namespace setup
+		namespace, err = example.CreateTestNamespace(ctx, logger, clientset, testTag)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		values.Namespace = namespace
 	})
 
-	ginkgo.AfterEach(func() {
-		clientset.CoreV1().RESTClient().(*rest.RESTClient).Client.CloseIdleConnections()
+	ginkgo.AfterEach(func(ctx ginkgo.SpecContext) {
+		// Capture the evidence before AfterAll clears the namespace
 		if ginkgo.CurrentSpecReport().Failed() {
-			logger.Error().Msgf("%s:TEST_FAILED", testTag)
+			example.CollectFailureDiagnostics(ctx, logger, clientset, namespace, testTag)
 		}
-
 	})
 
-	ginkgo.AfterAll(func() {
-		example.ClearNamespace(logger, clientset)
+	ginkgo.AfterAll(func(ctx ginkgo.SpecContext) {
+		example.ClearNamespace(ctx, logger, clientset, namespace)
 	})
 
-	ginkgo.It("should apply PDB manifests", func() {
+	ginkgo.It("should apply PDB manifests", func(ctx ginkgo.SpecContext) {
 		logger.Info().Msgf("=== Starting StatefulSet PDB E2E test ===")
 		logger.Info().Msgf("=== tag: %s, allowed to fail: %t", testTag, example.IsTestAllowedToFail(testTag))
 
-		pdbYAML, ssYAML, err := example.GetPDBStSTestFiles()
+		fixtures, err := example.LoadFixtureBundle(testTag, values)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		type pdbSpec struct {
-			Spec struct {
-				MinAvailable int32 `yaml:"minAvailable"`
-			} `yaml:"spec"`
-		}
-
-		var pdbConfig pdbSpec
-		err = yaml.Unmarshal([]byte(pdbYAML), &pdbConfig)
+		minBDPAllowedPods, err = fixtures.Int32Param("minAvailable")
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		minBDPAllowedPods = pdbConfig.Spec.MinAvailable
 		logger.Info().Msgf("=== Minimum allowed pods from PDB: %d ===", minBDPAllowedPods)
 
-		// Apply all the manifests
-		logger.Info().Msgf("=== Applying StatefulSet and Service manifest ===")
-		err = example.ApplyRawManifest(clientset, ssYAML)
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		logger.Info().Msgf("=== Applying PDB manifest ===")
-		err = example.ApplyRawManifest(clientset, pdbYAML)
+		err = example.ApplyFixtureBundle(ctx, logger, manifestClient, fixtures)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		logger.Info().Msgf("=== Wait for Pods to schedule ===")
-		time.Sleep(30 * time.Second)
 	})
 
-	ginkgo.It("should maintain minimum pod count during deletions", func() {
+	ginkgo.It("should maintain minimum pod count during evictions", func(ctx ginkgo.SpecContext) {
 
 		//Get current pod count
-		pods, err := clientset.CoreV1().Pods("test-ns").List(
-			context.TODO(),
+		pods, err := clientset.CoreV1().Pods(namespace).List(
+			ctx,
 			metav1.ListOptions{FieldSelector: "status.phase=Running"},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -122,34 +96,33 @@ var _ = ginkgo.Describe("StatefulSet PDB E2E test", ginkgo.Ordered, ginkgo.Label
 			fmt.Sprintf("Initial pods (%d) below PDB minimum (%d)", initialPods, minBDPAllowedPods),
 		)
 
-		// Delete all pods
-		logger.Info().Msgf("=== Deleting all %d pods ===", initialPods)
-		for _, pod := range pods.Items {
-			err := clientset.CoreV1().Pods("test-ns").Delete(
-				context.TODO(),
-				pod.Name,
-				metav1.DeleteOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		}
+		// Evict all pods, the PDB must refuse the evictions that would break minAvailable
+		logger.Info().Msgf("=== Evicting all %d pods ===", initialPods)
+		evictions := example.EvictPods(ctx, logger, clientset, pods.Items)
+		gomega.Expect(evictions.Failed).To(gomega.BeEmpty(), "Evictions failed for reasons other than the PDB")
 
-		// Immediate post-deletion checks with 5 attempts
-		logger.Info().Msgf("=== Performing post-deletion validation (several attempts) ===")
+		allowedDisruptions := initialPods - int(minBDPAllowedPods)
+		gomega.Expect(len(evictions.Evicted)).To(
+			gomega.BeNumerically("<=", allowedDisruptions),
+			fmt.Sprintf("PDB allowed %d evictions, expected at most %d", len(evictions.Evicted), allowedDisruptions),
+		)
+		gomega.Expect(evictions.Blocked).NotTo(gomega.BeEmpty(), "PDB did not block any eviction")
+
+		// Immediate post-eviction checks with several attempts
+		logger.Info().Msgf("=== Performing post-eviction validation (several attempts) ===")
 		numAttempts := 10
 		for attempt := 1; attempt <= numAttempts; attempt++ {
 			startPostCheck := time.Now()
-			postDeletePods, err := clientset.CoreV1().Pods("test-ns").List(
-				context.TODO(),
-				metav1.ListOptions{FieldSelector: "status.phase=Running"},
-			)
+			snapshot, err := example.ListPodStateSnapshot(ctx, clientset, namespace, "")
 			postCheckDuration := time.Since(startPostCheck)
 			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-			finalPods := len(postDeletePods.Items)
+			finalPods := snapshot.Running()
 
-			logger.Info().Msgf("Attempt %d: Running Pods=%d, Sampling Duration=%v\n",
+			logger.Info().Msgf("Attempt %d: Running Pods=%d, Sampling Duration=%v, %s\n",
 				attempt,
 				finalPods,
-				postCheckDuration.Round(time.Millisecond))
+				postCheckDuration.Round(time.Millisecond),
+				snapshot)
 
 			gomega.Expect(int32(finalPods)).To(
 				gomega.BeNumerically(">=", minBDPAllowedPods),
@@ -160,7 +133,7 @@ var _ = ginkgo.Describe("StatefulSet PDB E2E test", ginkgo.Ordered, ginkgo.Label
 			)
 		}
 
-		logger.Info().Msgf("=== All post-deletion checks passed ===")
+		logger.Info().Msgf("=== All post-eviction checks passed ===")
 	})
 
 })
@@ -0,0 +1,152 @@
+package example_test
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"example"
+)
+
+func podInPhase(name string, phase v1.PodPhase, conditions ...v1.PodCondition) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1.PodStatus{
+			Phase:      phase,
+			Conditions: conditions,
+		},
+	}
+}
+
+func waitingPod(name, reason string) v1.Pod {
+	pod := podInPhase(name, v1.PodPending)
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+		Name:  "app",
+		State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: reason}},
+	}}
+	return pod
+}
+
+var _ = ginkgo.Describe("PodStateSnapshot", ginkgo.Label("unit"), func() {
+	ready := v1.PodCondition{Type: v1.PodReady, Status: v1.ConditionTrue}
+	notReady := v1.PodCondition{Type: v1.PodReady, Status: v1.ConditionFalse}
+
+	ginkgo.It("should put every pod in exactly one state", func() {
+		terminating := podInPhase("terminating", v1.PodRunning, ready)
+		now := metav1.Now()
+		terminating.DeletionTimestamp = &now
+
+		snapshot := example.NewPodStateSnapshot([]v1.Pod{
+			podInPhase("ready-0", v1.PodRunning, ready),
+			podInPhase("ready-1", v1.PodRunning, ready),
+			podInPhase("starting", v1.PodRunning, notReady),
+			podInPhase("pending", v1.PodPending),
+			podInPhase("done", v1.PodSucceeded),
+			terminating,
+		})
+
+		gomega.Expect(snapshot.Ready).To(gomega.Equal(2))
+		gomega.Expect(snapshot.RunningNotReady).To(gomega.Equal(1))
+		gomega.Expect(snapshot.Pending).To(gomega.Equal(1))
+		gomega.Expect(snapshot.Terminating).To(gomega.Equal(1))
+		gomega.Expect(snapshot.Finished).To(gomega.Equal(1))
+		gomega.Expect(snapshot.Active()).To(gomega.Equal(4))
+		gomega.Expect(snapshot.Running()).To(gomega.Equal(3))
+		gomega.Expect(snapshot.Pods).To(gomega.HaveLen(6))
+	})
+
+	ginkgo.It("should report why pods are not healthy", func() {
+		oomKilled := waitingPod("oom", "CrashLoopBackOff")
+		oomKilled.Status.ContainerStatuses[0].LastTerminationState = v1.ContainerState{
+			Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+		}
+		unschedulable := podInPhase("unschedulable", v1.PodPending, v1.PodCondition{
+			Type:   v1.PodScheduled,
+			Status: v1.ConditionFalse,
+			Reason: v1.PodReasonUnschedulable,
+		})
+
+		snapshot := example.NewPodStateSnapshot([]v1.Pod{
+			waitingPod("crash", "CrashLoopBackOff"),
+			waitingPod("pull", "ImagePullBackOff"),
+			waitingPod("creating", "ContainerCreating"),
+			oomKilled,
+			unschedulable,
+		})
+
+		reasons := map[string]string{}
+		for _, pod := range snapshot.Pods {
+			reasons[pod.Name] = pod.Reason
+		}
+		gomega.Expect(reasons).To(gomega.Equal(map[string]string{
+			"crash":         "CrashLoopBackOff",
+			"pull":          "ImagePullBackOff",
+			"creating":      "",
+			"oom":           "OOMKilled",
+			"unschedulable": "Unschedulable",
+		}))
+		gomega.Expect(snapshot.Reasons).To(gomega.HaveKeyWithValue("CrashLoopBackOff", 1))
+		gomega.Expect(snapshot.String()).To(gomega.ContainSubstring(
+			"Reasons: [CrashLoopBackOff=1, ImagePullBackOff=1, OOMKilled=1, Unschedulable=1]"))
+	})
+
+	ginkgo.It("should compute surge and unavailability against the replicas", func() {
+		snapshot := example.NewPodStateSnapshot([]v1.Pod{
+			podInPhase("old-0", v1.PodRunning, ready),
+			podInPhase("old-1", v1.PodRunning, ready),
+			podInPhase("new-0", v1.PodPending),
+			podInPhase("new-1", v1.PodRunning, notReady),
+		})
+
+		gomega.Expect(snapshot.Surge(3)).To(gomega.Equal(1))
+		gomega.Expect(snapshot.Unavailable(3)).To(gomega.Equal(1))
+		gomega.Expect(snapshot.Surge(6)).To(gomega.Equal(0))
+		gomega.Expect(snapshot.Unavailable(1)).To(gomega.Equal(0))
+	})
+
+	ginkgo.It("should resolve deployment limits like the deployment controller", func() {
+		replicas := int32(3)
+		maxSurge := intstr.FromInt32(1)
+		maxUnavailable := intstr.FromString("25%")
+		deployment := &appsv1.Deployment{
+			Spec: appsv1.DeploymentSpec{
+				Replicas: &replicas,
+				Strategy: appsv1.DeploymentStrategy{
+					Type: appsv1.RollingUpdateDeploymentStrategyType,
+					RollingUpdate: &appsv1.RollingUpdateDeployment{
+						MaxSurge:       &maxSurge,
+						MaxUnavailable: &maxUnavailable,
+					},
+				},
+			},
+		}
+
+		limits, err := example.DeploymentRolloutLimits(deployment)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(limits).To(gomega.Equal(example.RolloutLimits{Replicas: 3, MaxSurge: 1, MaxUnavailable: 0}))
+
+		maxSurge = intstr.FromInt32(0)
+		limits, err = example.DeploymentRolloutLimits(deployment)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(limits.MaxUnavailable).To(gomega.Equal(1))
+
+		deployment.Spec.Strategy = appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+		limits, err = example.DeploymentRolloutLimits(deployment)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(limits).To(gomega.Equal(example.RolloutLimits{Replicas: 3, MaxSurge: 0, MaxUnavailable: 3}))
+	})
+
+	ginkgo.It("should let a statefulset replace one pod at a time by default", func() {
+		replicas := int32(3)
+		sts := &appsv1.StatefulSet{
+			Spec: appsv1.StatefulSetSpec{Replicas: &replicas},
+		}
+
+		limits, err := example.StatefulSetRolloutLimits(sts)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(limits).To(gomega.Equal(example.RolloutLimits{Replicas: 3, MaxSurge: 0, MaxUnavailable: 1}))
+	})
+})
@@ -0,0 +1,342 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/rs/zerolog"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Requirement is a cluster capability a test depends on, declared on its
+// Describe with Requires and checked once per process by the preflight phase
+type Requirement string
+
+const (
+	// RequirementZones needs the schedulable nodes spread over at least two
+	// values of the topology key
+	RequirementZones Requirement = "zones"
+	// RequirementMetricsAPI needs metrics.k8s.io to be served, for the HPAs
+	RequirementMetricsAPI Requirement = "metrics-api"
+	// RequirementCPUHeadroom needs MIN_CPU_HEADROOM of allocatable CPU left
+	// unrequested on the schedulable nodes
+	RequirementCPUHeadroom Requirement = "cpu-headroom"
+	// RequirementRBAC needs every permission in requiredPermissions
+	RequirementRBAC Requirement = "rbac"
+	// RequirementCordon needs the permissions in cordonPermissions, the
+	// scenarios that cordon nodes require it
+	RequirementCordon Requirement = "cordon"
+)
+
+// RequirementPrefix marks the Ginkgo labels carrying the requirements of a spec
+const RequirementPrefix = "requires:"
+
+const defaultMinCPUHeadroom = "1500m"
+
+const metricsGroupVersion = "metrics.k8s.io/v1beta1"
+
+// requiredPermissions are the verbs the tests use, checked with a
+// SelfSubjectAccessReview each
+var requiredPermissions = []authorizationv1.ResourceAttributes{
+	{Verb: "create", Resource: "namespaces"},
+	{Verb: "delete", Resource: "namespaces"},
+	{Verb: "list", Resource: "nodes"},
+	{Verb: "list", Resource: "pods"},
+	{Verb: "create", Resource: "pods", Subresource: "eviction"},
+	{Verb: "list", Resource: "events"},
+	{Verb: "patch", Group: "apps", Resource: "deployments"},
+	{Verb: "patch", Group: "apps", Resource: "statefulsets"},
+	{Verb: "patch", Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+	{Verb: "patch", Group: "policy", Resource: "poddisruptionbudgets"},
+}
+
+// cordonPermissions are the verbs of the cordon scenario action, on top of
+// requiredPermissions
+var cordonPermissions = []authorizationv1.ResourceAttributes{
+	{Verb: "patch", Resource: "nodes"},
+}
+
+// Requires labels a Describe with the cluster capabilities its specs need
+func Requires(requirements ...Requirement) ginkgo.Labels {
+	labels := ginkgo.Labels{}
+	for _, requirement := range requirements {
+		labels = append(labels, RequirementPrefix+string(requirement))
+	}
+	return labels
+}
+
+// PreflightResult is what the preflight phase found out about the cluster.
+// Unmet holds the reason of every requirement the cluster does not meet.
+type PreflightResult struct {
+	TopologyKey       string                 `json:"topology_key"`
+	Zones             []string               `json:"zones"`
+	MetricsAPI        bool                   `json:"metrics_api"`
+	CPUAllocatable    string                 `json:"cpu_allocatable"`
+	CPURequested      string                 `json:"cpu_requested"`
+	DeniedPermissions []string               `json:"denied_permissions"`
+	Unmet             map[Requirement]string `json:"unmet"`
+}
+
+// RunPreflight checks the RBAC permissions of the tests, the zone count, the
+// metrics API and the allocatable CPU headroom. A check the service account is
+// forbidden to run leaves its requirement unmet. A check that cannot reach the
+// API server is an error, not an unmet requirement, so a broken cluster access
+// fails the tests instead of skipping them.
+func RunPreflight(ctx context.Context, clientset kubernetes.Interface, topologyKey string, minCPUHeadroom resource.Quantity) (*PreflightResult, error) {
+	result := &PreflightResult{
+		TopologyKey: topologyKey,
+		Unmet:       make(map[Requirement]string),
+	}
+
+	if err := checkPermissions(ctx, clientset, result); err != nil {
+		return nil, err
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	switch {
+	case apierrors.IsForbidden(err):
+		result.Unmet[RequirementZones] = forbiddenReason("list nodes", err)
+		result.Unmet[RequirementCPUHeadroom] = forbiddenReason("list nodes", err)
+	case err != nil:
+		return nil, fmt.Errorf("preflight: failed to list nodes: %w", err)
+	default:
+		checkZones(result, nodes.Items)
+		if err := checkCPUHeadroom(ctx, clientset, result, nodes.Items, minCPUHeadroom); err != nil {
+			return nil, err
+		}
+	}
+
+	// An API group that is not registered is NotFound, a registered one whose
+	// metrics-server is down is ServiceUnavailable
+	_, err = clientset.Discovery().ServerResourcesForGroupVersion(metricsGroupVersion)
+	switch {
+	case err == nil:
+		result.MetricsAPI = true
+	case apierrors.IsNotFound(err) || apierrors.IsServiceUnavailable(err):
+		result.Unmet[RequirementMetricsAPI] = fmt.Sprintf("%s is not served (is metrics-server running?): %v", metricsGroupVersion, err)
+	case apierrors.IsForbidden(err):
+		result.Unmet[RequirementMetricsAPI] = forbiddenReason("get "+metricsGroupVersion, err)
+	default:
+		return nil, fmt.Errorf("preflight: failed to discover %s: %w", metricsGroupVersion, err)
+	}
+
+	return result, nil
+}
+
+// forbiddenReason is the unmet reason of a check the service account may not run
+func forbiddenReason(verb string, err error) string {
+	return fmt.Sprintf("cannot be checked, %s is forbidden: %v", verb, err)
+}
+
+func schedulable(node corev1.Node) bool {
+	if node.Spec.Unschedulable {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectNoSchedule || taint.Effect == corev1.TaintEffectNoExecute {
+			return false
+		}
+	}
+	return true
+}
+
+func checkZones(result *PreflightResult, nodes []corev1.Node) {
+	zones := make(map[string]bool)
+	for _, node := range nodes {
+		if zone, ok := node.Labels[result.TopologyKey]; ok && schedulable(node) {
+			zones[zone] = true
+		}
+	}
+	for zone := range zones {
+		result.Zones = append(result.Zones, zone)
+	}
+	sort.Strings(result.Zones)
+
+	if len(result.Zones) < 2 {
+		result.Unmet[RequirementZones] = fmt.Sprintf("schedulable nodes span %d value(s) of %s %v, at least 2 are needed",
+			len(result.Zones), result.TopologyKey, result.Zones)
+	}
+}
+
+func checkCPUHeadroom(ctx context.Context, clientset kubernetes.Interface, result *PreflightResult, nodes []corev1.Node, minCPUHeadroom resource.Quantity) error {
+	allocatable := resource.Quantity{}
+	schedulableNodes := make(map[string]bool)
+	for _, node := range nodes {
+		if !schedulable(node) {
+			continue
+		}
+		schedulableNodes[node.Name] = true
+		allocatable.Add(*node.Status.Allocatable.Cpu())
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if apierrors.IsForbidden(err) {
+		result.Unmet[RequirementCPUHeadroom] = forbiddenReason("list pods in all namespaces", err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("preflight: failed to list pods: %w", err)
+	}
+
+	requested := resource.Quantity{}
+	for _, pod := range pods.Items {
+		if !schedulableNodes[pod.Spec.NodeName] {
+			continue
+		}
+		for _, container := range pod.Spec.Containers {
+			requested.Add(*container.Resources.Requests.Cpu())
+		}
+	}
+
+	result.CPUAllocatable = allocatable.String()
+	result.CPURequested = requested.String()
+
+	headroom := allocatable.DeepCopy()
+	headroom.Sub(requested)
+	if headroom.Cmp(minCPUHeadroom) < 0 {
+		result.Unmet[RequirementCPUHeadroom] = fmt.Sprintf("%s CPU left unrequested of %s allocatable, %s is needed",
+			headroom.String(), allocatable.String(), minCPUHeadroom.String())
+	}
+	return nil
+}
+
+func checkPermissions(ctx context.Context, clientset kubernetes.Interface, result *PreflightResult) error {
+	for _, check := range []struct {
+		requirement Requirement
+		permissions []authorizationv1.ResourceAttributes
+	}{
+		{RequirementRBAC, requiredPermissions},
+		{RequirementCordon, cordonPermissions},
+	} {
+		var denied []string
+		for _, permission := range check.permissions {
+			attributes := permission
+			review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx,
+				&authorizationv1.SelfSubjectAccessReview{
+					Spec: authorizationv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attributes},
+				}, metav1.CreateOptions{})
+
+			name := permissionName(permission)
+			if err != nil {
+				return fmt.Errorf("preflight: access review of %s failed: %w", name, err)
+			}
+			if !review.Status.Allowed {
+				denied = append(denied, name)
+			}
+		}
+
+		if len(denied) > 0 {
+			result.DeniedPermissions = append(result.DeniedPermissions, denied...)
+			result.Unmet[check.requirement] = fmt.Sprintf("missing permissions: %s", strings.Join(denied, ", "))
+		}
+	}
+	return nil
+}
+
+func permissionName(permission authorizationv1.ResourceAttributes) string {
+	target := permission.Resource
+	if permission.Subresource != "" {
+		target += "/" + permission.Subresource
+	}
+	if permission.Group != "" {
+		target += "." + permission.Group
+	}
+	return fmt.Sprintf("%s %s", permission.Verb, target)
+}
+
+// UnmetReason returns why the given requirements are not met, empty when they all are
+func (r *PreflightResult) UnmetReason(requirements []Requirement) string {
+	var reasons []string
+	for _, requirement := range requirements {
+		if reason, ok := r.Unmet[requirement]; ok {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", requirement, reason))
+		}
+	}
+	if len(reasons) == 0 {
+		return ""
+	}
+	return "Preflight requirements not met: " + strings.Join(reasons, "; ")
+}
+
+// specRequirements returns the requirements declared on the current spec
+func specRequirements() []Requirement {
+	var requirements []Requirement
+	for _, label := range ginkgo.CurrentSpecReport().Labels() {
+		if requirement, ok := strings.CutPrefix(label, RequirementPrefix); ok {
+			requirements = append(requirements, Requirement(requirement))
+		}
+	}
+	return requirements
+}
+
+var (
+	preflightMu     sync.Mutex
+	preflightResult *PreflightResult
+)
+
+// Preflight runs the preflight checks once per process and returns their
+// result. A failed run is not kept, the next spec runs the checks again under
+// its own context.
+func Preflight(ctx context.Context, clientset kubernetes.Interface, values ManifestValues) (*PreflightResult, error) {
+	preflightMu.Lock()
+	defer preflightMu.Unlock()
+
+	if preflightResult != nil {
+		return preflightResult, nil
+	}
+
+	minCPUHeadroom := defaultMinCPUHeadroom
+	if value := os.Getenv("MIN_CPU_HEADROOM"); value != "" {
+		minCPUHeadroom = value
+	}
+	quantity, err := resource.ParseQuantity(minCPUHeadroom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MIN_CPU_HEADROOM %q: %w", minCPUHeadroom, err)
+	}
+
+	logger := GetLogger("Preflight")
+	result, err := RunPreflight(ctx, clientset, values.TopologyKey, quantity)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info().Msgf("=== Preflight: zones %v, metrics API %t, CPU %s requested of %s allocatable ===",
+		result.Zones, result.MetricsAPI, result.CPURequested, result.CPUAllocatable)
+	for requirement, reason := range result.Unmet {
+		logger.Warn().Msgf("[UnmetRequirement] %s: %s", requirement, reason)
+	}
+	preflightResult = result
+	return preflightResult, nil
+}
+
+// SkipUnmetRequirements skips the current spec, and with it the rest of its
+// Ordered container when called from BeforeAll, if the cluster does not meet
+// a requirement declared with Requires. The reason ends up in the final report.
+func SkipUnmetRequirements(ctx context.Context, logger zerolog.Logger, clientset kubernetes.Interface, values ManifestValues) error {
+	requirements := specRequirements()
+	if len(requirements) == 0 {
+		return nil
+	}
+
+	result, err := Preflight(ctx, clientset, values)
+	if err != nil {
+		return err
+	}
+
+	if reason := result.UnmetReason(requirements); reason != "" {
+		logger.Warn().Msgf("=== Skipping: %s ===", reason)
+		ginkgo.Skip(reason)
+	}
+	return nil
+}
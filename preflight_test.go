@@ -0,0 +1,177 @@
+package example_test
+
+import (
+	"errors"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"example"
+)
+
+const zoneKey = "topology.kubernetes.io/zone"
+
+func zonedNode(name, zone, cpu string, taints ...v1.Taint) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{zoneKey: zone}},
+		Spec:       v1.NodeSpec{Taints: taints},
+		Status: v1.NodeStatus{Allocatable: v1.ResourceList{
+			v1.ResourceCPU: resource.MustParse(cpu),
+		}},
+	}
+}
+
+func requestingPod(name, node, cpu string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "kube-system"},
+		Spec: v1.PodSpec{
+			NodeName: node,
+			Containers: []v1.Container{{
+				Name: "main",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceCPU: resource.MustParse(cpu),
+				}},
+			}},
+		},
+	}
+}
+
+// allowAccessReviews answers every SelfSubjectAccessReview, denying the given resources
+func allowAccessReviews(clientset *fake.Clientset, deniedResources ...string) {
+	clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		review.Status.Allowed = true
+		for _, denied := range deniedResources {
+			if review.Spec.ResourceAttributes.Resource == denied {
+				review.Status.Allowed = false
+			}
+		}
+		return true, review, nil
+	})
+}
+
+var _ = ginkgo.Describe("RunPreflight", ginkgo.Label("unit"), func() {
+	controlPlaneTaint := v1.Taint{Key: "node-role.kubernetes.io/control-plane", Effect: v1.TaintEffectNoSchedule}
+
+	ginkgo.It("should report every unmet requirement with its reason", func(ctx ginkgo.SpecContext) {
+		clientset := fake.NewSimpleClientset(
+			zonedNode("worker-a", "zone-a", "2"),
+			zonedNode("control-plane", "zone-b", "4", controlPlaneTaint),
+			requestingPod("busy", "worker-a", "1800m"),
+			requestingPod("ignored", "control-plane", "3"),
+		)
+		allowAccessReviews(clientset, "poddisruptionbudgets")
+
+		result, err := example.RunPreflight(ctx, clientset, zoneKey, resource.MustParse("1500m"))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Expect(result.Zones).To(gomega.Equal([]string{"zone-a"}))
+		gomega.Expect(result.MetricsAPI).To(gomega.BeFalse())
+		gomega.Expect(result.CPUAllocatable).To(gomega.Equal("2"))
+		gomega.Expect(result.CPURequested).To(gomega.Equal("1800m"))
+		gomega.Expect(result.DeniedPermissions).To(gomega.Equal([]string{"patch poddisruptionbudgets.policy"}))
+		gomega.Expect(result.Unmet).To(gomega.HaveKeyWithValue(example.RequirementZones, gomega.ContainSubstring("1 value(s)")))
+		gomega.Expect(result.Unmet).To(gomega.HaveKeyWithValue(example.RequirementMetricsAPI, gomega.ContainSubstring("metrics-server")))
+		gomega.Expect(result.Unmet).To(gomega.HaveKeyWithValue(example.RequirementCPUHeadroom, gomega.ContainSubstring("200m CPU left")))
+		gomega.Expect(result.Unmet).To(gomega.HaveKey(example.RequirementRBAC))
+
+		reason := result.UnmetReason([]example.Requirement{example.RequirementZones, example.RequirementRBAC})
+		gomega.Expect(reason).To(gomega.HavePrefix("Preflight requirements not met: zones: "))
+		gomega.Expect(reason).To(gomega.ContainSubstring("rbac: missing permissions: patch poddisruptionbudgets.policy"))
+	})
+
+	ginkgo.It("should meet every requirement on a capable cluster", func(ctx ginkgo.SpecContext) {
+		clientset := fake.NewSimpleClientset(
+			zonedNode("worker-a", "zone-a", "2"),
+			zonedNode("worker-b", "zone-b", "2"),
+			requestingPod("busy", "worker-a", "500m"),
+		)
+		clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{{
+			GroupVersion: "metrics.k8s.io/v1beta1",
+			APIResources: []metav1.APIResource{{Name: "pods", Namespaced: true}},
+		}}
+		allowAccessReviews(clientset)
+
+		result, err := example.RunPreflight(ctx, clientset, zoneKey, resource.MustParse("1500m"))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		gomega.Expect(result.Zones).To(gomega.Equal([]string{"zone-a", "zone-b"}))
+		gomega.Expect(result.MetricsAPI).To(gomega.BeTrue())
+		gomega.Expect(result.Unmet).To(gomega.BeEmpty())
+		gomega.Expect(result.UnmetReason([]example.Requirement{example.RequirementZones})).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should check the cordon permission on its own", func(ctx ginkgo.SpecContext) {
+		clientset := fake.NewSimpleClientset(zonedNode("worker-a", "zone-a", "2"), zonedNode("worker-b", "zone-b", "2"))
+		clientset.PrependReactor("create", "selfsubjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+			review := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+			attributes := review.Spec.ResourceAttributes
+			review.Status.Allowed = attributes.Resource != "nodes" || attributes.Verb != "patch"
+			return true, review, nil
+		})
+
+		result, err := example.RunPreflight(ctx, clientset, zoneKey, resource.MustParse("1500m"))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(result.DeniedPermissions).To(gomega.Equal([]string{"patch nodes"}))
+		gomega.Expect(result.Unmet).To(gomega.HaveKeyWithValue(example.RequirementCordon, "missing permissions: patch nodes"))
+		gomega.Expect(result.Unmet).NotTo(gomega.HaveKey(example.RequirementRBAC))
+	})
+
+	ginkgo.It("should fail instead of skipping when a check cannot reach the API server", func(ctx ginkgo.SpecContext) {
+		clientset := fake.NewSimpleClientset(zonedNode("worker-a", "zone-a", "2"), zonedNode("worker-b", "zone-b", "2"))
+		allowAccessReviews(clientset)
+		clientset.PrependReactor("list", "nodes", func(clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.New("dial tcp 10.0.0.1:443: connect: connection refused")
+		})
+
+		_, err := example.RunPreflight(ctx, clientset, zoneKey, resource.MustParse("1500m"))
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("failed to list nodes: dial tcp")))
+
+		clientset = fake.NewSimpleClientset(zonedNode("worker-a", "zone-a", "2"), zonedNode("worker-b", "zone-b", "2"))
+		clientset.PrependReactor("create", "selfsubjectaccessreviews", func(clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewInternalError(errors.New("webhook authorizer unavailable"))
+		})
+
+		_, err = example.RunPreflight(ctx, clientset, zoneKey, resource.MustParse("1500m"))
+		gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("access review of create namespaces failed")))
+	})
+
+	ginkgo.It("should leave the requirements unmet when the service account may not list", func(ctx ginkgo.SpecContext) {
+		clientset := fake.NewSimpleClientset(zonedNode("worker-a", "zone-a", "2"), zonedNode("worker-b", "zone-b", "2"))
+		allowAccessReviews(clientset, "nodes")
+		clientset.PrependReactor("list", "nodes", func(clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(v1.Resource("nodes"), "", errors.New("cluster-tester cannot list nodes"))
+		})
+
+		result, err := example.RunPreflight(ctx, clientset, zoneKey, resource.MustParse("1500m"))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(result.Unmet).To(gomega.HaveKeyWithValue(example.RequirementZones, gomega.HavePrefix("cannot be checked, list nodes is forbidden")))
+		gomega.Expect(result.Unmet).To(gomega.HaveKeyWithValue(example.RequirementCPUHeadroom, gomega.HavePrefix("cannot be checked, list nodes is forbidden")))
+		gomega.Expect(result.Unmet).To(gomega.HaveKeyWithValue(example.RequirementRBAC, gomega.ContainSubstring("list nodes")))
+
+		clientset = fake.NewSimpleClientset(zonedNode("worker-a", "zone-a", "2"), zonedNode("worker-b", "zone-b", "2"))
+		allowAccessReviews(clientset, "pods")
+		clientset.PrependReactor("list", "pods", func(clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(v1.Resource("pods"), "", errors.New("cluster-tester cannot list pods at the cluster scope"))
+		})
+
+		result, err = example.RunPreflight(ctx, clientset, zoneKey, resource.MustParse("1500m"))
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(result.Unmet).NotTo(gomega.HaveKey(example.RequirementZones))
+		gomega.Expect(result.Unmet).To(gomega.HaveKeyWithValue(example.RequirementCPUHeadroom, gomega.HavePrefix("cannot be checked, list pods in all namespaces is forbidden")))
+	})
+
+	ginkgo.It("should declare requirements as labels", func() {
+		gomega.Expect(example.Requires(example.RequirementZones, example.RequirementMetricsAPI)).To(
+			gomega.Equal(ginkgo.Labels{"requires:zones", "requires:metrics-api"}))
+	})
+})
@@ -0,0 +1,194 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// DefaultMaxRetries is the number of retries of a failed API request when
+// K8S_MAX_RETRIES is unset
+const DefaultMaxRetries = 3
+
+// APIRetryTag groups the log lines of retried API requests in the report
+const APIRetryTag = "APIRetry"
+
+// retryLogField marks the log line of a retry, the final report counts them
+// from the log lines of every parallel process
+const retryLogField = "api_retry"
+
+// Backoff between two attempts
+const (
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 5 * time.Second
+)
+
+// retryTransport retries the idempotent API requests that failed transiently,
+// with an exponential backoff: on connection errors and 5xx responses.
+// client-go owns the retries of the responses that carry a Retry-After, a 429
+// throttled by API Priority and Fairness or a 5xx: rest.Request already waits
+// and sends them again, retrying them here too would multiply the attempts.
+// A 429 without Retry-After is an answer, like an eviction refused by a
+// PodDisruptionBudget, evictions are never retried.
+type retryTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+}
+
+// idempotentMethods are safe to send again when the first attempt may have
+// reached the API server
+var idempotentMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true,
+	http.MethodPut: true, http.MethodDelete: true,
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	logger := GetLogger(APIRetryTag)
+
+	attemptReq := req
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(attemptReq)
+		reason := t.retryReason(req, resp, err)
+		if reason == "" || attempt >= t.maxRetries {
+			return resp, err
+		}
+		next, ok := cloneWithBody(req)
+		if !ok {
+			return resp, err
+		}
+		attemptReq = next
+		if resp != nil {
+			// Read the body so the connection can be reused
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+		delay := wait.Jitter(min(retryBaseDelay<<attempt, retryMaxDelay), 0.5)
+
+		logger.Warn().Str(retryLogField, reason).
+			Msgf("Retrying %s %s in %v (retry %d of %d): %s", req.Method, req.URL.Path, delay.Round(time.Millisecond), attempt+1, t.maxRetries, reason)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// retryReason tells why an attempt is worth retrying, empty when it is not
+func (t *retryTransport) retryReason(req *http.Request, resp *http.Response, err error) string {
+	if !idempotentMethods[req.Method] || strings.HasSuffix(req.URL.Path, "/eviction") {
+		return ""
+	}
+	if err != nil {
+		if req.Context().Err() != nil {
+			return ""
+		}
+		return "connection error"
+	}
+	if hasRetryAfter(resp) {
+		return ""
+	}
+
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return resp.Status
+	}
+	return ""
+}
+
+// hasRetryAfter tells whether client-go retries the response itself, as it
+// does for a 429 or 5xx with a Retry-After in seconds
+func hasRetryAfter(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return false
+	}
+	_, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	return err == nil
+}
+
+// cloneWithBody copies req to send it again, it fails for a body that cannot
+// be read twice
+func cloneWithBody(req *http.Request) (*http.Request, bool) {
+	clone := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return clone, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	clone.Body = body
+	return clone, true
+}
+
+// timeoutTransport bounds an API request with K8S_TIMEOUT, until its
+// response body is closed. Watches and followed logs stream for as long as
+// their caller's context allows.
+type timeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isStreaming(req) {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// isStreaming tells a watch or a followed log from a plain request
+func isStreaming(req *http.Request) bool {
+	query := req.URL.Query()
+	for _, name := range []string{"watch", "follow"} {
+		if value, err := strconv.ParseBool(query.Get(name)); err == nil && value {
+			return true
+		}
+	}
+	return false
+}
+
+// cancelOnClose releases the timeout of a request once its body is read
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// countRetries counts the retry log lines of the report by reason
+func countRetries(entries []map[string]interface{}) (int, map[string]int) {
+	total, reasons := 0, make(map[string]int)
+	for _, entry := range entries {
+		if reason, ok := entry[retryLogField].(string); ok {
+			total++
+			reasons[reason]++
+		}
+	}
+	return total, reasons
+}
+
+func describeRetries(maxRetries int) string {
+	if maxRetries == 0 {
+		return "off"
+	}
+	return fmt.Sprint(maxRetries)
+}
@@ -0,0 +1,205 @@
+package example_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+
+	"example"
+)
+
+var _ = ginkgo.Describe("Retrying API requests", ginkgo.Label("unit"), func() {
+	var (
+		server   *httptest.Server
+		requests atomic.Int32
+		// failures answers the first requests, the later ones succeed
+		failures []func(w http.ResponseWriter)
+		// delay slows every answer down
+		delay time.Duration
+	)
+
+	ginkgo.BeforeEach(func() {
+		requests.Store(0)
+		failures, delay = nil, 0
+		server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if attempt := int(requests.Add(1)); attempt <= len(failures) {
+				failures[attempt-1](w)
+				return
+			}
+			time.Sleep(delay)
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("watch") == "true" {
+				w.Write([]byte(`{"type":"ADDED","object":{"kind":"Namespace","apiVersion":"v1","metadata":{"name":"watched"}}}`))
+				return
+			}
+			w.Write([]byte(`{"kind":"Namespace","apiVersion":"v1","metadata":{"name":"retried"}}`))
+		}))
+		ginkgo.DeferCleanup(server.Close)
+
+		caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		for name, value := range map[string]string{
+			"ACCESS_MODE": "EXTERNAL_K8S_API", "KUBE_CONTEXT": "", "KUBE_CONTEXTS": "",
+			"K8S_API_URL": server.URL, "K8S_TOKEN": "token", "K8S_TOKEN_FILE": "",
+			"K8S_CLIENT_CERT": "", "K8S_CLIENT_CERT_FILE": "", "K8S_EXEC_COMMAND": "",
+			"K8S_CA_CERT": base64.StdEncoding.EncodeToString(caCert), "K8S_CA_FILE": "",
+			"K8S_TLS_SERVER_NAME": "", "K8S_PROXY_URL": "", "K8S_MAX_RETRIES": "", "K8S_TIMEOUT": "",
+		} {
+			ginkgo.GinkgoT().Setenv(name, value)
+		}
+	})
+
+	status := func(code int, header ...string) func(w http.ResponseWriter) {
+		return func(w http.ResponseWriter) {
+			for i := 0; i+1 < len(header); i += 2 {
+				w.Header().Set(header[i], header[i+1])
+			}
+			w.WriteHeader(code)
+		}
+	}
+	resetConnection := func(w http.ResponseWriter) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		conn.Close()
+	}
+
+	load := func() *example.SuiteConfig {
+		config, err := example.LoadSuiteConfig(".env")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		return config
+	}
+
+	ginkgo.It("should retry idempotent requests and count the retries in the report", func() {
+		// The connection is new, net/http only retries on reused ones itself
+		failures = append(failures, resetConnection, status(http.StatusServiceUnavailable), status(http.StatusBadGateway))
+		config := load()
+
+		logged := example.LogBuffer.Len()
+		namespace, err := config.Clientset.CoreV1().Namespaces().Get(context.TODO(), "retried", metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(namespace.Name).To(gomega.Equal("retried"))
+		gomega.Expect(requests.Load()).To(gomega.Equal(int32(4)))
+
+		finalReport := example.BuildFinalReport(ginkgo.Report{}, bytes.Split(example.LogBuffer.Bytes()[logged:], []byte("\n")))
+		gomega.Expect(finalReport.APIRetries).To(gomega.Equal(3))
+		gomega.Expect(finalReport.APIRetryReasons).To(gomega.Equal(map[string]int{
+			"503 Service Unavailable": 1, "connection error": 1, "502 Bad Gateway": 1,
+		}))
+	})
+
+	ginkgo.It("should give up after K8S_MAX_RETRIES", func() {
+		ginkgo.GinkgoT().Setenv("K8S_MAX_RETRIES", "1")
+		failures = append(failures, status(http.StatusServiceUnavailable), status(http.StatusServiceUnavailable), status(http.StatusServiceUnavailable))
+		config := load()
+
+		_, err := config.Clientset.CoreV1().Namespaces().Get(context.TODO(), "retried", metav1.GetOptions{})
+		gomega.Expect(apierrors.IsServiceUnavailable(err)).To(gomega.BeTrue(), err.Error())
+		gomega.Expect(requests.Load()).To(gomega.Equal(int32(2)))
+
+		ginkgo.GinkgoT().Setenv("K8S_MAX_RETRIES", "many")
+		_, err = example.LoadSuiteConfig(".env")
+		gomega.Expect(err).To(gomega.MatchError(example.ErrInvalidSetting))
+	})
+
+	ginkgo.It("should not send a failed create twice", func() {
+		failures = append(failures, status(http.StatusServiceUnavailable))
+		config := load()
+
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "retried"}}
+		_, err := config.Clientset.CoreV1().Namespaces().Create(context.TODO(), namespace, metav1.CreateOptions{})
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(requests.Load()).To(gomega.Equal(int32(1)))
+	})
+
+	ginkgo.It("should leave a 429 with Retry-After to client-go's own retry", func() {
+		failures = append(failures, status(http.StatusTooManyRequests, "Retry-After", "1"))
+		config := load()
+
+		logged := example.LogBuffer.Len()
+		started := time.Now()
+		_, err := config.Clientset.CoreV1().Namespaces().Get(context.TODO(), "retried", metav1.GetOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(requests.Load()).To(gomega.Equal(int32(2)))
+		gomega.Expect(time.Since(started)).To(gomega.BeNumerically(">=", time.Second))
+
+		finalReport := example.BuildFinalReport(ginkgo.Report{}, bytes.Split(example.LogBuffer.Bytes()[logged:], []byte("\n")))
+		gomega.Expect(finalReport.APIRetries).To(gomega.BeZero())
+	})
+
+	ginkgo.It("should not stack its retries on client-go's", func() {
+		for range 20 {
+			failures = append(failures, status(http.StatusServiceUnavailable, "Retry-After", "0"))
+		}
+		config := load()
+
+		_, err := config.Clientset.CoreV1().Namespaces().Get(context.TODO(), "retried", metav1.GetOptions{})
+		gomega.Expect(apierrors.IsServiceUnavailable(err)).To(gomega.BeTrue(), err.Error())
+		// client-go's 10 retries, not (10+1) x (K8S_MAX_RETRIES+1) attempts
+		gomega.Expect(requests.Load()).To(gomega.Equal(int32(11)))
+	})
+
+	ginkgo.It("should not retry a 429 without Retry-After", func() {
+		failures = append(failures, status(http.StatusTooManyRequests))
+		config := load()
+
+		_, err := config.Clientset.CoreV1().Namespaces().Get(context.TODO(), "retried", metav1.GetOptions{})
+		gomega.Expect(apierrors.IsTooManyRequests(err)).To(gomega.BeTrue(), err.Error())
+		gomega.Expect(requests.Load()).To(gomega.Equal(int32(1)))
+	})
+
+	ginkgo.It("should leave an eviction refused by a PodDisruptionBudget to the test", func() {
+		// The API server refuses the eviction with a 429 and no Retry-After
+		failures = append(failures, status(http.StatusTooManyRequests))
+		config := load()
+
+		logged := example.LogBuffer.Len()
+		eviction := &policyv1.Eviction{ObjectMeta: metav1.ObjectMeta{Name: "app-0", Namespace: "pdb-ns"}}
+		err := config.Clientset.PolicyV1().Evictions("pdb-ns").Evict(context.TODO(), eviction)
+		gomega.Expect(apierrors.IsTooManyRequests(err)).To(gomega.BeTrue(), err.Error())
+		gomega.Expect(requests.Load()).To(gomega.Equal(int32(1)))
+
+		finalReport := example.BuildFinalReport(ginkgo.Report{}, bytes.Split(example.LogBuffer.Bytes()[logged:], []byte("\n")))
+		gomega.Expect(finalReport.APIRetries).To(gomega.BeZero())
+	})
+
+	ginkgo.It("should stop retrying when the request is cancelled", func() {
+		failures = append(failures, status(http.StatusServiceUnavailable), status(http.StatusServiceUnavailable))
+		config := load()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		started := time.Now()
+		_, err := config.Clientset.CoreV1().Namespaces().Get(ctx, "retried", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.MatchError(context.DeadlineExceeded))
+		gomega.Expect(time.Since(started)).To(gomega.BeNumerically("<", 5*time.Second))
+		gomega.Expect(requests.Load()).To(gomega.Equal(int32(1)))
+	})
+
+	ginkgo.It("should bound plain requests with K8S_TIMEOUT but not watches", func(ctx ginkgo.SpecContext) {
+		ginkgo.GinkgoT().Setenv("K8S_TIMEOUT", "200ms")
+		delay = 500 * time.Millisecond
+		config := load()
+
+		_, err := config.Clientset.CoreV1().Namespaces().Get(ctx, "retried", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.MatchError(context.DeadlineExceeded))
+
+		watcher, err := config.Clientset.CoreV1().Namespaces().Watch(ctx, metav1.ListOptions{})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		defer watcher.Stop()
+		var event watch.Event
+		gomega.Eventually(watcher.ResultChan()).WithContext(ctx).Should(gomega.Receive(&event))
+		gomega.Expect(event.Object.(*corev1.Namespace).Name).To(gomega.Equal("watched"))
+	})
+})
@@ -1,126 +1,79 @@
 package example_test
 
 import (
-	"bytes"
-	"context"
 	"fmt"
 	"time"
 
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"github.com/rs/zerolog"
-	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/serializer"
+	appsv1ac "k8s.io/client-go/applyconfigurations/apps/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/rest"
 
 	"example"
 )
 
-var _ = ginkgo.Describe("StatefulSet Rolling Update E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), func() {
+var _ = ginkgo.Describe("StatefulSet Rolling Update E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), example.TestTag("StatefulSetRollingUpdateTest"), example.Requires(example.RequirementCPUHeadroom, example.RequirementRBAC), func() {
 	var (
-		clientset   *kubernetes.Clientset
-		ssStartYAML []byte
-		logger      zerolog.Logger
-		testTag     = "StatefulSetRollingUpdateTest"
+		clientset      kubernetes.Interface
+		manifestClient *example.ManifestClient
+		values         example.ManifestValues
+		namespace      string
+		logger         zerolog.Logger
+		testTag        = "StatefulSetRollingUpdateTest"
 	)
 
-	ginkgo.BeforeAll(func() {
+	ginkgo.BeforeAll(func(ctx ginkgo.SpecContext) {
 
-		var err error
-		clientset, err = example.GetClient()
+		suite, err := example.Config()
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		clientset, manifestClient = suite.Clientset, suite.ManifestClient
 
 		logger = example.GetLogger(testTag)
 
-		// Namespace setup
-		logger.Info().Msgf("=== Ensuring test-ns exists ===")
-		_, err = clientset.CoreV1().Namespaces().Get(
-			context.TODO(),
-			"test-ns",
-			metav1.GetOptions{},
-		)
+		values = suite.Values
+		logger.Info().Msgf("=== Manifest values: %s ===", values)
 
-		if apierrors.IsNotFound(err) {
-			logger.Info().Msgf("Creating test-ns namespace\n")
-			ns := &v1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-ns",
-				},
-			}
-			_, err = clientset.CoreV1().Namespaces().Create(
-				context.TODO(),
-				ns,
-				metav1.CreateOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		} else {
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		}
+		// Skip before creating anything when the cluster lacks a declared requirement
+		err = example.SkipUnmetRequirements(ctx, logger, clientset, values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		// Namespace setup
+		namespace, err = example.CreateTestNamespace(ctx, logger, clientset, testTag)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		values.Namespace = namespace
 	})
 
-	ginkgo.AfterEach(func() {
-		clientset.CoreV1().RESTClient().(*rest.RESTClient).Client.CloseIdleConnections()
+	ginkgo.AfterEach(func(ctx ginkgo.SpecContext) {
+		// Capture the evidence before AfterAll clears the namespace
 		if ginkgo.CurrentSpecReport().Failed() {
-			logger.Error().Msgf("%s:TEST_FAILED", testTag)
+			example.CollectFailureDiagnostics(ctx, logger, clientset, namespace, testTag)
 		}
-
 	})
 
-	ginkgo.AfterAll(func() {
-		example.ClearNamespace(logger, clientset)
+	ginkgo.AfterAll(func(ctx ginkgo.SpecContext) {
+		example.ClearNamespace(ctx, logger, clientset, namespace)
 	})
 
-	ginkgo.It("should apply Rolling update manifests", func() {
+	ginkgo.It("should apply Rolling update manifests", func(ctx ginkgo.SpecContext) {
 		logger.Info().Msgf("=== Starting StatefulSet Rolling Update E2E test ===")
 		logger.Info().Msgf("=== tag: %s, allowed to fail: %t", testTag, example.IsTestAllowedToFail(testTag))
 
 		var err error
-		ssStartYAML, err = example.GetRollingUpdateStatefulSetTestFiles()
+		fixtures, err := example.LoadFixtureBundle(testTag, values)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		// Parse YAML to find expected replicas
-		var expectedReplicas int32
-		decoder := serializer.NewCodecFactory(scheme.Scheme).UniversalDeserializer()
-
-		// Split multi-document YAML [1]
-		manifests := bytes.Split(ssStartYAML, []byte("---"))
-		for _, manifest := range manifests {
-			if len(bytes.TrimSpace(manifest)) == 0 {
-				continue
-			}
-
-			obj, _, err := decoder.Decode(manifest, nil, nil)
-			if err != nil {
-				continue // Skip invalid documents
-			}
-
-			// Extract replicas from StatefulSet [1][4]
-			if sts, ok := obj.(*appsv1.StatefulSet); ok {
-				expectedReplicas = *sts.Spec.Replicas
-				break
-			}
-		}
-
-		gomega.Expect(expectedReplicas).To(gomega.BeNumerically(">", 0),
-			"No StatefulSet found in manifest")
-
-		// Apply all manifests
-		logger.Info().Msgf("=== Applying Initial StatefulSet and Service manifest ===")
-		err = example.ApplyRawManifest(clientset, ssStartYAML)
+		expectedReplicas, err := fixtures.Int32Param("replicas")
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		logger.Info().Msgf("=== Waiting for Pods to schedule ===")
-		time.Sleep(100 * time.Second)
+		err = example.ApplyFixtureBundle(ctx, logger, manifestClient, fixtures)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		// Verify current StatefulSet status
-		currentSTS, err := clientset.AppsV1().StatefulSets("test-ns").Get(
-			context.TODO(),
+		currentSTS, err := clientset.AppsV1().StatefulSets(namespace).Get(
+			ctx,
 			"app",
 			metav1.GetOptions{},
 		)
@@ -138,42 +91,48 @@ var _ = ginkgo.Describe("StatefulSet Rolling Update E2E test", ginkgo.Ordered, g
 		)
 	})
 
-	ginkgo.It("should perform rolling update with updated CPU requests for StatefulSet", func() {
+	ginkgo.It("should perform rolling update with updated CPU requests for StatefulSet", func(ctx ginkgo.SpecContext) {
 
 		logger.Info().Msgf("=== Preparing StatefulSet rolling update with new CPU requests ===")
 
 		// Get existing StatefulSet
-		currentSTS, err := clientset.AppsV1().StatefulSets("test-ns").Get(
-			context.TODO(),
+		currentSTS, err := clientset.AppsV1().StatefulSets(namespace).Get(
+			ctx,
 			"app",
 			metav1.GetOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		// Update container spec CPU to new value
-		newSTS := currentSTS.DeepCopy()
-		newSTS.Spec.Template.Spec.Containers[0].Resources.Requests[v1.ResourceCPU] = resource.MustParse("100m")
+		limits, err := example.StatefulSetRolloutLimits(currentSTS)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		expectedReplicas := int32(limits.Replicas)
+		logger.Info().Msgf("=== StatefulSet Replicas: %d, MaxUnavailable: %d ===", limits.Replicas, limits.MaxUnavailable)
+
+		// Watch every pod transition, at most one pod may be down at a time
+		monitor := example.NewPodMonitor(logger, clientset, namespace, "app=app",
+			example.MaxUnavailable(limits.Replicas, limits.MaxUnavailable),
+		)
+		ginkgo.DeferCleanup(func() { monitor.Stop() })
+		gomega.Expect(monitor.Start(ctx)).To(gomega.Succeed())
+
+		// Declaratively bump the CPU request on the fields we own
+		stsApply, err := appsv1ac.ExtractStatefulSet(currentSTS, example.FieldManager)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		(*stsApply.Spec.Template.Spec.Containers[0].Resources.Requests)[v1.ResourceCPU] = resource.MustParse("100m")
 
 		logger.Info().Msgf("=== Triggering StatefulSet rolling update ===")
-		_, err = clientset.AppsV1().StatefulSets("test-ns").Update(
-			context.TODO(),
-			newSTS,
-			metav1.UpdateOptions{
-				FieldManager: "e2e-test",
+		_, err = clientset.AppsV1().StatefulSets(namespace).Apply(
+			ctx,
+			stsApply,
+			metav1.ApplyOptions{
+				FieldManager: example.FieldManager,
 			},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		expectedReplicas := *newSTS.Spec.Replicas
-		logger.Info().Msgf("=== StatefulSet Replicas: %d ===", expectedReplicas)
-
-		rolloutCheckNum := 1
-		lastLog := time.Now()
-		logInterval := 5 * time.Second
-
-		gomega.Eventually(func() error {
-			sts, err := clientset.AppsV1().StatefulSets("test-ns").Get(
-				context.TODO(),
+		gomega.Eventually(ctx, func() error {
+			sts, err := clientset.AppsV1().StatefulSets(namespace).Get(
+				ctx,
 				"app",
 				metav1.GetOptions{},
 			)
@@ -189,113 +148,22 @@ var _ = ginkgo.Describe("StatefulSet Rolling Update E2E test", ginkgo.Ordered, g
 				return nil // Rollout complete
 			}
 
-			// Monitor pod states
-			pods, err := clientset.CoreV1().Pods("test-ns").List(context.TODO(), metav1.ListOptions{
-				LabelSelector: "app=app",
-			})
-			if err != nil {
-				return err
-			}
-			if time.Since(lastLog) > logInterval {
-				logger.Info().Msgf("=== Sample checking rolling update status (attempt %d): ===\n", rolloutCheckNum)
-			}
-			var terminating, pending, runningNotReady, ready int
-			for _, pod := range pods.Items {
-				if pod.DeletionTimestamp != nil {
-					terminating++
-					if time.Since(lastLog) > logInterval {
-						logger.Info().Msgf("[Terminating] %s\n", pod.Name)
-					}
-					continue
-				}
-
-				switch pod.Status.Phase {
-				case v1.PodPending:
-					pending++
-					if time.Since(lastLog) > logInterval {
-						logger.Info().Msgf("[Pending] %s\n", pod.Name)
-					}
-				case v1.PodRunning:
-					isReady := false
-					for _, cond := range pod.Status.Conditions {
-						if cond.Type == v1.PodReady && cond.Status == v1.ConditionTrue {
-							isReady = true
-							break
-						}
-					}
-					if isReady {
-						ready++
-						if time.Since(lastLog) > logInterval {
-							logger.Info().Msgf("[Ready] %s\n", pod.Name)
-						}
-					} else {
-						runningNotReady++
-						if time.Since(lastLog) > logInterval {
-							logger.Info().Msgf("[RunningNotReady] %s\n", pod.Name)
-						}
-					}
-				}
-			}
-
-			totalPods := len(pods.Items)
-			if time.Since(lastLog) > logInterval {
-
-				logger.Info().Msgf("\nRollout Status:\n"+
-					"  Total Pods: %d\n"+
-					"  Ready: %d | RunningNotReady: %d | Pending: %d | Terminating: %d\n\n",
-					totalPods,
-					ready, runningNotReady, pending, terminating)
-				lastLog = time.Now()
-			}
-			// Validate minimum ready pods requirement
-			if ready < int(expectedReplicas)-1 {
-				return fmt.Errorf("ready pods %d < %d (replicas-1)", ready, expectedReplicas-1)
-			}
-
-			rolloutCheckNum++
+			logger.Info().Msgf("Rollout in progress: updated %d/%d, available %d, pod transitions observed: %d",
+				sts.Status.UpdatedReplicas, expectedReplicas,
+				sts.Status.AvailableReplicas, monitor.Transitions())
 			return fmt.Errorf("rollout in progress")
-		}, 5*time.Minute, 10*time.Millisecond).Should(gomega.Succeed(), "StatefulSet rollout timed out after 5 minutes")
-
-		// Final status report
-		logger.Info().Msgf("=== Final Rollout Status ===")
-		pods, err := clientset.CoreV1().Pods("test-ns").List(context.TODO(), metav1.ListOptions{
-			LabelSelector: "app=app",
-		})
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		var readyFinal, runningNotReadyFinal, pendingFinal, terminatingFinal int
-		for _, pod := range pods.Items {
-			if pod.DeletionTimestamp != nil {
-				terminatingFinal++
-				continue
-			}
+		}, 5*time.Minute, 5*time.Second).Should(gomega.Succeed(), "StatefulSet rollout timed out after 5 minutes")
 
-			isReady := false
-			for _, cond := range pod.Status.Conditions {
-				if cond.Type == v1.PodReady && cond.Status == v1.ConditionTrue {
-					isReady = true
-					break
-				}
-			}
-
-			switch pod.Status.Phase {
-			case v1.PodPending:
-				pendingFinal++
-			case v1.PodRunning:
-				if isReady {
-					readyFinal++
-				} else {
-					runningNotReadyFinal++
-				}
-			}
+		violations := monitor.Stop()
+		for _, violation := range violations {
+			logger.Error().Msgf("%s", violation)
 		}
+		gomega.Expect(violations).To(gomega.BeEmpty(), "StatefulSet rollout exceeded its maxUnavailable")
 
-		logger.Info().Msgf(
-			"  Total Pods: %d\n"+
-				"  Ready: %d | RunningNotReady: %d | Pending: %d | Terminating: %d\n\n",
-			len(pods.Items),
-			readyFinal, runningNotReadyFinal, pendingFinal, terminatingFinal,
-		)
+		// Final status report
+		snapshot, err := example.ListPodStateSnapshot(ctx, clientset, namespace, "app=app")
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		logger.Info().Msgf("=== Final Rollout Status === %s", snapshot.RolloutStatus(limits))
 	})
 
 })
@@ -0,0 +1,887 @@
+package example
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/rs/zerolog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+)
+
+// embeddedScenarios holds the scenario files shipped with the binary
+//
+//go:embed scenarios
+var embeddedScenarios embed.FS
+
+const scenariosDir = "scenarios"
+
+// ScenarioLabel is set on the specs of every scenario, so they can be
+// selected with --ginkgo.label-filter=scenario
+const ScenarioLabel = "scenario"
+
+var scenarioTagPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9-]*$`)
+
+var knownRequirements = []Requirement{RequirementZones, RequirementMetricsAPI, RequirementCPUHeadroom, RequirementRBAC, RequirementCordon}
+
+// defaultAPIVersions lets scenarios name the usual kinds without their apiVersion
+var defaultAPIVersions = map[string]string{
+	"Deployment":              "apps/v1",
+	"StatefulSet":             "apps/v1",
+	"ReplicaSet":              "apps/v1",
+	"DaemonSet":               "apps/v1",
+	"Pod":                     "v1",
+	"Service":                 "v1",
+	"ConfigMap":               "v1",
+	"Job":                     "batch/v1",
+	"PodDisruptionBudget":     "policy/v1",
+	"HorizontalPodAutoscaler": "autoscaling/v2",
+}
+
+var patchTypes = map[string]types.PatchType{
+	"merge":     types.MergePatchType,
+	"json":      types.JSONPatchType,
+	"strategic": types.StrategicMergePatchType,
+}
+
+// Scenario is a test declared in YAML: the manifests to apply, then steps
+// of actions followed by assertions. Each step becomes one spec of an
+// Ordered Describe labeled with the scenario tag, like the Go tests.
+type Scenario struct {
+	Name     string        `json:"name"`
+	Tag      string        `json:"tag"`
+	Labels   []string      `json:"labels,omitempty"`
+	Requires []Requirement `json:"requires,omitempty"`
+	// Fixtures is the test tag of a fixture bundle applied before the manifests
+	Fixtures  string            `json:"fixtures,omitempty"`
+	Manifests []json.RawMessage `json:"manifests,omitempty"`
+	Steps     []ScenarioStep    `json:"steps"`
+}
+
+// ScenarioStep runs its actions in order, then checks its assertions
+type ScenarioStep struct {
+	Name       string              `json:"name"`
+	Actions    []ScenarioAction    `json:"actions,omitempty"`
+	Assertions []ScenarioAssertion `json:"assertions,omitempty"`
+}
+
+// ScenarioAction sets exactly one of its fields
+type ScenarioAction struct {
+	Scale     *ScaleAction     `json:"scale,omitempty"`
+	Patch     *PatchAction     `json:"patch,omitempty"`
+	Evict     *EvictAction     `json:"evict,omitempty"`
+	Cordon    *CordonAction    `json:"cordon,omitempty"`
+	DeletePod *DeletePodAction `json:"deletePod,omitempty"`
+	Wait      *WaitAction      `json:"wait,omitempty"`
+}
+
+// ScaleAction sets the replicas of a Deployment or StatefulSet
+type ScaleAction struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Replicas int32  `json:"replicas"`
+}
+
+// PatchAction patches an object of the namespace, with a merge patch unless
+// type is json or strategic
+type PatchAction struct {
+	APIVersion string          `json:"apiVersion,omitempty"`
+	Kind       string          `json:"kind"`
+	Name       string          `json:"name"`
+	Type       string          `json:"type,omitempty"`
+	Patch      json.RawMessage `json:"patch"`
+}
+
+// EvictAction evicts the first count pods (all when 0) matching the
+// selector through the Eviction API, bounding what the PDBs let through
+type EvictAction struct {
+	Selector   string `json:"selector"`
+	Count      int    `json:"count,omitempty"`
+	MaxEvicted *int   `json:"maxEvicted,omitempty"`
+	MinBlocked *int   `json:"minBlocked,omitempty"`
+}
+
+// CordonAction marks nodes unschedulable until the step ends: the nodes
+// matching nodeSelector, or the nodes running the pods matching podSelector
+type CordonAction struct {
+	NodeSelector string `json:"nodeSelector,omitempty"`
+	PodSelector  string `json:"podSelector,omitempty"`
+}
+
+// DeletePodAction deletes the first count pods (all when 0) matching the selector
+type DeletePodAction struct {
+	Selector string `json:"selector"`
+	Count    int    `json:"count,omitempty"`
+}
+
+// WaitAction sleeps for duration, or waits for an object of the namespace
+// to reach a readiness gate condition
+type WaitAction struct {
+	Duration   metav1.Duration `json:"duration,omitempty"`
+	APIVersion string          `json:"apiVersion,omitempty"`
+	Kind       string          `json:"kind,omitempty"`
+	Name       string          `json:"name,omitempty"`
+	Condition  string          `json:"condition,omitempty"`
+	Timeout    metav1.Duration `json:"timeout,omitempty"`
+}
+
+// ScenarioAssertion sets exactly one check. Within retries the check until
+// it passes or the duration is over, it is checked once by default.
+type ScenarioAssertion struct {
+	PodCount         *PodCountAssertion         `json:"podCount,omitempty"`
+	ZonePlacement    *ZonePlacementAssertion    `json:"zonePlacement,omitempty"`
+	RolloutInvariant *RolloutInvariantAssertion `json:"rolloutInvariant,omitempty"`
+	EventPresent     *EventPresentAssertion     `json:"eventPresent,omitempty"`
+	Within           metav1.Duration            `json:"within,omitempty"`
+}
+
+// PodCountAssertion bounds the pods matching the selector in a state:
+// Ready (default), Running or Active
+type PodCountAssertion struct {
+	Selector string `json:"selector"`
+	State    string `json:"state,omitempty"`
+	Min      *int   `json:"min,omitempty"`
+	Max      *int   `json:"max,omitempty"`
+}
+
+// ZonePlacementAssertion checks how the pods matching the selector spread
+// over the zones (TOPOLOGY_KEY values) of the schedulable nodes
+type ZonePlacementAssertion struct {
+	Selector string `json:"selector"`
+	MinZones int    `json:"minZones,omitempty"`
+	MaxSkew  *int   `json:"maxSkew,omitempty"`
+}
+
+// RolloutInvariantAssertion watches the pods matching the selector while
+// the actions of the step run, like the rolling update tests do
+type RolloutInvariantAssertion struct {
+	Selector       string `json:"selector"`
+	Replicas       int    `json:"replicas,omitempty"`
+	MinReady       *int   `json:"minReady,omitempty"`
+	MaxSurge       *int   `json:"maxSurge,omitempty"`
+	MaxUnavailable *int   `json:"maxUnavailable,omitempty"`
+}
+
+// EventPresentAssertion looks for an event of the namespace with the given
+// reason, and the involved object kind, name and message substring when set
+type EventPresentAssertion struct {
+	Reason  string `json:"reason"`
+	Kind    string `json:"kind,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func (a ScenarioAction) String() string {
+	switch {
+	case a.Scale != nil:
+		return fmt.Sprintf("scale %s %s to %d", a.Scale.Kind, a.Scale.Name, a.Scale.Replicas)
+	case a.Patch != nil:
+		return fmt.Sprintf("patch %s %s", a.Patch.Kind, a.Patch.Name)
+	case a.Evict != nil:
+		return fmt.Sprintf("evict %s", podsTarget(a.Evict.Selector, a.Evict.Count))
+	case a.Cordon != nil && a.Cordon.PodSelector != "":
+		return fmt.Sprintf("cordon the nodes of pods %q", a.Cordon.PodSelector)
+	case a.Cordon != nil:
+		return fmt.Sprintf("cordon nodes %q", a.Cordon.NodeSelector)
+	case a.DeletePod != nil:
+		return fmt.Sprintf("delete %s", podsTarget(a.DeletePod.Selector, a.DeletePod.Count))
+	case a.Wait != nil && a.Wait.Kind != "":
+		return fmt.Sprintf("wait for %s %s %s", a.Wait.Kind, a.Wait.Name, a.Wait.Condition)
+	case a.Wait != nil:
+		return fmt.Sprintf("wait %s", a.Wait.Duration.Duration)
+	}
+	return "empty action"
+}
+
+func podsTarget(selector string, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("all pods %q", selector)
+	}
+	return fmt.Sprintf("%d pods %q", count, selector)
+}
+
+func (a ScenarioAssertion) String() string {
+	switch {
+	case a.PodCount != nil:
+		return fmt.Sprintf("podCount %s %q", podCountState(a.PodCount.State), a.PodCount.Selector)
+	case a.ZonePlacement != nil:
+		return fmt.Sprintf("zonePlacement %q", a.ZonePlacement.Selector)
+	case a.RolloutInvariant != nil:
+		return fmt.Sprintf("rolloutInvariant %q", a.RolloutInvariant.Selector)
+	case a.EventPresent != nil:
+		return fmt.Sprintf("eventPresent %s", a.EventPresent.Reason)
+	}
+	return "empty assertion"
+}
+
+func podCountState(state string) string {
+	if state == "" {
+		return "Ready"
+	}
+	return state
+}
+
+// ReadScenarioFiles reads the embedded scenario files, then those of
+// SCENARIOS_DIR, which replace the embedded ones with the same name
+func ReadScenarioFiles() (map[string][]byte, map[string]string, error) {
+	files := make(map[string][]byte)
+	sources := make(map[string]string)
+	if err := readFixtureDir(embeddedScenarios, scenariosDir, "embedded:"+scenariosDir, files, sources); err != nil {
+		return nil, nil, err
+	}
+	if dir := os.Getenv("SCENARIOS_DIR"); dir != "" {
+		if err := readFixtureDir(os.DirFS(dir), ".", dir, files, sources); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+	return files, sources, nil
+}
+
+// ParseScenario renders a scenario file with the given values, like the
+// fixtures, then decodes and validates it
+func ParseScenario(name string, content []byte, values ManifestValues) (*Scenario, error) {
+	rendered, err := RenderManifest(name, content, values)
+	if err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", name, err)
+	}
+	scenario := &Scenario{}
+	if err := utilyaml.UnmarshalStrict(rendered, scenario); err != nil {
+		return nil, fmt.Errorf("scenario %s: invalid YAML: %w", name, err)
+	}
+	if err := scenario.validate(); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", name, err)
+	}
+	// Cordoning patches nodes, preflight skips the scenario without the permission
+	if scenario.cordons() && !slices.Contains(scenario.Requires, RequirementCordon) {
+		scenario.Requires = append(scenario.Requires, RequirementCordon)
+	}
+	return scenario, nil
+}
+
+// cordons tells whether an action of the scenario cordons nodes
+func (s *Scenario) cordons() bool {
+	for _, step := range s.Steps {
+		for _, action := range step.Actions {
+			if action.Cordon != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *Scenario) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("no name set")
+	}
+	if !scenarioTagPattern.MatchString(s.Tag) {
+		return fmt.Errorf("tag %q must start with a letter and hold only letters, digits and dashes", s.Tag)
+	}
+	if _, ok := fixtureDirs[s.Tag]; ok {
+		return fmt.Errorf("tag %s is already used by a Go test", s.Tag)
+	}
+	for _, requirement := range s.Requires {
+		if !slices.Contains(knownRequirements, requirement) {
+			return fmt.Errorf("unknown requirement %q (known: %v)", requirement, knownRequirements)
+		}
+	}
+	if _, ok := fixtureDirs[s.Fixtures]; s.Fixtures != "" && !ok {
+		return fmt.Errorf("no fixtures registered for test %s", s.Fixtures)
+	}
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("no steps")
+	}
+
+	for i, step := range s.Steps {
+		if step.Name == "" {
+			return fmt.Errorf("step %d has no name", i+1)
+		}
+		for j, action := range step.Actions {
+			if err := action.validate(); err != nil {
+				return fmt.Errorf("step %q action %d: %w", step.Name, j+1, err)
+			}
+		}
+		for j, assertion := range step.Assertions {
+			if err := assertion.validate(); err != nil {
+				return fmt.Errorf("step %q assertion %d: %w", step.Name, j+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (a ScenarioAction) validate() error {
+	set := 0
+	for _, field := range []bool{a.Scale != nil, a.Patch != nil, a.Evict != nil, a.Cordon != nil, a.DeletePod != nil, a.Wait != nil} {
+		if field {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of scale, patch, evict, cordon, deletePod or wait must be set")
+	}
+
+	switch {
+	case a.Scale != nil:
+		if a.Scale.Kind != "Deployment" && a.Scale.Kind != "StatefulSet" {
+			return fmt.Errorf("scale: kind must be Deployment or StatefulSet, got %q", a.Scale.Kind)
+		}
+		if a.Scale.Name == "" {
+			return fmt.Errorf("scale: no name set")
+		}
+	case a.Patch != nil:
+		if _, ok := patchTypes[a.Patch.Type]; a.Patch.Type != "" && !ok {
+			return fmt.Errorf("patch: unknown type %q (merge, json or strategic)", a.Patch.Type)
+		}
+		if a.Patch.Name == "" || len(a.Patch.Patch) == 0 {
+			return fmt.Errorf("patch: name and patch must be set")
+		}
+		if _, err := apiVersionOf(a.Patch.APIVersion, a.Patch.Kind); err != nil {
+			return fmt.Errorf("patch: %w", err)
+		}
+	case a.Evict != nil:
+		if a.Evict.Selector == "" {
+			return fmt.Errorf("evict: no selector set")
+		}
+	case a.Cordon != nil:
+		if (a.Cordon.NodeSelector == "") == (a.Cordon.PodSelector == "") {
+			return fmt.Errorf("cordon: exactly one of nodeSelector or podSelector must be set")
+		}
+	case a.DeletePod != nil:
+		if a.DeletePod.Selector == "" {
+			return fmt.Errorf("deletePod: no selector set")
+		}
+	case a.Wait != nil:
+		if (a.Wait.Duration.Duration > 0) == (a.Wait.Kind != "") {
+			return fmt.Errorf("wait: exactly one of duration or kind must be set")
+		}
+		if a.Wait.Kind != "" {
+			if a.Wait.Name == "" || a.Wait.Condition == "" {
+				return fmt.Errorf("wait: name and condition must be set")
+			}
+			if _, err := apiVersionOf(a.Wait.APIVersion, a.Wait.Kind); err != nil {
+				return fmt.Errorf("wait: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func (a ScenarioAssertion) validate() error {
+	set := 0
+	for _, field := range []bool{a.PodCount != nil, a.ZonePlacement != nil, a.RolloutInvariant != nil, a.EventPresent != nil} {
+		if field {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of podCount, zonePlacement, rolloutInvariant or eventPresent must be set")
+	}
+
+	switch {
+	case a.PodCount != nil:
+		if a.PodCount.Selector == "" || (a.PodCount.Min == nil && a.PodCount.Max == nil) {
+			return fmt.Errorf("podCount: selector and min or max must be set")
+		}
+		if !slices.Contains([]string{"Ready", "Running", "Active"}, podCountState(a.PodCount.State)) {
+			return fmt.Errorf("podCount: state must be Ready, Running or Active, got %q", a.PodCount.State)
+		}
+	case a.ZonePlacement != nil:
+		if a.ZonePlacement.Selector == "" || (a.ZonePlacement.MinZones == 0 && a.ZonePlacement.MaxSkew == nil) {
+			return fmt.Errorf("zonePlacement: selector and minZones or maxSkew must be set")
+		}
+	case a.RolloutInvariant != nil:
+		invariant := a.RolloutInvariant
+		if invariant.Selector == "" || (invariant.MinReady == nil && invariant.MaxSurge == nil && invariant.MaxUnavailable == nil) {
+			return fmt.Errorf("rolloutInvariant: selector and minReady, maxSurge or maxUnavailable must be set")
+		}
+		if (invariant.MaxSurge != nil || invariant.MaxUnavailable != nil) && invariant.Replicas == 0 {
+			return fmt.Errorf("rolloutInvariant: maxSurge and maxUnavailable need replicas")
+		}
+	case a.EventPresent != nil:
+		if a.EventPresent.Reason == "" {
+			return fmt.Errorf("eventPresent: no reason set")
+		}
+	}
+	return nil
+}
+
+func apiVersionOf(apiVersion, kind string) (string, error) {
+	if apiVersion != "" {
+		return apiVersion, nil
+	}
+	if version, ok := defaultAPIVersions[kind]; ok {
+		return version, nil
+	}
+	return "", fmt.Errorf("no apiVersion set for kind %q", kind)
+}
+
+// ScenarioRun runs the steps of a scenario in the namespace of its values
+// and remembers what has to be undone when the scenario ends
+type ScenarioRun struct {
+	Scenario  *Scenario
+	logger    zerolog.Logger
+	clientset kubernetes.Interface
+	mc        *ManifestClient
+	values    ManifestValues
+	cordoned  []string
+}
+
+func NewScenarioRun(logger zerolog.Logger, clientset kubernetes.Interface, mc *ManifestClient, scenario *Scenario, values ManifestValues) *ScenarioRun {
+	return &ScenarioRun{
+		Scenario:  scenario,
+		logger:    logger,
+		clientset: clientset,
+		mc:        mc,
+		values:    values,
+	}
+}
+
+// Setup applies the fixture bundle of the scenario, then its manifests
+func (r *ScenarioRun) Setup(ctx context.Context) error {
+	if r.Scenario.Fixtures != "" {
+		bundle, err := LoadFixtureBundle(r.Scenario.Fixtures, r.values)
+		if err != nil {
+			return err
+		}
+		if err := ApplyFixtureBundle(ctx, r.logger, r.mc, bundle); err != nil {
+			return err
+		}
+	}
+	for i, manifest := range r.Scenario.Manifests {
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(manifest); err != nil {
+			return fmt.Errorf("manifest %d: %w", i+1, err)
+		}
+		if obj.GetNamespace() == "" {
+			obj.SetNamespace(r.values.Namespace)
+		}
+		content, err := obj.MarshalJSON()
+		if err != nil {
+			return fmt.Errorf("manifest %d: %w", i+1, err)
+		}
+		r.logger.Info().Msgf("=== Applying %s %s ===", obj.GetKind(), obj.GetName())
+		if err := ApplyRawManifest(ctx, r.mc, content); err != nil {
+			return fmt.Errorf("apply of %s %s failed: %w", obj.GetKind(), obj.GetName(), err)
+		}
+	}
+	return nil
+}
+
+// RunStep watches the pods of the rollout invariants, runs the actions and
+// then checks every assertion, reporting all the failed ones
+func (r *ScenarioRun) RunStep(ctx context.Context, step ScenarioStep) error {
+	monitors := make(map[int]*PodMonitor)
+	defer func() {
+		for _, monitor := range monitors {
+			monitor.Stop()
+		}
+	}()
+	for i, assertion := range step.Assertions {
+		if assertion.RolloutInvariant == nil {
+			continue
+		}
+		monitor := NewPodMonitor(r.logger, r.clientset, r.values.Namespace, assertion.RolloutInvariant.Selector,
+			rolloutInvariants(*assertion.RolloutInvariant)...)
+		monitors[i] = monitor
+		if err := monitor.Start(ctx); err != nil {
+			return fmt.Errorf("assertion %d (%s): %w", i+1, assertion, err)
+		}
+	}
+
+	for i, action := range step.Actions {
+		r.logger.Info().Msgf("=== Action %d: %s ===", i+1, action)
+		if err := r.runAction(ctx, action); err != nil {
+			return fmt.Errorf("action %d (%s): %w", i+1, action, err)
+		}
+	}
+
+	var errs []error
+	for i, assertion := range step.Assertions {
+		var err error
+		if monitor, ok := monitors[i]; ok {
+			err = violationsError(monitor.Stop())
+		} else {
+			err = r.checkWithin(ctx, assertion)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("assertion %d (%s): %w", i+1, assertion, err))
+			continue
+		}
+		r.logger.Info().Msgf("Assertion %d passed: %s", i+1, assertion)
+	}
+	return errors.Join(errs...)
+}
+
+// Cleanup uncordons the nodes the scenario still holds cordoned
+func (r *ScenarioRun) Cleanup(ctx context.Context) {
+	for _, node := range slices.Clone(r.cordoned) {
+		r.uncordon(ctx, node)
+	}
+	r.cordoned = nil
+}
+
+// uncordon undoes a cordon of the scenario, nodes it did not cordon or
+// already uncordoned are left alone
+func (r *ScenarioRun) uncordon(ctx context.Context, node string) {
+	i := slices.Index(r.cordoned, node)
+	if i < 0 {
+		return
+	}
+	if err := setCordon(ctx, r.clientset, node, false); err != nil {
+		r.logger.Error().Msgf("Failed to uncordon node %s: %v", node, err)
+		return
+	}
+	r.cordoned = slices.Delete(r.cordoned, i, i+1)
+	r.logger.Info().Msgf("=== Uncordoned node %s ===", node)
+}
+
+func (r *ScenarioRun) runAction(ctx context.Context, action ScenarioAction) error {
+	namespace := r.values.Namespace
+
+	switch {
+	case action.Scale != nil:
+		patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, action.Scale.Replicas))
+		var err error
+		if action.Scale.Kind == "Deployment" {
+			_, err = r.clientset.AppsV1().Deployments(namespace).Patch(ctx, action.Scale.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		} else {
+			_, err = r.clientset.AppsV1().StatefulSets(namespace).Patch(ctx, action.Scale.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		}
+		return err
+
+	case action.Patch != nil:
+		obj, err := r.namespacedObject(action.Patch.APIVersion, action.Patch.Kind, action.Patch.Name)
+		if err != nil {
+			return err
+		}
+		resource, err := r.mc.resourceFor(obj)
+		if err != nil {
+			return fmt.Errorf("cannot resolve %s: %w", obj.GroupVersionKind(), err)
+		}
+		patchType := types.MergePatchType
+		if action.Patch.Type != "" {
+			patchType = patchTypes[action.Patch.Type]
+		}
+		_, err = resource.Patch(ctx, obj.GetName(), patchType, action.Patch.Patch, metav1.PatchOptions{FieldManager: FieldManager})
+		return err
+
+	case action.Evict != nil:
+		pods, err := r.listPods(ctx, action.Evict.Selector, action.Evict.Count)
+		if err != nil {
+			return err
+		}
+		result := EvictPods(ctx, r.logger, r.clientset, pods)
+		if len(result.Failed) > 0 {
+			return fmt.Errorf("evictions failed for reasons other than a PDB: %v", result.Failed)
+		}
+		if action.Evict.MaxEvicted != nil && len(result.Evicted) > *action.Evict.MaxEvicted {
+			return fmt.Errorf("%d pods evicted, expected at most %d", len(result.Evicted), *action.Evict.MaxEvicted)
+		}
+		if action.Evict.MinBlocked != nil && len(result.Blocked) < *action.Evict.MinBlocked {
+			return fmt.Errorf("%d evictions blocked by a PDB, expected at least %d", len(result.Blocked), *action.Evict.MinBlocked)
+		}
+		return nil
+
+	case action.Cordon != nil:
+		nodes, err := r.cordonTargets(ctx, *action.Cordon)
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			if node.Spec.Unschedulable || slices.Contains(r.cordoned, node.Name) {
+				continue
+			}
+			if err := setCordon(ctx, r.clientset, node.Name, true); err != nil {
+				return fmt.Errorf("failed to cordon node %s: %w", node.Name, err)
+			}
+			r.cordoned = append(r.cordoned, node.Name)
+			r.logger.Info().Msgf("[Cordoned] %s", node.Name)
+			// Undone at the end of the step, even when it fails or is interrupted
+			ginkgo.DeferCleanup(r.uncordon, node.Name)
+		}
+		return nil
+
+	case action.DeletePod != nil:
+		pods, err := r.listPods(ctx, action.DeletePod.Selector, action.DeletePod.Count)
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			if err := r.clientset.CoreV1().Pods(namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{}); err != nil {
+				return fmt.Errorf("failed to delete pod %s: %w", pod.Name, err)
+			}
+			r.logger.Info().Msgf("[Deleted] %s", pod.Name)
+		}
+		return nil
+
+	case action.Wait != nil && action.Wait.Kind == "":
+		return sleepContext(ctx, action.Wait.Duration.Duration)
+
+	case action.Wait != nil:
+		obj, err := r.namespacedObject(action.Wait.APIVersion, action.Wait.Kind, action.Wait.Name)
+		if err != nil {
+			return err
+		}
+		return waitForGate(ctx, r.logger, r.mc, obj, ReadinessGate{
+			Kind:      action.Wait.Kind,
+			Name:      action.Wait.Name,
+			Condition: action.Wait.Condition,
+			Timeout:   action.Wait.Timeout,
+		})
+	}
+	return fmt.Errorf("empty action")
+}
+
+func (r *ScenarioRun) namespacedObject(apiVersion, kind, name string) (*unstructured.Unstructured, error) {
+	apiVersion, err := apiVersionOf(apiVersion, kind)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetName(name)
+	obj.SetNamespace(r.values.Namespace)
+	return obj, nil
+}
+
+// listPods returns the first count pods matching the selector by name, all
+// of them when count is 0, leaving out the terminating ones
+func (r *ScenarioRun) listPods(ctx context.Context, selector string, count int) ([]corev1.Pod, error) {
+	list, err := r.clientset.CoreV1().Pods(r.values.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods %q in %s: %w", selector, r.values.Namespace, err)
+	}
+	var pods []corev1.Pod
+	for _, pod := range list.Items {
+		if pod.DeletionTimestamp == nil {
+			pods = append(pods, pod)
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	if count > 0 && count < len(pods) {
+		pods = pods[:count]
+	}
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no pod matches %q in %s", selector, r.values.Namespace)
+	}
+	return pods, nil
+}
+
+func (r *ScenarioRun) cordonTargets(ctx context.Context, action CordonAction) ([]corev1.Node, error) {
+	if action.NodeSelector != "" {
+		nodes, err := r.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: action.NodeSelector})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nodes %q: %w", action.NodeSelector, err)
+		}
+		if len(nodes.Items) == 0 {
+			return nil, fmt.Errorf("no node matches %q", action.NodeSelector)
+		}
+		return nodes.Items, nil
+	}
+
+	pods, err := r.listPods(ctx, action.PodSelector, 0)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []corev1.Node
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || slices.ContainsFunc(nodes, func(node corev1.Node) bool { return node.Name == pod.Spec.NodeName }) {
+			continue
+		}
+		node, err := r.clientset.CoreV1().Nodes().Get(ctx, pod.Spec.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get node %s: %w", pod.Spec.NodeName, err)
+		}
+		nodes = append(nodes, *node)
+	}
+	return nodes, nil
+}
+
+// CordonedByAnnotation marks the nodes a scenario cordoned with the run ID, so
+// the cordons left by a killed run can be found and undone
+const CordonedByAnnotation = "cluster-tester/cordoned-by"
+
+// setCordon cordons a node and annotates it with the run ID, or uncordons it
+// and removes the annotation
+func setCordon(ctx context.Context, clientset kubernetes.Interface, node string, cordon bool) error {
+	var cordonedBy interface{}
+	if cordon {
+		cordonedBy = RunID
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{CordonedByAnnotation: cordonedBy}},
+		"spec":     map[string]interface{}{"unschedulable": cordon},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = clientset.CoreV1().Nodes().Patch(ctx, node, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func rolloutInvariants(assertion RolloutInvariantAssertion) []Invariant {
+	var invariants []Invariant
+	if assertion.MinReady != nil {
+		invariants = append(invariants, MinReady(*assertion.MinReady))
+	}
+	if assertion.MaxSurge != nil {
+		invariants = append(invariants, MaxSurge(assertion.Replicas, *assertion.MaxSurge))
+	}
+	if assertion.MaxUnavailable != nil {
+		invariants = append(invariants, MaxUnavailable(assertion.Replicas, *assertion.MaxUnavailable))
+	}
+	return invariants
+}
+
+func violationsError(violations []Violation) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	var messages []string
+	for _, violation := range violations {
+		messages = append(messages, violation.String())
+	}
+	return fmt.Errorf("%d violations: %s", len(violations), strings.Join(messages, "; "))
+}
+
+// checkWithin retries an assertion until it passes or its within duration is over
+func (r *ScenarioRun) checkWithin(ctx context.Context, assertion ScenarioAssertion) error {
+	if assertion.Within.Duration <= 0 {
+		return r.check(ctx, assertion)
+	}
+
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, gatePollInterval, assertion.Within.Duration, true, func(ctx context.Context) (bool, error) {
+		err := r.check(ctx, assertion)
+		// A check cut short by the timeout keeps the last failure
+		if err != nil && ctx.Err() == nil {
+			lastErr = err
+			r.logger.Info().Msgf("Waiting for %s: %v", assertion, err)
+		}
+		return err == nil, nil
+	})
+	switch {
+	case err == nil:
+		return nil
+	case lastErr == nil:
+		return err
+	case ctx.Err() != nil:
+		return fmt.Errorf("%w: %w", ctx.Err(), lastErr)
+	}
+	return lastErr
+}
+
+func (r *ScenarioRun) check(ctx context.Context, assertion ScenarioAssertion) error {
+	namespace := r.values.Namespace
+
+	switch {
+	case assertion.PodCount != nil:
+		podCount := assertion.PodCount
+		snapshot, err := ListPodStateSnapshot(ctx, r.clientset, namespace, podCount.Selector)
+		if err != nil {
+			return err
+		}
+		count := snapshot.Ready
+		switch podCountState(podCount.State) {
+		case "Running":
+			count = snapshot.Running()
+		case "Active":
+			count = snapshot.Active()
+		}
+		if podCount.Min != nil && count < *podCount.Min {
+			return fmt.Errorf("%d pods, expected at least %d (%s)", count, *podCount.Min, snapshot)
+		}
+		if podCount.Max != nil && count > *podCount.Max {
+			return fmt.Errorf("%d pods, expected at most %d (%s)", count, *podCount.Max, snapshot)
+		}
+		return nil
+
+	case assertion.ZonePlacement != nil:
+		return r.checkZonePlacement(ctx, *assertion.ZonePlacement)
+
+	case assertion.EventPresent != nil:
+		expected := assertion.EventPresent
+		events, err := r.clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to list events in %s: %w", namespace, err)
+		}
+		for _, event := range events.Items {
+			if event.Reason == expected.Reason &&
+				(expected.Kind == "" || event.InvolvedObject.Kind == expected.Kind) &&
+				(expected.Name == "" || event.InvolvedObject.Name == expected.Name) &&
+				strings.Contains(event.Message, expected.Message) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no matching event among the %d of %s", len(events.Items), namespace)
+	}
+	return fmt.Errorf("empty assertion")
+}
+
+// checkZonePlacement counts the scheduled pods per zone. Every zone of a
+// schedulable node is a domain, so a zone left without pods counts in the skew.
+func (r *ScenarioRun) checkZonePlacement(ctx context.Context, assertion ZonePlacementAssertion) error {
+	topologyKey := r.values.TopologyKey
+
+	nodes, err := r.clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	podsPerZone := make(map[string]int)
+	nodeZones := make(map[string]string)
+	for _, node := range nodes.Items {
+		zone, ok := node.Labels[topologyKey]
+		if !ok {
+			continue
+		}
+		nodeZones[node.Name] = zone
+		if _, ok := podsPerZone[zone]; !ok && schedulable(node) {
+			podsPerZone[zone] = 0
+		}
+	}
+
+	pods, err := r.listPods(ctx, assertion.Selector, 0)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		if zone, ok := nodeZones[pod.Spec.NodeName]; ok {
+			podsPerZone[zone]++
+		}
+	}
+
+	zones, minCount, maxCount := 0, -1, 0
+	for _, count := range podsPerZone {
+		if count > 0 {
+			zones++
+		}
+		if minCount < 0 || count < minCount {
+			minCount = count
+		}
+		maxCount = max(maxCount, count)
+	}
+	if zones < assertion.MinZones {
+		return fmt.Errorf("pods in %d zones, expected at least %d (pods per %s: %v)", zones, assertion.MinZones, topologyKey, podsPerZone)
+	}
+	if skew := maxCount - max(minCount, 0); assertion.MaxSkew != nil && skew > *assertion.MaxSkew {
+		return fmt.Errorf("skew %d > %d (pods per %s: %v)", skew, *assertion.MaxSkew, topologyKey, podsPerZone)
+	}
+	return nil
+}
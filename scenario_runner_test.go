@@ -0,0 +1,121 @@
+package example_test
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	"k8s.io/client-go/kubernetes"
+
+	"example"
+)
+
+// Every scenario file becomes an Ordered Describe tagged like the Go tests,
+// so ALLOWED_TO_FAIL and the reports treat both the same way
+var _ = describeScenarios()
+
+func describeScenarios() bool {
+	files, sources, err := example.ReadScenarioFiles()
+	if err != nil {
+		describeInvalidScenario("Scenarios", err)
+		return true
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// The tree is built before the namespace exists, the scenarios are
+	// rendered again with the real values in BeforeAll
+	tags := make(map[string]string)
+	for _, name := range names {
+		scenario, err := example.ParseScenario(name, files[name], example.ManifestValues{})
+		if err == nil && tags[scenario.Tag] != "" {
+			err = fmt.Errorf("scenario %s: tag %s is already used by %s", name, scenario.Tag, tags[scenario.Tag])
+		}
+		if err != nil {
+			describeInvalidScenario(strings.TrimSuffix(name, path.Ext(name)), fmt.Errorf("%w (checked: %s)", err, sources[name]))
+			continue
+		}
+		tags[scenario.Tag] = name
+		describeScenario(name, files[name], scenario)
+	}
+	return true
+}
+
+// describeInvalidScenario reports a scenario that cannot be run as a failed test
+func describeInvalidScenario(testTag string, err error) {
+	ginkgo.Describe("Scenario "+testTag, ginkgo.Label(example.ScenarioLabel), example.TestTag(testTag), func() {
+		ginkgo.It("should load the scenario", func() {
+			ginkgo.Fail(err.Error())
+		})
+	})
+}
+
+func describeScenario(name string, content []byte, scenario *example.Scenario) {
+	labels := append(ginkgo.Label(example.ScenarioLabel), scenario.Labels...)
+
+	ginkgo.Describe(scenario.Name, ginkgo.Ordered, labels, example.TestTag(scenario.Tag), example.Requires(scenario.Requires...), func() {
+		var (
+			clientset kubernetes.Interface
+			run       *example.ScenarioRun
+			namespace string
+			logger    zerolog.Logger
+			testTag   = scenario.Tag
+		)
+
+		ginkgo.BeforeAll(func(ctx ginkgo.SpecContext) {
+			suite, err := example.Config()
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			clientset = suite.Clientset
+
+			logger = example.GetLogger(testTag)
+			logger.Info().Msgf("=== Starting scenario %s ===", name)
+			logger.Info().Msgf("=== tag: %s, allowed to fail: %t", testTag, example.IsTestAllowedToFail(testTag))
+
+			values := suite.Values
+			logger.Info().Msgf("=== Manifest values: %s ===", values)
+
+			// Skip before creating anything when the cluster lacks a declared requirement
+			err = example.SkipUnmetRequirements(ctx, logger, clientset, values)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+			namespace, err = example.CreateTestNamespace(ctx, logger, clientset, testTag)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			values.Namespace = namespace
+
+			rendered, err := example.ParseScenario(name, content, values)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			run = example.NewScenarioRun(logger, clientset, suite.ManifestClient, rendered, values)
+			gomega.Expect(run.Setup(ctx)).To(gomega.Succeed())
+		})
+
+		ginkgo.AfterEach(func(ctx ginkgo.SpecContext) {
+			// Capture the evidence before AfterAll clears the namespace
+			if ginkgo.CurrentSpecReport().Failed() {
+				example.CollectFailureDiagnostics(ctx, logger, clientset, namespace, testTag)
+			}
+		})
+
+		ginkgo.AfterAll(func(ctx ginkgo.SpecContext) {
+			// The steps undo their own cordons, this catches any left over
+			if run != nil {
+				run.Cleanup(ctx)
+			}
+			example.ClearNamespace(ctx, logger, clientset, namespace)
+		})
+
+		for i, step := range scenario.Steps {
+			ginkgo.It(step.Name, func(ctx ginkgo.SpecContext) {
+				logger.Info().Msgf("=== Step %d/%d: %s ===", i+1, len(scenario.Steps), step.Name)
+				gomega.Expect(run.RunStep(ctx, run.Scenario.Steps[i])).To(gomega.Succeed())
+			})
+		}
+	})
+}
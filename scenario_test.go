@@ -0,0 +1,207 @@
+package example_test
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/rs/zerolog"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"example"
+)
+
+func scenarioPod(name, node string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "scenario-ns", Labels: map[string]string{"app": "app"}},
+		Spec:       v1.PodSpec{NodeName: node},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func parseStep(content string) example.ScenarioStep {
+	scenario, err := example.ParseScenario("step.yaml", []byte("name: Step\ntag: StepScenario\nsteps:\n"+content), example.ManifestValues{})
+	gomega.Expect(err).NotTo(gomega.HaveOccurred())
+	return scenario.Steps[0]
+}
+
+var _ = ginkgo.Describe("Scenarios", ginkgo.Label("unit"), func() {
+	values := example.ManifestValues{
+		Namespace:   "scenario-ns",
+		Image:       "nginx:alpine",
+		TopologyKey: zoneKey,
+	}
+
+	ginkgo.It("should parse the embedded scenarios", func() {
+		files, sources, err := example.ReadScenarioFiles()
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(files).NotTo(gomega.BeEmpty())
+
+		for name, content := range files {
+			scenario, err := example.ParseScenario(name, content, values)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred(), sources[name])
+			gomega.Expect(scenario.Steps).NotTo(gomega.BeEmpty(), sources[name])
+		}
+	})
+
+	ginkgo.It("should require the cordon permission of the scenarios that cordon nodes", func() {
+		scenario, err := example.ParseScenario("cordon.yaml", []byte(`name: Cordon
+tag: CordonScenario
+requires: [zones]
+steps:
+- name: step
+  actions:
+  - cordon: {nodeSelector: pool=spot}
+`), values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(scenario.Requires).To(gomega.Equal([]example.Requirement{example.RequirementZones, example.RequirementCordon}))
+	})
+
+	ginkgo.DescribeTable("should reject invalid scenarios",
+		func(content, expected string) {
+			_, err := example.ParseScenario("invalid.yaml", []byte(content), values)
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(expected)))
+		},
+		ginkgo.Entry("missing tag", "name: No tag\nsteps:\n- name: step\n", `tag "" must start with a letter`),
+		ginkgo.Entry("tag of a Go test", "name: Taken\ntag: DeploymentPDBTest\nsteps:\n- name: step\n", "tag DeploymentPDBTest is already used by a Go test"),
+		ginkgo.Entry("unknown requirement", "name: R\ntag: R\nrequires: [gpu]\nsteps:\n- name: step\n", `unknown requirement "gpu"`),
+		ginkgo.Entry("unknown field", "name: F\ntag: F\nsteps:\n- name: step\n  action: []\n", `unknown field "action"`),
+		ginkgo.Entry("two actions in one entry", "name: A\ntag: A\nsteps:\n- name: step\n  actions:\n  - scale: {kind: Deployment, name: app, replicas: 2}\n    deletePod: {selector: app=app}\n",
+			`step "step" action 1: exactly one of scale, patch, evict, cordon, deletePod or wait must be set`),
+		ginkgo.Entry("surge without replicas", "name: S\ntag: S\nsteps:\n- name: step\n  assertions:\n  - rolloutInvariant: {selector: app=app, maxSurge: 1}\n",
+			"rolloutInvariant: maxSurge and maxUnavailable need replicas"),
+		ginkgo.Entry("template error", "name: T\ntag: T\nsteps:\n- name: {{ .Unknown }}\n", "template render failed"),
+	)
+
+	ginkgo.Context("when running steps", func() {
+		var (
+			clientset *fake.Clientset
+			run       *example.ScenarioRun
+		)
+
+		ginkgo.BeforeEach(func() {
+			replicas := int32(3)
+			clientset = fake.NewSimpleClientset(
+				zonedNode("node-a", "zone-a", "2"),
+				zonedNode("node-b", "zone-b", "2"),
+				zonedNode("node-c", "zone-c", "2"),
+				&appsv1.Deployment{
+					ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "scenario-ns"},
+					Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+				},
+				scenarioPod("app-0", "node-a"),
+				scenarioPod("app-1", "node-b"),
+				scenarioPod("app-2", "node-b"),
+				&v1.Event{
+					ObjectMeta:     metav1.ObjectMeta{Name: "app.1", Namespace: "scenario-ns"},
+					InvolvedObject: v1.ObjectReference{Kind: "Deployment", Name: "app"},
+					Reason:         "ScalingReplicaSet",
+					Message:        "Scaled up replica set app-5d4f to 3",
+				},
+			)
+			run = example.NewScenarioRun(zerolog.Nop(), clientset, nil, &example.Scenario{}, values)
+		})
+
+		ginkgo.It("should run the actions, check the assertions and undo the cordons", func(ctx ginkgo.SpecContext) {
+			step := parseStep(`- name: step
+  actions:
+  - scale: {kind: Deployment, name: app, replicas: 5}
+  - cordon: {podSelector: app=app}
+  - deletePod: {selector: app=app, count: 1}
+  assertions:
+  - podCount: {selector: app=app, min: 2, max: 2}
+  - zonePlacement: {selector: app=app, minZones: 1, maxSkew: 2}
+  - eventPresent: {reason: ScalingReplicaSet, kind: Deployment, message: Scaled up}
+`)
+
+			gomega.Expect(run.RunStep(ctx, step)).To(gomega.Succeed())
+
+			deployment, err := clientset.AppsV1().Deployments("scenario-ns").Get(ctx, "app", metav1.GetOptions{})
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			gomega.Expect(*deployment.Spec.Replicas).To(gomega.Equal(int32(5)))
+			_, err = clientset.CoreV1().Pods("scenario-ns").Get(ctx, "app-0", metav1.GetOptions{})
+			gomega.Expect(apierrors.IsNotFound(err)).To(gomega.BeTrue())
+			for node, cordoned := range map[string]bool{"node-a": true, "node-b": true, "node-c": false} {
+				live, err := clientset.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+				gomega.Expect(err).NotTo(gomega.HaveOccurred())
+				gomega.Expect(live.Spec.Unschedulable).To(gomega.Equal(cordoned), node)
+				if cordoned {
+					gomega.Expect(live.Annotations).To(gomega.HaveKeyWithValue(example.CordonedByAnnotation, example.RunID), node)
+				}
+			}
+
+			run.Cleanup(ctx)
+			nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			for _, node := range nodes.Items {
+				gomega.Expect(node.Spec.Unschedulable).To(gomega.BeFalse(), node.Name)
+				gomega.Expect(node.Annotations).NotTo(gomega.HaveKey(example.CordonedByAnnotation), node.Name)
+			}
+		})
+
+		ginkgo.It("should report every failed assertion", func(ctx ginkgo.SpecContext) {
+			step := parseStep(`- name: step
+  assertions:
+  - podCount: {selector: app=app, min: 4}
+  - zonePlacement: {selector: app=app, minZones: 2, maxSkew: 1}
+  - eventPresent: {reason: FailedScheduling}
+`)
+
+			err := run.RunStep(ctx, step)
+
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(`assertion 1 (podCount Ready "app=app"): 3 pods, expected at least 4`)))
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(`assertion 2 (zonePlacement "app=app"): skew 2 > 1 (pods per topology.kubernetes.io/zone: map[zone-a:1 zone-b:2 zone-c:0])`)))
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("assertion 3 (eventPresent FailedScheduling): no matching event")))
+		})
+
+		ginkgo.It("should bound the evictions a PDB lets through", func(ctx ginkgo.SpecContext) {
+			evictions := 0
+			clientset.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if action.GetSubresource() != "eviction" {
+					return false, nil, nil
+				}
+				evictions++
+				if evictions > 1 {
+					return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+				}
+				return true, nil, nil
+			})
+
+			err := run.RunStep(ctx, parseStep(`- name: step
+  actions:
+  - evict: {selector: app=app, maxEvicted: 1, minBlocked: 2}
+`))
+			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+			evictions = 0
+			err = run.RunStep(ctx, parseStep(`- name: step
+  actions:
+  - evict: {selector: app=app, maxEvicted: 0}
+`))
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(`action 1 (evict all pods "app=app"): 1 pods evicted, expected at most 0`)))
+		})
+
+		ginkgo.It("should fail actions targeting missing objects", func(ctx ginkgo.SpecContext) {
+			err := run.RunStep(ctx, parseStep(`- name: step
+  actions:
+  - deletePod: {selector: app=missing}
+`))
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(`no pod matches "app=missing" in scenario-ns`)))
+
+			err = run.RunStep(ctx, parseStep(`- name: step
+  actions:
+  - scale: {kind: StatefulSet, name: app, replicas: 2}
+`))
+			gomega.Expect(apierrors.IsNotFound(err)).To(gomega.BeTrue())
+			gomega.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(schema.GroupResource{Group: "apps", Resource: "statefulsets"}.String())))
+		})
+	})
+})
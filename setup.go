@@ -2,49 +2,70 @@ package example
 
 import (
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/ginkgo/v2/types"
 	"github.com/rs/zerolog"
 
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
 )
 
 var Logger zerolog.Logger
-var LogBuffer *bytes.Buffer
-var KubeconfigPath string
-var AllowedToFailTags []string
 
-func parseAllowedToFailTags() error {
-	err := godotenv.Load(".env")
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("error loading .env file: %w", err)
-	}
+// LogBuffer keeps every log line of the process for the final report. The
+// specs, informers, retried requests and cluster runs all log concurrently.
+var LogBuffer *SyncBuffer
 
-	allowedToFailStr := os.Getenv("ALLOWED_TO_FAIL")
-	if allowedToFailStr != "" {
-		AllowedToFailTags = strings.Split(allowedToFailStr, ",")
-		for i, tag := range AllowedToFailTags {
-			AllowedToFailTags[i] = strings.TrimSpace(tag)
-		}
-	}
+// SyncBuffer is a bytes.Buffer safe for concurrent writes and reads
+type SyncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *SyncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of the buffered content
+func (b *SyncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
 
-	return nil
+func (b *SyncBuffer) String() string {
+	return string(b.Bytes())
 }
 
+func (b *SyncBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+// AllowedToFailTags are the tests whose failure does not fail the run, set
+// from ALLOWED_TO_FAIL when the suite configuration loads
+var AllowedToFailTags []string
+
+// RunID identifies this run of the suite, every namespace it creates is labeled with it
+var RunID string
+
 func init() {
-	LogBuffer = new(bytes.Buffer)
+	RunID = fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), utilrand.String(5))
+	LogBuffer = new(SyncBuffer)
 	consoleWriter := zerolog.ConsoleWriter{
 		Out:        os.Stdout,
 		NoColor:    true,
@@ -66,10 +87,6 @@ func init() {
 		With().
 		Timestamp().
 		Logger()
-
-	if err := parseAllowedToFailTags(); err != nil {
-		fmt.Printf("Warning: Failed to parse ALLOWED_TO_FAIL tags: %v", err)
-	}
 }
 
 func GetLogger(tag string) zerolog.Logger {
@@ -90,340 +107,337 @@ func IsTestAllowedToFail(testTag string) bool {
 	return slices.Contains(AllowedToFailTags, testTag)
 }
 
-func initKubeconfig() error {
-	// Try to load .env file
-	err := godotenv.Load(".env")
-	if err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("error loading .env file: %w", err)
-	}
-
-	// Get kubeconfig path from environment
-	KubeconfigPath = os.Getenv("KUBECONFIG")
+// UnitLabel marks the specs that never talk to a cluster
+const UnitLabel = "unit"
 
-	// Fallback to default if not set
-	if KubeconfigPath == "" {
-		if os.IsNotExist(err) { // .env doesn't exist
-			home := homedir.HomeDir()
-			if home == "" {
-				return fmt.Errorf("no home directory found")
-			}
-			KubeconfigPath = filepath.Join(home, ".kube", "config")
-		} else { // .env exists but KUBECONFIG is empty
-			panic(".env file format error, please use KUBECONFIG=/path/to/.kube/config")
-		}
+// needsCluster tells whether a label filter selects specs other than the
+// unit ones. Every cluster spec is labeled safe-in-production or scenario.
+func needsCluster(labelFilter string) bool {
+	if labelFilter == "" {
+		return true
 	}
-
-	// Verify kubeconfig file exists
-	if _, err := os.Stat(KubeconfigPath); err != nil {
-		return fmt.Errorf("kubeconfig not found: %w (checked: %s)", err, KubeconfigPath)
-	}
-
-	return nil
-}
-
-func getLocalClusterAPICreds() (*rest.Config, error) {
-	// In-cluster configuration (auto-mounted)
-	tokenPath := "/var/run/secrets/kubernetes.io/serviceaccount/token"
-	caPath := "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
-
-	token, err := os.ReadFile(tokenPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed reading token: %w", err)
-	}
-
-	caCert, err := os.ReadFile(caPath)
+	filter, err := types.ParseLabelFilter(labelFilter)
 	if err != nil {
-		return nil, fmt.Errorf("failed reading CA cert: %w", err)
+		return true
 	}
-
-	return &rest.Config{
-		Host:        "https://kubernetes.default.svc",
-		BearerToken: string(token),
-		TLSClientConfig: rest.TLSClientConfig{
-			CAData: caCert,
-		},
-	}, nil
+	return !filter([]string{UnitLabel}) ||
+		filter([]string{"safe-in-production"}) || filter([]string{ScenarioLabel})
 }
 
-func getExternalClusterAPICreds() (*rest.Config, error) {
-	apiURL := os.Getenv("K8S_API_URL")
-	if apiURL == "" {
-		return nil, fmt.Errorf("K8S_API_URL environment variable not set")
-	}
-
-	token := os.Getenv("K8S_TOKEN")
-	if token == "" {
-		return nil, fmt.Errorf("K8S_TOKEN environment variable not set")
-	}
-
-	caCert := os.Getenv("K8S_CA_CERT")
-	if caCert == "" {
-		return nil, fmt.Errorf("K8S_CA_CERT environment variable not set")
-	}
-
-	// Process escaped newlines in CA certificate
-	caCert = strings.ReplaceAll(caCert, "\\n", "\n")
-
-	caCertBytes, err := base64.StdEncoding.DecodeString(caCert)
-	if err != nil {
-		return nil, fmt.Errorf("CA cert decoding failed: %w", err)
-	}
+// ManifestValues are substituted into the fixture YAMLs, which are rendered
+// as Go templates before they are decoded. Zero Replicas/MaxReplicas keep the
+// default written in each fixture. Namespace is not read from the
+// environment, each Describe sets it to the namespace it created.
+type ManifestValues struct {
+	Namespace      string
+	Image          string
+	RegistryPrefix string
+	Replicas       int32
+	MaxReplicas    int32
+	TopologyKey    string
+}
 
-	return &rest.Config{
-		Host:        apiURL,
-		BearerToken: token,
-		TLSClientConfig: rest.TLSClientConfig{
-			CAData: caCertBytes,
-		},
-	}, nil
+func (v ManifestValues) String() string {
+	return fmt.Sprintf("namespace=%s image=%s%s replicas=%s maxReplicas=%s topologyKey=%s",
+		v.Namespace, v.RegistryPrefix, v.Image,
+		fixtureDefault(v.Replicas), fixtureDefault(v.MaxReplicas), v.TopologyKey)
 }
 
-func GetClient() (*kubernetes.Clientset, error) {
-	// Load .env to get ACCESS_MODE
-	logger := GetLogger("Setup")
-	err := godotenv.Load(".env")
-	if err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("error loading .env file: %w", err)
+func fixtureDefault(value int32) string {
+	if value == 0 {
+		return "<fixture default>"
 	}
+	return strconv.Itoa(int(value))
+}
 
-	accessMode := os.Getenv("ACCESS_MODE")
-	switch accessMode {
-	case "KUBECONFIG":
-		if err := initKubeconfig(); err != nil {
-			return nil, err
+var manifestFuncs = template.FuncMap{
+	// default returns fallback when value is the zero value of its type
+	"default": func(fallback, value interface{}) interface{} {
+		if value == nil || reflect.ValueOf(value).IsZero() {
+			return fallback
 		}
-
-		config, err := clientcmd.BuildConfigFromFlags("", KubeconfigPath)
+		return value
+	},
+	"sub": func(a, b interface{}) (int64, error) {
+		x, err := strconv.ParseInt(fmt.Sprint(a), 10, 64)
 		if err != nil {
-			return nil, fmt.Errorf("config creation error: %w", err)
+			return 0, err
 		}
-		logger.Info().Msgf("Running test with access mode KUBECONFIG")
-		return kubernetes.NewForConfig(config)
-
-	case "EXTERNAL_K8S_API":
-		config, err := getExternalClusterAPICreds()
+		y, err := strconv.ParseInt(fmt.Sprint(b), 10, 64)
 		if err != nil {
-			return nil, fmt.Errorf("API credentials error: %w", err)
+			return 0, err
 		}
-		logger.Info().Msgf("Running test with access mode EXTERNAL_K8S_API")
-		return kubernetes.NewForConfig(config)
-
-	case "LOCAL_K8S_API":
-		config, err := getLocalClusterAPICreds()
-		if err != nil {
-			return nil, fmt.Errorf("API credentials error: %w", err)
-		}
-		logger.Info().Msgf("Running test with access mode LOCAL_K8S_API")
-		return kubernetes.NewForConfig(config)
-
-	default:
-		logger.Info().Msgf("Invalid .env ACCESS_MODE: %s. Must be KUBECONFIG, LOCAL_K8S_API or EXTERNAL_K8S_API\n", accessMode)
-		os.Exit(1)
-		return nil, fmt.Errorf(".env invalid access mode") // For compiler satisfaction
-	}
-}
-
-func GetTopologyDeploymentTestFiles() ([]byte, []byte, error) {
-	hpaPath := filepath.Join("topology_test_deployment_yamls", "hpa-trigger.yaml")
-	hpaContent, err := os.ReadFile(hpaPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("HPA file error: %w (checked: %s)", err, hpaPath)
-	}
-
-	deploymentPath := filepath.Join("topology_test_deployment_yamls", "topology-dep.yaml")
-	deploymentContent, err := os.ReadFile(deploymentPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("deployment file error: %w (checked: %s)", err, deploymentPath)
-	}
-
-	return hpaContent, deploymentContent, nil
+		return x - y, nil
+	},
 }
 
-func GetAffinityDeploymentTestFiles() ([]byte, []byte, []byte, error) {
-	hpaPath := filepath.Join("affinity_test_deployment_yamls", "hpa-trigger.yaml")
-	hpaContent, err := os.ReadFile(hpaPath)
+// RenderManifest executes a fixture as a Go template with the given values
+func RenderManifest(name string, content []byte, values ManifestValues) ([]byte, error) {
+	tmpl, err := template.New(name).Funcs(manifestFuncs).Option("missingkey=error").Parse(string(content))
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("HPA trigger file error: %w (checked: %s)", err, hpaPath)
+		return nil, fmt.Errorf("template parse failed: %w", err)
 	}
 
-	zonePath := filepath.Join("affinity_test_deployment_yamls", "zone-marker.yaml")
-	zoneContent, err := os.ReadFile(zonePath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("zone marker file error: %w (checked: %s)", err, zonePath)
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, values); err != nil {
+		return nil, fmt.Errorf("template render failed: %w", err)
 	}
-
-	deploymentPath := filepath.Join("affinity_test_deployment_yamls", "affinity-dependent-app.yaml")
-	deploymentContent, err := os.ReadFile(deploymentPath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("affinity-dependent deployment file error: %w (checked: %s)", err, deploymentPath)
-	}
-
-	return hpaContent, zoneContent, deploymentContent, nil
+	return rendered.Bytes(), nil
 }
 
-func GetAntiAffinityTestFiles() ([]byte, []byte, []byte, error) {
-	hpaPath := filepath.Join("anti_affinity_test_deployment_yamls", "hpa-trigger.yaml")
-	hpaContent, err := os.ReadFile(hpaPath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("HPA trigger file error: %w (checked: %s)", err, hpaPath)
-	}
-
-	zonePath := filepath.Join("anti_affinity_test_deployment_yamls", "zone-marker.yaml")
-	zoneContent, err := os.ReadFile(zonePath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("zone marker file error: %w (checked: %s)", err, zonePath)
-	}
-
-	deploymentPath := filepath.Join("anti_affinity_test_deployment_yamls", "anti-affinity-dependent-app.yaml")
-	deploymentContent, err := os.ReadFile(deploymentPath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("anti-affinity-dependent deployment file error: %w (checked: %s)", err, deploymentPath)
-	}
+// ReportDir receives the final JSON report, and the per-process log files
+// of a parallel run until process 1 merges them into the report. It is read
+// from the environment only, the cluster fan-out gives each run its own.
+var ReportDir = getEnvOrDefault("REPORT_DIR", "./temp")
 
-	return hpaContent, zoneContent, deploymentContent, nil
+func processLogPath(process int) string {
+	return filepath.Join(ReportDir, fmt.Sprintf(".process_%d_log.jsonl", process))
 }
 
-func GetPDBDeploymentTestFiles() ([]byte, []byte, error) {
-	deploymentPath := filepath.Join("pdb_deployment_test_yamls", "deployment.yaml")
-	deploymentContent, err := os.ReadFile(deploymentPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("deployment file error: %w (checked: %s)", err, deploymentPath)
+// All parallel processes share the run ID of process 1, so every namespace
+// created by one `ginkgo -p` run carries the same run ID label. A broken
+// configuration fails the suite here, before any test starts, and the report
+// records it as a setup failure instead of failing every test.
+var _ = ginkgo.SynchronizedBeforeSuite(func() []byte {
+	suiteConfig, _ := ginkgo.GinkgoConfiguration()
+	if needsCluster(suiteConfig.LabelFilter) {
+		if err := CheckConfiguration(); err != nil {
+			logger := GetLogger(SetupTag)
+			logger.Error().Msgf("=== Setup failed: %v ===", err)
+			ginkgo.Fail(fmt.Sprintf("Setup failed: %v", err))
+		}
 	}
-
-	pdbPath := filepath.Join("pdb_deployment_test_yamls", "pdb.yaml")
-	pdbContent, err := os.ReadFile(pdbPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("PDB file error: %w (checked: %s)", err, pdbPath)
+	return []byte(RunID)
+}, func(runID []byte) {
+	RunID = string(runID)
+	// Drop the logs a previous run left behind
+	if suiteConfig, _ := ginkgo.GinkgoConfiguration(); suiteConfig.ParallelProcess != 1 {
+		os.Remove(processLogPath(suiteConfig.ParallelProcess))
 	}
+})
 
-	return pdbContent, deploymentContent, nil
-}
+// handedOverLogs is the length of LogBuffer already appended to the log file
+// of the process, handOverFailed keeps a missing report directory from being
+// logged after every spec
+var (
+	handedOverLogs int
+	handOverFailed bool
+)
 
-func GetRollingUpdateDeploymentTestFiles() ([]byte, error) {
-	startPath := filepath.Join("rolling_update_deployment_test_yamls", "deployment_start.yaml")
-	startContent, err := os.ReadFile(startPath)
-	if err != nil {
-		return nil, fmt.Errorf("deployment start file error: %w (checked: %s)", err, startPath)
+// handOverLogs appends the log lines of a process other than 1 to its log
+// file, since ReportAfterSuite only runs on process 1. It runs after every
+// spec, so a process that panics or is killed still hands over the logs of
+// the specs it finished.
+func handOverLogs() {
+	suiteConfig, _ := ginkgo.GinkgoConfiguration()
+	if suiteConfig.ParallelProcess == 1 {
+		return
 	}
 
-	return startContent, nil
-}
-
-func GetAffinityStatefulSetTestFiles() ([]byte, []byte, []byte, error) {
-	hpaPath := filepath.Join("affinity_test_statefulset_yamls", "hpa-trigger.yaml")
-	hpaContent, err := os.ReadFile(hpaPath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("HPA trigger file error: %w (checked: %s)", err, hpaPath)
+	logs := LogBuffer.Bytes()[handedOverLogs:]
+	if len(logs) == 0 {
+		return
 	}
-
-	zonePath := filepath.Join("affinity_test_statefulset_yamls", "zone-marker.yaml")
-	zoneContent, err := os.ReadFile(zonePath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("zone marker file error: %w (checked: %s)", err, zonePath)
+	file, err := os.OpenFile(processLogPath(suiteConfig.ParallelProcess), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = file.Write(logs)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
 	}
-
-	statefulSetPath := filepath.Join("affinity_test_statefulset_yamls", "affinity-dependent-app.yaml")
-	statefulSetContent, err := os.ReadFile(statefulSetPath)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("affinity-dependent StatefulSet file error: %w (checked: %s)", err, statefulSetPath)
+		if !handOverFailed {
+			logger := GetLogger(SetupTag)
+			logger.Error().Err(err).Msgf("Failed to hand over logs of process %d", suiteConfig.ParallelProcess)
+		}
+		handOverFailed = true
+		return
 	}
-
-	return hpaContent, zoneContent, statefulSetContent, nil
+	handedOverLogs += len(logs)
 }
 
-func GetAntiAffinityStatefulSetTestFiles() ([]byte, []byte, []byte, error) {
-	hpaPath := filepath.Join("anti_affinity_statefulset_test_yamls", "hpa-trigger.yaml")
-	hpaContent, err := os.ReadFile(hpaPath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("HPA trigger file error: %w (checked: %s)", err, hpaPath)
-	}
+var _ = ginkgo.SynchronizedAfterSuite(handOverLogs, func() {})
 
-	zonePath := filepath.Join("anti_affinity_statefulset_test_yamls", "zone-marker.yaml")
-	zoneContent, err := os.ReadFile(zonePath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("zone marker file error: %w (checked: %s)", err, zonePath)
-	}
+// collectLogLines returns the log lines of process 1 followed by those of
+// every other parallel process
+func collectLogLines(parallelTotal int) [][]byte {
+	logger := GetLogger("FinalReportAfterSuite")
+	lines := bytes.Split(LogBuffer.Bytes(), []byte("\n"))
 
-	statefulSetPath := filepath.Join("anti_affinity_statefulset_test_yamls", "anti-affinity-dependent-app.yaml")
-	statefulSetContent, err := os.ReadFile(statefulSetPath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("anti-affinity-dependent StatefulSet file error: %w (checked: %s)", err, statefulSetPath)
+	for process := 2; process <= parallelTotal; process++ {
+		path := processLogPath(process)
+		content, err := os.ReadFile(path)
+		if err != nil {
+			logger.Error().Err(err).Msgf("Missing logs of process %d", process)
+			continue
+		}
+		lines = append(lines, bytes.Split(content, []byte("\n"))...)
+		os.Remove(path)
 	}
 
-	return hpaContent, zoneContent, statefulSetContent, nil
+	return lines
 }
 
-func GetStatefulSetTestFiles() ([]byte, []byte, error) {
-	hpaPath := filepath.Join("topology_test_statefulset_yamls", "hpa-trigger.yaml")
-	hpaContent, err := os.ReadFile(hpaPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("HPA file error: %w (checked: %s)", err, hpaPath)
-	}
+// TestTagPrefix marks the Ginkgo label carrying the test tag of a Describe,
+// e.g. "tag:DeploymentAffinityTest", every spec of the Describe inherits it
+const TestTagPrefix = "tag:"
 
-	statefulsetPath := filepath.Join("topology_test_statefulset_yamls", "topology-statefulset.yaml")
-	statefulsetContent, err := os.ReadFile(statefulsetPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("StatefulSet file error: %w (checked: %s)", err, statefulsetPath)
-	}
+// SetupTag groups the suite nodes (BeforeSuite, AfterSuite...) in the report
+const SetupTag = "Setup"
 
-	return hpaContent, statefulsetContent, nil
+// TestTag labels a Describe with its test tag, so the final report can map
+// every spec to its test even when the spec never logs
+func TestTag(tag string) ginkgo.Labels {
+	return ginkgo.Label(TestTagPrefix + tag)
 }
 
-func GetPDBStSTestFiles() ([]byte, []byte, error) {
-	pdbPath := filepath.Join("pdb_statefulset_test_yamls", "pdb.yaml")
-	pdbContent, err := os.ReadFile(pdbPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("PDB file error: %w (checked: %s)", err, pdbPath)
+// specTestTag returns the test tag of a spec. Specs without a tag label are
+// grouped under their top level container
+func specTestTag(spec types.SpecReport) string {
+	for _, label := range spec.Labels() {
+		if tag, ok := strings.CutPrefix(label, TestTagPrefix); ok {
+			return tag
+		}
 	}
-
-	stsPath := filepath.Join("pdb_statefulset_test_yamls", "sts.yaml")
-	stsContent, err := os.ReadFile(stsPath)
-	if err != nil {
-		return nil, nil, fmt.Errorf("StatefulSet file error: %w (checked: %s)", err, stsPath)
+	if len(spec.ContainerHierarchyTexts) > 0 {
+		return spec.ContainerHierarchyTexts[0]
 	}
-
-	return pdbContent, stsContent, nil
+	return SetupTag
 }
 
-func GetRollingUpdateStatefulSetTestFiles() ([]byte, error) {
-	startPath := filepath.Join("rolling_update_sts_yamls", "sts_start.yaml")
-	startContent, err := os.ReadFile(startPath)
-	if err != nil {
-		return nil, fmt.Errorf("statefulset start file error: %w (checked: %s)", err, startPath)
-	}
-
-	return startContent, nil
+// SpecResult is the outcome of one spec as reported by Ginkgo
+type SpecResult struct {
+	Tag      string `json:"tag"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Failure  string `json:"failure,omitempty"`
+	Location string `json:"location"`
+	Duration string `json:"duration"`
 }
 
+// Outcomes of a run, in the outcome field of the final report
+const (
+	OutcomePassed      = "passed"
+	OutcomeFailed      = "failed"
+	OutcomeSetupFailed = "setup failed"
+)
+
 type FinalReport struct {
 	TestTimestamp       string                              `json:"test_timestamp"`
+	Cluster             string                              `json:"cluster,omitempty"`
+	Outcome             string                              `json:"outcome"`
+	SetupError          string                              `json:"setup_error,omitempty"`
+	Interrupted         string                              `json:"interrupted,omitempty"`
 	FailingTests        []string                            `json:"failing_tests"`
 	SucceedingTests     []string                            `json:"succeeding_tests"`
+	SkippedTests        []string                            `json:"skipped_tests"`
+	SkipReasons         map[string]string                   `json:"skip_reasons"`
 	AllowedToFailTests  []string                            `json:"allowed_to_fail_tests"`
 	FailedButNotAllowed []string                            `json:"failed_but_not_allowed_to_fail"`
 	SuccessRatio        string                              `json:"success_ratio"`
+	APIRetries          int                                 `json:"api_retries"`
+	APIRetryReasons     map[string]int                      `json:"api_retry_reasons"`
+	Specs               []SpecResult                        `json:"specs"`
+	Diagnostics         map[string]string                   `json:"diagnostics"`
 	LogsByTags          map[string][]map[string]interface{} `json:"logs_by_tags"`
 }
 
-var _ = ginkgo.ReportAfterSuite("Test Suite Summary", func(report ginkgo.Report) {
-	logger := GetLogger("FinalReportAfterSuite")
-
-	dir := "./temp"
-	if _, err := os.Stat(dir); os.IsNotExist(err) {
-		logger.Error().Msgf("Error: Directory %s does not exist", dir)
-		return
+// BuildFinalReport decides the outcome of every test tag from the Ginkgo spec
+// reports: a tag fails when any of its specs failed, and succeeds when at least
+// one spec passed and none failed. The log lines are only attached as evidence.
+func BuildFinalReport(report ginkgo.Report, lines [][]byte) FinalReport {
+	finalReport := FinalReport{
+		TestTimestamp:       time.Now().Format("01/02/2006 15:04:05"),
+		FailingTests:        []string{},
+		SucceedingTests:     []string{},
+		SkippedTests:        []string{},
+		SkipReasons:         make(map[string]string),
+		AllowedToFailTests:  []string{},
+		FailedButNotAllowed: []string{},
+		Specs:               []SpecResult{},
+		Diagnostics:         make(map[string]string),
+		LogsByTags:          make(map[string][]map[string]interface{}),
+	}
+
+	// Specs left out by the label or focus filters are reported as skipped
+	// without a reason, a test whose specs were all left out was not selected
+	selected := make(map[string]bool)
+	for _, spec := range report.SpecReports {
+		if spec.LeafNodeType == types.NodeTypeIt &&
+			(spec.State != types.SpecStateSkipped || spec.Failure.Message != "") {
+			selected[specTestTag(spec)] = true
+		}
 	}
 
-	filename := filepath.Join(dir, fmt.Sprintf("test_suite_log_%s.json",
-		time.Now().Format("20060102-150405")))
+	var tags []string
+	failed := make(map[string]bool)
+	passed := make(map[string]bool)
+	skipReasons := make(map[string]string)
 
-	lines := bytes.Split(LogBuffer.Bytes(), []byte("\n"))
-	logsByTags := make(map[string][]map[string]interface{})
-	failingTests := []string{}
-	succeedingTests := []string{}
-	allowedToFailTests := []string{}
-	failedButNotAllowedToFail := []string{}
-	allTags := make(map[string]bool)
+	for _, spec := range report.SpecReports {
+		isSuiteNode := spec.LeafNodeType != types.NodeTypeIt
+		// Suite nodes only matter to the report when they broke the run
+		if isSuiteNode && !spec.State.Is(types.SpecStateFailureStates) {
+			continue
+		}
+
+		tag := SetupTag
+		name := spec.LeafNodeType.String()
+		if !isSuiteNode {
+			tag = specTestTag(spec)
+			name = spec.FullText()
+		}
+		if !selected[tag] && tag != SetupTag {
+			continue
+		}
+		if spec.LeafNodeType.Is(types.NodeTypeBeforeSuite|types.NodeTypeSynchronizedBeforeSuite) && finalReport.SetupError == "" {
+			finalReport.SetupError = spec.Failure.Message
+		}
+
+		location := spec.LeafNodeLocation
+		if spec.State.Is(types.SpecStateFailureStates) {
+			location = spec.Failure.Location
+		}
+
+		finalReport.Specs = append(finalReport.Specs, SpecResult{
+			Tag:      tag,
+			Name:     name,
+			State:    spec.State.String(),
+			Failure:  spec.Failure.Message,
+			Location: location.String(),
+			Duration: spec.RunTime.Round(time.Millisecond).String(),
+		})
+
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+		switch {
+		case spec.State.Is(types.SpecStateFailureStates):
+			failed[tag] = true
+		case spec.State == types.SpecStatePassed:
+			passed[tag] = true
+		case spec.State == types.SpecStateSkipped && skipReasons[tag] == "":
+			// The first skipped spec carries the reason given to Skip()
+			skipReasons[tag] = spec.Failure.Message
+		}
+	}
+
+	for _, tag := range tags {
+		switch {
+		case failed[tag]:
+			finalReport.FailingTests = append(finalReport.FailingTests, tag)
+			if slices.Contains(AllowedToFailTags, tag) {
+				finalReport.AllowedToFailTests = append(finalReport.AllowedToFailTests, tag)
+			} else {
+				finalReport.FailedButNotAllowed = append(finalReport.FailedButNotAllowed, tag)
+			}
+		case passed[tag]:
+			finalReport.SucceedingTests = append(finalReport.SucceedingTests, tag)
+		default:
+			finalReport.SkippedTests = append(finalReport.SkippedTests, tag)
+			finalReport.SkipReasons[tag] = skipReasons[tag]
+		}
+	}
 
 	for _, line := range lines {
 		if len(line) == 0 {
@@ -435,44 +449,101 @@ var _ = ginkgo.ReportAfterSuite("Test Suite Summary", func(report ginkgo.Report)
 			continue
 		}
 
-		if tagValue, ok := logEntry["tag"].(string); ok && tagValue != "Setup" {
-			allTags[tagValue] = true
-
-			if msg, ok := logEntry["message"].(string); ok && strings.Contains(msg, "TEST_FAILED") {
-				failingTests = append(failingTests, tagValue)
-				if slices.Contains(AllowedToFailTags, tagValue) {
-					allowedToFailTests = append(allowedToFailTests, tagValue)
-				} else {
-					failedButNotAllowedToFail = append(failedButNotAllowedToFail, tagValue)
-				}
-			}
-
+		if tagValue, ok := logEntry["tag"].(string); ok {
 			delete(logEntry, "tag")
 			delete(logEntry, "level")
-			logsByTags[tagValue] = append(logsByTags[tagValue], logEntry)
+			finalReport.LogsByTags[tagValue] = append(finalReport.LogsByTags[tagValue], logEntry)
 		}
 	}
 
-	for tag := range allTags {
-		if !slices.Contains(failingTests, tag) {
-			succeedingTests = append(succeedingTests, tag)
+	totalTests := len(finalReport.FailingTests) + len(finalReport.SucceedingTests)
+	successRatio := 0.0
+	if totalTests > 0 {
+		successRatio = float64(len(finalReport.SucceedingTests)) / float64(totalTests) * 100
+	}
+	finalReport.SuccessRatio = fmt.Sprintf("%.2f%%", successRatio)
+	// Retried API requests, a flaky control plane shows here before it fails tests
+	finalReport.APIRetries, finalReport.APIRetryReasons = countRetries(finalReport.LogsByTags[APIRetryTag])
+	// A signal or SUITE_TIMEOUT stopped the run, the specs left were skipped
+	var interrupted []string
+	for _, reason := range report.SpecialSuiteFailureReasons {
+		if strings.HasPrefix(reason, "Interrupted") || reason == "Suite Timeout Elapsed" {
+			if !slices.Contains(interrupted, reason) {
+				interrupted = append(interrupted, reason)
+			}
 		}
 	}
+	finalReport.Interrupted = strings.Join(interrupted, ", ")
+
+	switch {
+	case finalReport.SetupError != "":
+		finalReport.Outcome = OutcomeSetupFailed
+	case len(finalReport.FailedButNotAllowed) > 0 || finalReport.Interrupted != "":
+		finalReport.Outcome = OutcomeFailed
+	default:
+		finalReport.Outcome = OutcomePassed
+	}
 
-	totalTests := len(failingTests) + len(succeedingTests)
-	successRatio := float64(len(succeedingTests)) / float64(totalTests) * 100
+	return finalReport
+}
 
-	// Replace map with struct instance
-	finalJSON := FinalReport{
-		TestTimestamp:       time.Now().Format("01/02/2006 15:04:05"),
-		FailingTests:        failingTests,
-		SucceedingTests:     succeedingTests,
-		AllowedToFailTests:  allowedToFailTests,
-		FailedButNotAllowed: failedButNotAllowedToFail,
-		SuccessRatio:        fmt.Sprintf("%.2f%%", successRatio),
-		LogsByTags:          logsByTags,
+// suiteReport is the final report of the run, set by ReportAfterSuite on process 1
+var suiteReport *FinalReport
+
+// processSpecReports are the specs run by this process, a parallel process
+// other than 1 decides its exit status from them
+var processSpecReports types.SpecReports
+
+var _ = ginkgo.ReportAfterEach(func(report ginkgo.SpecReport) {
+	processSpecReports = append(processSpecReports, report)
+	handOverLogs()
+})
+
+// ExitCode is the exit status of the test binary: non-zero only when the
+// setup failed or a test that is not allowed to fail has failed. Process 1
+// decides from the final report, the other parallel processes from their own
+// specs, since the ginkgo CLI fails the run when any process exits non-zero.
+func ExitCode(runCode int) int {
+	if suiteReport == nil {
+		return ProcessExitCode(runCode, processSpecReports)
+	}
+	if suiteReport.Outcome != OutcomePassed {
+		return 1
+	}
+	return 0
+}
+
+// ProcessExitCode is the exit status of a process without the final report,
+// decided from the specs it ran. The setup and the suite nodes are decided
+// by process 1. Without any spec (a flag error) the status of the run is kept.
+func ProcessExitCode(runCode int, specReports types.SpecReports) int {
+	if len(specReports) == 0 {
+		return runCode
+	}
+	if BuildFinalReport(ginkgo.Report{SpecReports: specReports}, nil).Outcome != OutcomePassed {
+		return 1
+	}
+	return 0
+}
+
+var _ = ginkgo.ReportAfterSuite("Test Suite Summary", func(report ginkgo.Report) {
+	logger := GetLogger("FinalReportAfterSuite")
+
+	finalJSON := BuildFinalReport(report, collectLogLines(report.SuiteConfig.ParallelTotal))
+	// Failure diagnostics bundles written by any process, by test tag
+	finalJSON.Diagnostics = DiagnosticsFiles(ReportDir)
+	finalJSON.Cluster = os.Getenv("KUBE_CONTEXT")
+	suiteReport = &finalJSON
+
+	dir := ReportDir
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		logger.Error().Msgf("Error: Directory %s does not exist", dir)
+		return
 	}
 
+	timestamp := time.Now().Format("20060102-150405")
+	filename := filepath.Join(dir, fmt.Sprintf("test_suite_log_%s.json", timestamp))
+
 	jsonData, err := json.MarshalIndent(finalJSON, "", " ")
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to serialize logs to JSON")
@@ -485,24 +556,43 @@ var _ = ginkgo.ReportAfterSuite("Test Suite Summary", func(report ginkgo.Report)
 		logger.Info().Str("file", filename).Msg("Test suite log written successfully")
 	}
 
-	if totalTests > 2 { // if running single test  - Setup + The specific single tests - don't print this
+	junitFilename := filepath.Join(dir, fmt.Sprintf("junit_%s.xml", timestamp))
+	if err := WriteJUnitReport(junitFilename, finalJSON); err != nil {
+		logger.Error().Err(err).Msg("Failed to write JUnit report")
+	} else {
+		logger.Info().Str("file", junitFilename).Msg("JUnit report written successfully")
+	}
+
+	totalTests := len(finalJSON.FailingTests) + len(finalJSON.SucceedingTests) + len(finalJSON.SkippedTests)
+	if totalTests > 1 { // if running a single test - don't print this
 		fmt.Printf("\n=== Test Suite Summary ===\n")
-		fmt.Printf("Failing Tests (%d):\n", len(failingTests))
-		for _, test := range failingTests {
+		fmt.Printf("Failing Tests (%d):\n", len(finalJSON.FailingTests))
+		for _, test := range finalJSON.FailingTests {
+			fmt.Printf("- %s\n", test)
+		}
+		fmt.Printf("\nSucceeding Tests (%d):\n", len(finalJSON.SucceedingTests))
+		for _, test := range finalJSON.SucceedingTests {
 			fmt.Printf("- %s\n", test)
 		}
-		fmt.Printf("\nSucceeding Tests (%d):\n", len(succeedingTests))
-		for _, test := range succeedingTests {
+		fmt.Printf("\nSkipped Tests (%d):\n", len(finalJSON.SkippedTests))
+		for _, test := range finalJSON.SkippedTests {
 			fmt.Printf("- %s\n", test)
 		}
-		fmt.Printf("\nAllowed to Fail Tests (%d):\n", len(allowedToFailTests))
-		for _, test := range allowedToFailTests {
+		fmt.Printf("\nAllowed to Fail Tests (%d):\n", len(finalJSON.AllowedToFailTests))
+		for _, test := range finalJSON.AllowedToFailTests {
 			fmt.Printf("- %s\n", test)
 		}
-		fmt.Printf("\nFailed but Not Allowed to Fail Tests (%d):\n", len(failedButNotAllowedToFail))
-		for _, test := range failedButNotAllowedToFail {
+		fmt.Printf("\nFailed but Not Allowed to Fail Tests (%d):\n", len(finalJSON.FailedButNotAllowed))
+		for _, test := range finalJSON.FailedButNotAllowed {
 			fmt.Printf("- %s\n", test)
 		}
-		fmt.Printf("\nSuccess Ratio: %.2f%%\n", successRatio)
+		fmt.Printf("\nSuccess Ratio: %s\n", finalJSON.SuccessRatio)
+		fmt.Printf("API Requests Retried: %d\n", finalJSON.APIRetries)
+	}
+	if finalJSON.Interrupted != "" {
+		fmt.Printf("\n=== Run stopped early: %s ===\n", finalJSON.Interrupted)
+	}
+	if finalJSON.Outcome == OutcomeSetupFailed {
+		fmt.Printf("\n=== Setup failed, no test ran ===\n%s\n", finalJSON.SetupError)
 	}
 })
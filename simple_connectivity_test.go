@@ -12,90 +12,73 @@ import (
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
-var _ = ginkgo.Describe("Basic cluster connectivity test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), func() {
+var _ = ginkgo.Describe("Basic cluster connectivity test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), example.TestTag("SimpleConnectivityTest"), func() {
 	var (
-		clientset *kubernetes.Clientset
+		clientset kubernetes.Interface
+		namespace string
 		logger    zerolog.Logger
 		testTag   = "SimpleConnectivityTest"
 	)
 
-	ginkgo.BeforeAll(func() {
-		var err error
-		clientset, err = example.GetClient()
+	ginkgo.BeforeAll(func(ctx ginkgo.SpecContext) {
+		suite, err := example.Config()
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		clientset = suite.Clientset
 
 		logger = example.GetLogger(testTag)
 
 		// Namespace setup
-		logger.Info().Msgf("=== Creating test-ns namespace ===")
-		_, err = clientset.CoreV1().Namespaces().Create(
-			context.TODO(),
-			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-ns"}},
-			metav1.CreateOptions{},
-		)
-		if apierrors.IsAlreadyExists(err) {
-			logger.Info().Msgf("Namespace test-ns already exists\n")
-		} else {
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-			logger.Info().Msgf("Namespace test-ns created successfully\n")
-		}
+		namespace, err = example.CreateTestNamespace(ctx, logger, clientset, testTag)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
 		// Register cleanup inside setup node
-		ginkgo.DeferCleanup(func() {
+		ginkgo.DeferCleanup(func(ctx ginkgo.SpecContext) {
 			logger.Info().Msgf("=== Final namespace cleanup ===")
 			err := clientset.CoreV1().Namespaces().Delete(
-				context.TODO(),
-				"test-ns",
+				ctx,
+				namespace,
 				metav1.DeleteOptions{},
 			)
 			if err != nil && !apierrors.IsNotFound(err) {
 				ginkgo.Fail(fmt.Sprintf("Final cleanup failed: %v", err))
 			}
 
-			// Verification loop
-			const (
-				timeout  = 1 * time.Minute
-				interval = 500 * time.Millisecond
-			)
-			deadline := time.Now().Add(timeout)
-
-			for {
+			// Verification loop, bounded by its own minute and by the suite
+			err = wait.PollUntilContextTimeout(ctx, 500*time.Millisecond, time.Minute, true, func(ctx context.Context) (bool, error) {
 				_, err := clientset.CoreV1().Namespaces().Get(
-					context.TODO(),
-					"test-ns",
+					ctx,
+					namespace,
 					metav1.GetOptions{},
 				)
-
-				if apierrors.IsNotFound(err) {
-					logger.Info().Msgf("Namespace test-ns successfully removed\n")
-					break
-				}
-
-				if time.Now().After(deadline) {
-					logger.Info().Msgf("\nError: Namespace test-ns still exists after 1 minute\n")
-					break
-				}
-
-				if err != nil {
+				if err != nil && !apierrors.IsNotFound(err) {
 					logger.Info().Msgf("Transient error verifying deletion: %v\n", err)
 				}
-
-				time.Sleep(interval)
+				return apierrors.IsNotFound(err), nil
+			})
+			if err != nil {
+				logger.Info().Msgf("\nError: Namespace %s still exists: %v\n", namespace, err)
+				return
 			}
+			logger.Info().Msgf("Namespace %s successfully removed\n", namespace)
+		}, ginkgo.NodeTimeout(2*time.Minute))
+	})
 
-			clientset.CoreV1().RESTClient().(*rest.RESTClient).Client.CloseIdleConnections()
-		})
+	ginkgo.AfterEach(func(ctx ginkgo.SpecContext) {
+		// Capture the evidence before the namespace is deleted
+		if ginkgo.CurrentSpecReport().Failed() {
+			example.CollectFailureDiagnostics(ctx, logger, clientset, namespace, testTag)
+		}
 	})
 
-	ginkgo.It("should list cluster nodes", func() {
+	ginkgo.It("should list cluster nodes", func(ctx ginkgo.SpecContext) {
 
 		logger.Info().Msgf("=== Listing cluster nodes ===")
 		nodes, err := clientset.CoreV1().Nodes().List(
-			context.TODO(),
+			ctx,
 			metav1.ListOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -107,10 +90,10 @@ var _ = ginkgo.Describe("Basic cluster connectivity test", ginkgo.Ordered, ginkg
 		}
 	})
 
-	ginkgo.It("should have ready nodes", func() {
+	ginkgo.It("should have ready nodes", func(ctx ginkgo.SpecContext) {
 
 		nodes, err := clientset.CoreV1().Nodes().List(
-			context.TODO(),
+			ctx,
 			metav1.ListOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -132,15 +115,15 @@ var _ = ginkgo.Describe("Basic cluster connectivity test", ginkgo.Ordered, ginkg
 		}
 	})
 
-	ginkgo.It("should have test namespace", func() {
+	ginkgo.It("should have test namespace", func(ctx ginkgo.SpecContext) {
 
 		logger.Info().Msgf("=== Verifying test namespace ===")
 		_, err := clientset.CoreV1().Namespaces().Get(
-			context.TODO(),
-			"test-ns",
+			ctx,
+			namespace,
 			metav1.GetOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		logger.Info().Msgf("Namespace test-ns verified\n")
+		logger.Info().Msgf("Namespace %s verified\n", namespace)
 	})
 })
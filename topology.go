@@ -0,0 +1,279 @@
+package example
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TopologySpreadResult is how the pods of a workload spread over the eligible
+// domains of one of its topologySpreadConstraints. Domains holds the matching
+// pod count of every eligible domain, empty ones included.
+type TopologySpreadResult struct {
+	TopologyKey       string                               `json:"topology_key"`
+	MaxSkew           int32                                `json:"max_skew"`
+	MinDomains        int32                                `json:"min_domains"`
+	WhenUnsatisfiable corev1.UnsatisfiableConstraintAction `json:"when_unsatisfiable"`
+	Domains           map[string]int                       `json:"domains"`
+	Matching          int                                  `json:"matching"`
+	GlobalMin         int                                  `json:"global_min"`
+	MaxCount          int                                  `json:"max_count"`
+	Skew              int                                  `json:"skew"`
+}
+
+// Violated tells whether a DoNotSchedule constraint is exceeded. ScheduleAnyway
+// constraints are only a scoring preference of the scheduler and never violated.
+func (r TopologySpreadResult) Violated() bool {
+	return r.WhenUnsatisfiable == corev1.DoNotSchedule && r.Skew > int(r.MaxSkew)
+}
+
+func (r TopologySpreadResult) String() string {
+	domains := make([]string, 0, len(r.Domains))
+	for domain := range r.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	counts := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		counts = append(counts, fmt.Sprintf("%s=%d", domain, r.Domains[domain]))
+	}
+	return fmt.Sprintf("%s (maxSkew %d, minDomains %d, %s): %d pods over [%s], skew %d-%d=%d",
+		r.TopologyKey, r.MaxSkew, r.MinDomains, r.WhenUnsatisfiable, r.Matching,
+		strings.Join(counts, ", "), r.MaxCount, r.GlobalMin, r.Skew)
+}
+
+// VerifyTopologySpread computes the skew of every topologySpreadConstraint of
+// a pod template the way the scheduler does. A node is an eligible domain
+// member when it carries every topology key of the template and passes the
+// nodeAffinityPolicy and nodeTaintsPolicy of the constraint. The global
+// minimum is 0 while there are fewer eligible domains than minDomains.
+// Terminating pods and pods of other namespaces are not counted.
+func VerifyTopologySpread(template corev1.PodTemplateSpec, namespace string, nodes []corev1.Node, pods []corev1.Pod) ([]TopologySpreadResult, error) {
+	constraints := template.Spec.TopologySpreadConstraints
+
+	podsByNode := make(map[string][]corev1.Pod)
+	for _, pod := range pods {
+		if pod.Namespace != namespace || pod.DeletionTimestamp != nil || pod.Spec.NodeName == "" {
+			continue
+		}
+		podsByNode[pod.Spec.NodeName] = append(podsByNode[pod.Spec.NodeName], pod)
+	}
+
+	var results []TopologySpreadResult
+	for _, constraint := range constraints {
+		selector, err := constraintSelector(constraint, template.Labels)
+		if err != nil {
+			return nil, fmt.Errorf("invalid labelSelector of the %s constraint: %w", constraint.TopologyKey, err)
+		}
+
+		result := TopologySpreadResult{
+			TopologyKey:       constraint.TopologyKey,
+			MaxSkew:           constraint.MaxSkew,
+			MinDomains:        1,
+			WhenUnsatisfiable: constraint.WhenUnsatisfiable,
+			Domains:           make(map[string]int),
+		}
+		if constraint.MinDomains != nil {
+			result.MinDomains = *constraint.MinDomains
+		}
+
+		for _, node := range nodes {
+			if !hasTopologyKeys(node, constraints) {
+				continue
+			}
+			eligible, err := matchesInclusionPolicies(node, template.Spec, constraint)
+			if err != nil {
+				return nil, err
+			}
+			if !eligible {
+				continue
+			}
+
+			domain := node.Labels[constraint.TopologyKey]
+			// An eligible domain without matching pods still holds the global minimum down
+			if _, ok := result.Domains[domain]; !ok {
+				result.Domains[domain] = 0
+			}
+			for _, pod := range podsByNode[node.Name] {
+				if selector.Matches(labels.Set(pod.Labels)) {
+					result.Domains[domain]++
+					result.Matching++
+				}
+			}
+		}
+
+		first := true
+		for _, count := range result.Domains {
+			if first || count < result.GlobalMin {
+				result.GlobalMin = count
+			}
+			if count > result.MaxCount {
+				result.MaxCount = count
+			}
+			first = false
+		}
+		if len(result.Domains) < int(result.MinDomains) {
+			result.GlobalMin = 0
+		}
+		result.Skew = result.MaxCount - result.GlobalMin
+
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// CheckTopologySpread lists the nodes and the pods of the namespace and
+// verifies the topologySpreadConstraints of a Deployment or StatefulSet template
+func CheckTopologySpread(ctx context.Context, clientset kubernetes.Interface, namespace string, template corev1.PodTemplateSpec) ([]TopologySpreadResult, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in namespace %s: %w", namespace, err)
+	}
+
+	return VerifyTopologySpread(template, namespace, nodes.Items, pods.Items)
+}
+
+// constraintSelector is the labelSelector of the constraint narrowed by the
+// template values of its matchLabelKeys
+func constraintSelector(constraint corev1.TopologySpreadConstraint, templateLabels map[string]string) (labels.Selector, error) {
+	selector, err := metav1.LabelSelectorAsSelector(constraint.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range constraint.MatchLabelKeys {
+		value, ok := templateLabels[key]
+		if !ok {
+			continue
+		}
+		requirement, err := labels.NewRequirement(key, selection.Equals, []string{value})
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*requirement)
+	}
+	return selector, nil
+}
+
+func hasTopologyKeys(node corev1.Node, constraints []corev1.TopologySpreadConstraint) bool {
+	for _, constraint := range constraints {
+		if _, ok := node.Labels[constraint.TopologyKey]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesInclusionPolicies applies nodeAffinityPolicy (Honor by default) and
+// nodeTaintsPolicy (Ignore by default) of a constraint to a node
+func matchesInclusionPolicies(node corev1.Node, spec corev1.PodSpec, constraint corev1.TopologySpreadConstraint) (bool, error) {
+	if constraint.NodeAffinityPolicy == nil || *constraint.NodeAffinityPolicy == corev1.NodeInclusionPolicyHonor {
+		matches, err := matchesRequiredNodeAffinity(node, spec)
+		if err != nil || !matches {
+			return false, err
+		}
+	}
+
+	if constraint.NodeTaintsPolicy != nil && *constraint.NodeTaintsPolicy == corev1.NodeInclusionPolicyHonor {
+		return toleratesNodeTaints(node, spec.Tolerations), nil
+	}
+	return true, nil
+}
+
+// matchesRequiredNodeAffinity tells whether the node satisfies the nodeSelector
+// and the required node affinity of a pod spec
+func matchesRequiredNodeAffinity(node corev1.Node, spec corev1.PodSpec) (bool, error) {
+	if !labels.SelectorFromSet(spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false, nil
+	}
+
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil ||
+		spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true, nil
+	}
+
+	// The terms are ORed, the requirements of a term are ANDed
+	for _, term := range spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		matches, err := matchesNodeSelectorTerm(node, term)
+		if err != nil {
+			return false, err
+		}
+		if matches {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+var nodeSelectorOperators = map[corev1.NodeSelectorOperator]selection.Operator{
+	corev1.NodeSelectorOpIn:           selection.In,
+	corev1.NodeSelectorOpNotIn:        selection.NotIn,
+	corev1.NodeSelectorOpExists:       selection.Exists,
+	corev1.NodeSelectorOpDoesNotExist: selection.DoesNotExist,
+	corev1.NodeSelectorOpGt:           selection.GreaterThan,
+	corev1.NodeSelectorOpLt:           selection.LessThan,
+}
+
+func matchesNodeSelectorTerm(node corev1.Node, term corev1.NodeSelectorTerm) (bool, error) {
+	// An empty term selects no node
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false, nil
+	}
+
+	matches, err := matchesNodeSelectorRequirements(term.MatchExpressions, labels.Set(node.Labels))
+	if err != nil || !matches {
+		return false, err
+	}
+	// metadata.name is the only field supported by the scheduler
+	return matchesNodeSelectorRequirements(term.MatchFields, labels.Set{"metadata.name": node.Name})
+}
+
+func matchesNodeSelectorRequirements(requirements []corev1.NodeSelectorRequirement, set labels.Set) (bool, error) {
+	for _, expression := range requirements {
+		operator, ok := nodeSelectorOperators[expression.Operator]
+		if !ok {
+			return false, fmt.Errorf("unsupported node selector operator %q", expression.Operator)
+		}
+		requirement, err := labels.NewRequirement(expression.Key, operator, expression.Values)
+		if err != nil {
+			return false, fmt.Errorf("invalid node selector requirement on %s: %w", expression.Key, err)
+		}
+		if !requirement.Matches(set) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// toleratesNodeTaints tells whether every NoSchedule and NoExecute taint of
+// the node is tolerated
+func toleratesNodeTaints(node corev1.Node, tolerations []corev1.Toleration) bool {
+	for i := range node.Spec.Taints {
+		taint := &node.Spec.Taints[i]
+		if taint.Effect != corev1.TaintEffectNoSchedule && taint.Effect != corev1.TaintEffectNoExecute {
+			continue
+		}
+		tolerated := false
+		for j := range tolerations {
+			if tolerations[j].ToleratesTaint(taint) {
+				tolerated = true
+				break
+			}
+		}
+		if !tolerated {
+			return false
+		}
+	}
+	return true
+}
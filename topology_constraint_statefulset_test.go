@@ -1,113 +1,82 @@
 package example_test
 
 import (
-	"context"
 	"fmt"
 	"time"
 
 	"github.com/onsi/ginkgo/v2"
 	"github.com/onsi/gomega"
 	"github.com/rs/zerolog"
-	"gopkg.in/yaml.v2"
-	v1 "k8s.io/api/core/v1"
-	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 
 	"example"
 )
 
-var _ = ginkgo.Describe("StatefulSet Topology Constraints E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), func() {
+var _ = ginkgo.Describe("StatefulSet Topology Constraints E2E test", ginkgo.Ordered, ginkgo.Label("safe-in-production"), example.TestTag("StatefulSetTopologyConstraitTest"), example.Requires(example.RequirementZones, example.RequirementMetricsAPI, example.RequirementCPUHeadroom, example.RequirementRBAC), func() {
 	var (
-		clientset      *kubernetes.Clientset
+		clientset      kubernetes.Interface
+		manifestClient *example.ManifestClient
+		values         example.ManifestValues
+		namespace      string
 		hpaMaxReplicas int32
 		logger         zerolog.Logger
 		testTag        = "StatefulSetTopologyConstraitTest"
 	)
 
-	ginkgo.BeforeAll(func() {
+	ginkgo.BeforeAll(func(ctx ginkgo.SpecContext) {
 
-		var err error
-		clientset, err = example.GetClient()
+		suite, err := example.Config()
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		clientset, manifestClient = suite.Clientset, suite.ManifestClient
 
 		logger = example.GetLogger(testTag)
 
-		// Namespace setup
-		logger.Info().Msgf("=== Ensuring test-ns exists ===")
-		_, err = clientset.CoreV1().Namespaces().Get(
-			context.TODO(),
-			"test-ns",
-			metav1.GetOptions{},
-		)
+		values = suite.Values
+		logger.Info().Msgf("=== Manifest values: %s ===", values)
 
-		if apierrors.IsNotFound(err) {
-			logger.Info().Msgf("Creating test-ns namespace\n")
-			ns := &v1.Namespace{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "test-ns",
-				},
-			}
-			_, err = clientset.CoreV1().Namespaces().Create(
-				context.TODO(),
-				ns,
-				metav1.CreateOptions{},
-			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		} else {
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		}
+		// Skip before creating anything when the cluster lacks a declared requirement
+		err = example.SkipUnmetRequirements(ctx, logger, clientset, values)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+
+		// Namespace setup
+		namespace, err = example.CreateTestNamespace(ctx, logger, clientset, testTag)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		values.Namespace = namespace
 	})
 
-	ginkgo.AfterEach(func() {
-		clientset.CoreV1().RESTClient().(*rest.RESTClient).Client.CloseIdleConnections()
+	ginkgo.AfterEach(func(ctx ginkgo.SpecContext) {
+		// Capture the evidence before AfterAll clears the namespace
 		if ginkgo.CurrentSpecReport().Failed() {
-			logger.Error().Msgf("%s:TEST_FAILED", testTag)
+			example.CollectFailureDiagnostics(ctx, logger, clientset, namespace, testTag)
 		}
-
 	})
 
-	ginkgo.AfterAll(func() {
-		example.ClearNamespace(logger, clientset)
+	ginkgo.AfterAll(func(ctx ginkgo.SpecContext) {
+		example.ClearNamespace(ctx, logger, clientset, namespace)
 	})
 
-	ginkgo.It("should apply topology manifests", func() {
+	ginkgo.It("should apply topology manifests", func(ctx ginkgo.SpecContext) {
 		logger.Info().Msgf("=== Starting StatefulSet Topology Constraints E2E test ===")
 		logger.Info().Msgf("=== tag: %s, allowed to fail: %t", testTag, example.IsTestAllowedToFail(testTag))
 
-		hpaYAML, ssYAML, err := example.GetStatefulSetTestFiles()
+		fixtures, err := example.LoadFixtureBundle(testTag, values)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		// Parse HPA YAML to extract maxReplicas
-		type hpaSpec struct {
-			Spec struct {
-				MaxReplicas int32 `yaml:"maxReplicas"`
-			} `yaml:"spec"`
-		}
-		var hpaConfig hpaSpec
-		err = yaml.Unmarshal([]byte(hpaYAML), &hpaConfig)
+		hpaMaxReplicas, err = fixtures.Int32Param("maxReplicas")
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-		hpaMaxReplicas = hpaConfig.Spec.MaxReplicas
+		logger.Info().Msgf("=== HPA maxReplicas: %d ===", hpaMaxReplicas)
 
-		logger.Info().Msgf("=== Applying StatefulSet and Service manifest ===")
-		err = example.ApplyRawManifest(clientset, ssYAML)
+		err = example.ApplyFixtureBundle(ctx, logger, manifestClient, fixtures)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		logger.Info().Msgf("=== Applying HPA manifest (maxReplicas: %d) ===", hpaMaxReplicas)
-		err = example.ApplyRawManifest(clientset, hpaYAML)
-		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		time.Sleep(10 * time.Second)
 	})
 
-	ginkgo.It("should verify topology resources exist", func() {
+	ginkgo.It("should verify topology resources exist", func(ctx ginkgo.SpecContext) {
 
 		logger.Info().Msgf("=== Verifying cluster resources ===")
 
 		// Check StatefulSet exists
-		statefulSets, err := clientset.AppsV1().StatefulSets("test-ns").List(
-			context.TODO(),
+		statefulSets, err := clientset.AppsV1().StatefulSets(namespace).List(
+			ctx,
 			metav1.ListOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -118,8 +87,8 @@ var _ = ginkgo.Describe("StatefulSet Topology Constraints E2E test", ginkgo.Orde
 		}
 
 		// Check HPA exists
-		hpas, err := clientset.AutoscalingV2().HorizontalPodAutoscalers("test-ns").List(
-			context.TODO(),
+		hpas, err := clientset.AutoscalingV2().HorizontalPodAutoscalers(namespace).List(
+			ctx,
 			metav1.ListOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
@@ -134,109 +103,53 @@ var _ = ginkgo.Describe("StatefulSet Topology Constraints E2E test", ginkgo.Orde
 		}
 
 		logger.Info().Msgf("=== Wait for HPA to trigger scaling ===")
-		deadline := time.Now().Add(5 * time.Minute)
-		pollInterval := 5 * time.Second
-
-		for {
-			// Get current pod count for StatefulSet
-			currentPods, err := clientset.CoreV1().Pods("test-ns").List(
-				context.TODO(),
+		gomega.Eventually(ctx, func() error {
+			currentPods, err := clientset.CoreV1().Pods(namespace).List(
+				ctx,
 				metav1.ListOptions{
 					LabelSelector: "app=myapp",
 					FieldSelector: "status.phase=Running",
 				},
 			)
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
+			if err != nil {
+				return err
+			}
 
 			runningCount := len(currentPods.Items)
 			logger.Info().Msgf("Waiting for HPA, Current running pods: %d/%d\n", runningCount, hpaMaxReplicas)
-
-			if runningCount >= int(hpaMaxReplicas) {
-				logger.Info().Msgf("Waiting for HPA, Reached required pod count of %d\n", hpaMaxReplicas)
-				break
-			}
-
-			if time.Now().After(deadline) {
-				ginkgo.Fail("Failed to wait for the HPA to get to the maximum required pods")
+			if runningCount < int(hpaMaxReplicas) {
+				return fmt.Errorf("%d/%d pods running", runningCount, hpaMaxReplicas)
 			}
-
-			time.Sleep(pollInterval)
-		}
+			return nil
+		}, 5*time.Minute, 5*time.Second).Should(gomega.Succeed(), "Failed to wait for the HPA to get to the maximum required pods")
+		logger.Info().Msgf("Waiting for HPA, Reached required pod count of %d\n", hpaMaxReplicas)
 
 	})
 
-	ginkgo.It("should verify topology constraints", func() {
+	ginkgo.It("should verify topology constraints", func(ctx ginkgo.SpecContext) {
 
-		logger.Info().Msgf("=== Verifying pod scale count and distribution ===")
+		logger.Info().Msgf("=== Verifying pod distribution against the manifest topologySpreadConstraints ===")
 
-		statefulSet, err := clientset.AppsV1().StatefulSets("test-ns").Get(
-			context.TODO(),
+		statefulSet, err := clientset.AppsV1().StatefulSets(namespace).Get(
+			ctx,
 			"zone-spread-example",
 			metav1.GetOptions{},
 		)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
 
-		pods, err := clientset.CoreV1().Pods("test-ns").List(
-			context.TODO(),
-			metav1.ListOptions{
-				LabelSelector: metav1.FormatLabelSelector(statefulSet.Spec.Selector),
-			},
-		)
+		results, err := example.CheckTopologySpread(ctx, clientset, namespace, statefulSet.Spec.Template)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred())
-
-		// Get unique node names from all pods
-		nodeNames := make(map[string]struct{})
-		for _, pod := range pods.Items {
-			if pod.Spec.NodeName != "" {
-				nodeNames[pod.Spec.NodeName] = struct{}{}
-			}
+		gomega.Expect(results).NotTo(gomega.BeEmpty(), "The manifest declares no topologySpreadConstraints")
+
+		for _, result := range results {
+			logger.Info().Msgf("Topology spread: %s", result)
+			gomega.Expect(result.Matching).To(gomega.BeNumerically(">", 0),
+				fmt.Sprintf("No scheduled pod matches the %s constraint", result.TopologyKey))
+			gomega.Expect(result.Violated()).To(gomega.BeFalse(),
+				fmt.Sprintf("Topology skew violation: skew %d exceeds maxSkew %d of the %s constraint", result.Skew, result.MaxSkew, result.TopologyKey))
 		}
 
-		// Build node-to-zone mapping
-		nodeToZone := make(map[string]string)
-		for nodeName := range nodeNames {
-			node, err := clientset.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
-			gomega.Expect(err).NotTo(gomega.HaveOccurred())
-			zone, ok := node.Labels["topology.kubernetes.io/zone"]
-			if !ok {
-				ginkgo.Fail(fmt.Sprintf("Node %s missing zone label", nodeName))
-			}
-			nodeToZone[nodeName] = zone
-		}
-
-		// Collect zone distribution
-		zoneDistribution := make(map[string]int)
-		logger.Info().Msgf("\nPod-to-Zone Distribution:\n")
-		for _, pod := range pods.Items {
-			zone := nodeToZone[pod.Spec.NodeName]
-			zoneDistribution[zone]++
-			logger.Info().Msgf("- Pod %-40s → Zone: %s\n", pod.Name, zone)
-		}
-
-		// Calculate max skew between zones
-		maxCount := 0
-		minCount := len(pods.Items)
-		for _, count := range zoneDistribution {
-			if count > maxCount {
-				maxCount = count
-			}
-			if count < minCount {
-				minCount = count
-			}
-		}
-		skew := maxCount - minCount
-
-		logger.Info().Msgf("\nZone Distribution Analysis:\n")
-		logger.Info().Msgf("Total Pods: %d\n", len(pods.Items))
-		logger.Info().Msgf("Zones Used: %d\n", len(zoneDistribution))
-		logger.Info().Msgf("Max Pods per Zone: %d\n", maxCount)
-		logger.Info().Msgf("Min Pods per Zone: %d\n", minCount)
-		logger.Info().Msgf("Calculated Skew: %d\n", skew)
-
-		gomega.Expect(skew).To(gomega.BeNumerically("<=", 1),
-			fmt.Sprintf("Topology skew violation: Max zone skew %d exceeds allowed maximum of 1", skew))
-
-		logger.Info().Msgf("\nZone topology validation successful - max skew of %d within threshold\n", skew)
+		logger.Info().Msgf("\nZone topology validation successful - every constraint within its maxSkew\n")
 	})
 
 })
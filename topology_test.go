@@ -0,0 +1,126 @@
+package example_test
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"example"
+)
+
+func spreadPod(name, node string) v1.Pod {
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "spread-ns", Labels: map[string]string{"app": "myapp"}},
+		Spec:       v1.PodSpec{NodeName: node},
+	}
+}
+
+func spreadTemplate(constraint v1.TopologySpreadConstraint) v1.PodTemplateSpec {
+	constraint.TopologyKey = zoneKey
+	constraint.LabelSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "myapp"}}
+	if constraint.WhenUnsatisfiable == "" {
+		constraint.WhenUnsatisfiable = v1.DoNotSchedule
+	}
+	return v1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "myapp"}},
+		Spec:       v1.PodSpec{TopologySpreadConstraints: []v1.TopologySpreadConstraint{constraint}},
+	}
+}
+
+var _ = ginkgo.Describe("VerifyTopologySpread", ginkgo.Label("unit"), func() {
+	var nodes []v1.Node
+
+	ginkgo.BeforeEach(func() {
+		nodes = []v1.Node{
+			*zonedNode("node-a", "zone-a", "2"),
+			*zonedNode("node-b", "zone-b", "2"),
+			*zonedNode("node-c", "zone-c", "2"),
+			*zonedNode("node-d", "zone-d", "2", v1.Taint{Key: "dedicated", Value: "infra", Effect: v1.TaintEffectNoSchedule}),
+			{ObjectMeta: metav1.ObjectMeta{Name: "unzoned"}},
+		}
+	})
+
+	ginkgo.It("should count empty eligible domains in the global minimum", func() {
+		pods := []v1.Pod{
+			spreadPod("app-0", "node-a"),
+			spreadPod("app-1", "node-a"),
+			spreadPod("app-2", "node-b"),
+			spreadPod("app-3", "unzoned"),
+		}
+
+		results, err := example.VerifyTopologySpread(spreadTemplate(v1.TopologySpreadConstraint{MaxSkew: 1}), "spread-ns", nodes, pods)
+
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(results).To(gomega.HaveLen(1))
+		gomega.Expect(results[0].Domains).To(gomega.Equal(map[string]int{"zone-a": 2, "zone-b": 1, "zone-c": 0, "zone-d": 0}))
+		gomega.Expect(results[0].Matching).To(gomega.Equal(3))
+		gomega.Expect(results[0].Skew).To(gomega.Equal(2))
+		gomega.Expect(results[0].Violated()).To(gomega.BeTrue())
+		gomega.Expect(results[0].String()).To(gomega.ContainSubstring("[zone-a=2, zone-b=1, zone-c=0, zone-d=0], skew 2-0=2"))
+	})
+
+	ginkgo.It("should honor the node inclusion policies", func() {
+		honor := v1.NodeInclusionPolicyHonor
+		ignore := v1.NodeInclusionPolicyIgnore
+		pods := []v1.Pod{spreadPod("app-0", "node-a"), spreadPod("app-1", "node-b")}
+
+		template := spreadTemplate(v1.TopologySpreadConstraint{MaxSkew: 1, NodeTaintsPolicy: &honor})
+		template.Spec.Affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchExpressions: []v1.NodeSelectorRequirement{{Key: zoneKey, Operator: v1.NodeSelectorOpNotIn, Values: []string{"zone-c"}}},
+			}}},
+		}}
+
+		results, err := example.VerifyTopologySpread(template, "spread-ns", nodes, pods)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(results[0].Domains).To(gomega.Equal(map[string]int{"zone-a": 1, "zone-b": 1}))
+		gomega.Expect(results[0].Violated()).To(gomega.BeFalse())
+
+		// A toleration makes the tainted node eligible again
+		template.Spec.Tolerations = []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpExists}}
+		template.Spec.TopologySpreadConstraints[0].NodeAffinityPolicy = &ignore
+
+		results, err = example.VerifyTopologySpread(template, "spread-ns", nodes, pods)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(results[0].Domains).To(gomega.HaveLen(4))
+	})
+
+	ginkgo.It("should take the global minimum as 0 below minDomains", func() {
+		minDomains := int32(5)
+		pods := []v1.Pod{
+			spreadPod("app-0", "node-a"),
+			spreadPod("app-1", "node-b"),
+			spreadPod("app-2", "node-c"),
+			spreadPod("app-3", "node-d"),
+		}
+
+		results, err := example.VerifyTopologySpread(spreadTemplate(v1.TopologySpreadConstraint{MaxSkew: 1, MinDomains: &minDomains}), "spread-ns", nodes, pods)
+
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(results[0].GlobalMin).To(gomega.Equal(0))
+		gomega.Expect(results[0].Skew).To(gomega.Equal(1))
+		gomega.Expect(results[0].Violated()).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should only count the live matching pods of the namespace", func() {
+		terminating := spreadPod("app-1", "node-a")
+		terminating.DeletionTimestamp = &metav1.Time{}
+		otherNamespace := spreadPod("app-2", "node-a")
+		otherNamespace.Namespace = "other-ns"
+		otherApp := spreadPod("other-0", "node-a")
+		otherApp.Labels = map[string]string{"app": "other"}
+		pods := []v1.Pod{spreadPod("app-0", "node-a"), spreadPod("app-3", "node-a"), terminating, otherNamespace, otherApp}
+
+		results, err := example.VerifyTopologySpread(spreadTemplate(v1.TopologySpreadConstraint{
+			MaxSkew:           1,
+			WhenUnsatisfiable: v1.ScheduleAnyway,
+		}), "spread-ns", nodes, pods)
+
+		gomega.Expect(err).NotTo(gomega.HaveOccurred())
+		gomega.Expect(results[0].Domains["zone-a"]).To(gomega.Equal(2))
+		gomega.Expect(results[0].Skew).To(gomega.Equal(2))
+		// ScheduleAnyway is a scoring preference, never a violation
+		gomega.Expect(results[0].Violated()).To(gomega.BeFalse())
+	})
+})
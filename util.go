@@ -1,97 +1,248 @@
 package example
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
 	appsv1 "k8s.io/api/apps/v1"
-	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 	"k8s.io/apimachinery/pkg/runtime/serializer/yaml"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 )
 
-var (
-	scheme         = runtime.NewScheme()
-	jsonSerializer = json.NewSerializerWithOptions(
-		json.DefaultMetaFactory, scheme, scheme,
-		json.SerializerOptions{Yaml: false, Strict: true},
-	)
-	yamlSerializer = yaml.NewDecodingSerializer(jsonSerializer)
-)
+var yamlSerializer = yaml.NewDecodingSerializer(unstructured.UnstructuredJSONScheme)
+
+// ManifestClient applies manifests of any kind through the dynamic client,
+// resolving each kind to its resource and scope with a RESTMapper.
+type ManifestClient struct {
+	Dynamic dynamic.Interface
+	Mapper  meta.RESTMapper
+}
+
+// NewManifestClient builds a ManifestClient backed by the cluster's discovery
+// API, so kinds registered by CRDs are resolved as well.
+func NewManifestClient(config *rest.Config) (*ManifestClient, error) {
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("dynamic client creation error: %w", err)
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("discovery client creation error: %w", err)
+	}
+
+	return &ManifestClient{
+		Dynamic: dynamicClient,
+		Mapper:  restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+	}, nil
+}
+
+// splitManifest splits a multi-document YAML stream into its documents
+func splitManifest(yamlContent []byte) ([][]byte, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(yamlContent)))
+	var documents [][]byte
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, doc)
+	}
+}
+
+// restMapping resolves the object's kind, refreshing the discovery cache once
+// when the kind is unknown (e.g. a CRD applied earlier in the same run).
+func (mc *ManifestClient) restMapping(obj *unstructured.Unstructured) (*meta.RESTMapping, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := mc.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if meta.IsNoMatchError(err) {
+		if resettable, ok := mc.Mapper.(meta.ResettableRESTMapper); ok {
+			resettable.Reset()
+			mapping, err = mc.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		}
+	}
+	return mapping, err
+}
+
+// resourceFor returns the dynamic resource client for the object, scoped to
+// its namespace (or "default") for namespaced kinds.
+func (mc *ManifestClient) resourceFor(obj *unstructured.Unstructured) (dynamic.ResourceInterface, error) {
+	mapping, err := mc.restMapping(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		obj.SetNamespace("")
+		return mc.Dynamic.Resource(mapping.Resource), nil
+	}
+
+	if obj.GetNamespace() == "" {
+		obj.SetNamespace(metav1.NamespaceDefault)
+	}
+	return mc.Dynamic.Resource(mapping.Resource).Namespace(obj.GetNamespace()), nil
+}
+
+// FieldManager owns every field the suite sets through server-side apply
+const FieldManager = "e2e-test"
 
-func init() {
-	// Register all required API groups
-	corev1.AddToScheme(scheme)
-	appsv1.AddToScheme(scheme)
-	autoscalingv2.AddToScheme(scheme)
-	policyv1.AddToScheme(scheme)
+// ApplyConflictError is returned when server-side apply refuses to take over
+// fields owned by another field manager.
+type ApplyConflictError struct {
+	Object    string
+	Conflicts []string
 }
 
-func ApplyRawManifest(clientset *kubernetes.Clientset, yamlContent []byte) error {
-	// Split YAML into individual documents
-	documents := bytes.Split(yamlContent, []byte("\n---\n"))
-	var errors []string
+func (e *ApplyConflictError) Error() string {
+	return fmt.Sprintf("apply conflict on %s: %s", e.Object, strings.Join(e.Conflicts, "; "))
+}
+
+func newApplyConflictError(obj *unstructured.Unstructured, err error) error {
+	conflict := &ApplyConflictError{
+		Object: fmt.Sprintf("%s %s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName()),
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			conflict.Conflicts = append(conflict.Conflicts, fmt.Sprintf("%s (%s)", cause.Field, cause.Message))
+		}
+	}
+	if len(conflict.Conflicts) == 0 {
+		conflict.Conflicts = append(conflict.Conflicts, err.Error())
+	}
+	return conflict
+}
+
+// ServerSideApply applies every document of the manifest with server-side
+// apply as FieldManager, so re-applying an existing object is not an error.
+// Unless force is set, fields owned by another manager are reported as an
+// ApplyConflictError. The live objects are returned in document order.
+func ServerSideApply(ctx context.Context, mc *ManifestClient, yamlContent []byte, force bool) ([]*unstructured.Unstructured, error) {
+	documents, err := splitManifest(yamlContent)
+	if err != nil {
+		return nil, fmt.Errorf("manifest split failed: %w", err)
+	}
+	var applied []*unstructured.Unstructured
+	var errs []error
 
 	for i, doc := range documents {
 		if len(bytes.TrimSpace(doc)) == 0 {
 			continue
 		}
 
-		obj, _, err := yamlSerializer.Decode(doc, nil, nil)
-		if err != nil {
-			errors = append(errors, fmt.Sprintf("Document %d decode failed: %v", i+1, err))
+		obj := &unstructured.Unstructured{}
+		if _, _, err := yamlSerializer.Decode(doc, nil, obj); err != nil {
+			errs = append(errs, fmt.Errorf("document %d decode failed: %w", i+1, err))
 			continue
 		}
 
-		var createErr error
-		switch o := obj.(type) {
-		case *autoscalingv2.HorizontalPodAutoscaler:
-			_, createErr = clientset.AutoscalingV2().HorizontalPodAutoscalers(o.Namespace).Create(
-				context.TODO(), o, metav1.CreateOptions{})
-		case *appsv1.Deployment:
-			_, createErr = clientset.AppsV1().Deployments(o.Namespace).Create(
-				context.TODO(), o, metav1.CreateOptions{})
-		case *appsv1.StatefulSet:
-			_, createErr = clientset.AppsV1().StatefulSets(o.Namespace).Create(
-				context.TODO(), o, metav1.CreateOptions{})
-		case *corev1.Service:
-			_, createErr = clientset.CoreV1().Services(o.Namespace).Create(
-				context.TODO(), o, metav1.CreateOptions{})
-		case *policyv1.PodDisruptionBudget:
-			_, createErr = clientset.PolicyV1().PodDisruptionBudgets(o.Namespace).Create(
-				context.TODO(), o, metav1.CreateOptions{})
-		default:
-			errors = append(errors, fmt.Sprintf("Document %d: unsupported type %T", i+1, obj))
+		resource, err := mc.resourceFor(obj)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("document %d: cannot resolve %s: %w", i+1, obj.GroupVersionKind(), err))
 			continue
 		}
 
-		if createErr != nil {
-			errors = append(errors, fmt.Sprintf("Document %d apply failed: %v", i+1, createErr))
+		live, err := resource.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+			FieldManager: FieldManager,
+			Force:        force,
+		})
+		if apierrors.IsConflict(err) {
+			err = newApplyConflictError(obj, err)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("document %d apply failed: %w", i+1, err))
+			continue
 		}
+		applied = append(applied, live)
 	}
 
-	if len(errors) > 0 {
-		return fmt.Errorf("manifest application errors:\n%s", strings.Join(errors, "\n"))
+	if len(errs) > 0 {
+		return applied, fmt.Errorf("manifest application errors:\n%w", errors.Join(errs...))
 	}
-	return nil
+	return applied, nil
 }
 
-func ClearNamespace(logger zerolog.Logger, clientset *kubernetes.Clientset) {
-	logger.Info().Msgf("=== Final namespace cleanup ===")
+func ApplyRawManifest(ctx context.Context, mc *ManifestClient, yamlContent []byte) error {
+	_, err := ServerSideApply(ctx, mc, yamlContent, false)
+	return err
+}
+
+const (
+	RunIDLabel   = "cluster-tester/run-id"
+	TestTagLabel = "cluster-tester/test-tag"
+)
+
+// TestNamespaceName returns a fresh namespace name of the form ct-<tag>-<random>
+func TestNamespaceName(testTag string) string {
+	tag := strings.ToLower(testTag)
+	// Keep room for the "ct-" prefix and the "-xxxxx" suffix in the 63 char limit
+	if len(tag) > 54 {
+		tag = tag[:54]
+	}
+	return fmt.Sprintf("ct-%s-%s", tag, utilrand.String(5))
+}
+
+// CreateTestNamespace creates a uniquely named namespace for one Describe
+// block, labeled with the run ID and the test tag that owns it.
+func CreateTestNamespace(ctx context.Context, logger zerolog.Logger, clientset kubernetes.Interface, testTag string) (string, error) {
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: TestNamespaceName(testTag),
+			Labels: map[string]string{
+				RunIDLabel:   RunID,
+				TestTagLabel: testTag,
+			},
+		},
+	}
+
+	created, err := clientset.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("namespace creation failed: %w", err)
+	}
+
+	logger.Info().Msgf("=== Created namespace %s (run %s) ===", created.Name, RunID)
+	return created.Name, nil
+}
+
+// ClearNamespace deletes the namespace and waits up to 3 minutes for it to
+// go, then deletes it again with no grace period and waits 3 more minutes.
+// A cancelled ctx stops the wait, the namespace is still being deleted.
+func ClearNamespace(ctx context.Context, logger zerolog.Logger, clientset kubernetes.Interface, namespace string) {
+	if namespace == "" {
+		logger.Info().Msgf("=== No namespace to clean up ===")
+		return
+	}
+	logger.Info().Msgf("=== Final namespace cleanup (%s) ===", namespace)
 	err := clientset.CoreV1().Namespaces().Delete(
-		context.TODO(),
-		"test-ns",
+		ctx,
+		namespace,
 		metav1.DeleteOptions{},
 	)
 	if err != nil && !apierrors.IsNotFound(err) {
@@ -99,20 +250,16 @@ func ClearNamespace(logger zerolog.Logger, clientset *kubernetes.Clientset) {
 	}
 
 	// Wait for initial deletion (3 minutes)
-	initialDeleteTimeout := time.Now().Add(3 * time.Minute)
-	for {
-		_, err := clientset.CoreV1().Namespaces().Get(context.TODO(), "test-ns", metav1.GetOptions{})
-		if apierrors.IsNotFound(err) {
-			logger.Info().Msgf("Namespace 'test-ns' successfully deleted")
-			return
-		}
-		if time.Now().After(initialDeleteTimeout) {
-			logger.Info().Msgf("Initial deletion timed out after 3 minutes. Attempting force deletion...")
-			break
-		}
-		logger.Info().Msgf("Waiting for initial deletion to complete...")
-		time.Sleep(5 * time.Second)
+	err = waitNamespaceDeleted(ctx, logger, clientset, namespace, "initial")
+	switch {
+	case err == nil:
+		logger.Info().Msgf("Namespace '%s' successfully deleted", namespace)
+		return
+	case ctx.Err() != nil:
+		logger.Error().Msgf("Stopped waiting for the deletion of namespace '%s': %v", namespace, ctx.Err())
+		return
 	}
+	logger.Info().Msgf("Initial deletion timed out after 3 minutes. Attempting force deletion...")
 
 	// Force deletion
 	deletePolicy := metav1.DeletePropagationBackground
@@ -123,8 +270,8 @@ func ClearNamespace(logger zerolog.Logger, clientset *kubernetes.Clientset) {
 	*deleteOptions.GracePeriodSeconds = 0 // This the forcing part
 
 	err = clientset.CoreV1().Namespaces().Delete(
-		context.TODO(),
-		"test-ns",
+		ctx,
+		namespace,
 		deleteOptions,
 	)
 	if err != nil {
@@ -132,18 +279,341 @@ func ClearNamespace(logger zerolog.Logger, clientset *kubernetes.Clientset) {
 	}
 
 	// Wait for force deletion (3 minutes)
-	forceDeleteTimeout := time.Now().Add(3 * time.Minute)
-	for {
-		_, err := clientset.CoreV1().Namespaces().Get(context.TODO(), "test-ns", metav1.GetOptions{})
+	if err := waitNamespaceDeleted(ctx, logger, clientset, namespace, "force"); err != nil {
+		logger.Error().Msgf("Force deletion of namespace '%s' did not complete: %v", namespace, err)
+		return
+	}
+	logger.Info().Msgf("Namespace '%s' successfully force deleted", namespace)
+}
+
+// namespaceDeletionTimeout bounds each of the two waits of ClearNamespace
+const namespaceDeletionTimeout = 3 * time.Minute
+
+func waitNamespaceDeleted(ctx context.Context, logger zerolog.Logger, clientset kubernetes.Interface, namespace, deletion string) error {
+	return wait.PollUntilContextTimeout(ctx, 5*time.Second, namespaceDeletionTimeout, true, func(ctx context.Context) (bool, error) {
+		_, err := clientset.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
 		if apierrors.IsNotFound(err) {
-			logger.Info().Msgf("Namespace 'test-ns' successfully force deleted")
-			return
+			return true, nil
 		}
-		if time.Now().After(forceDeleteTimeout) {
-			logger.Error().Msgf("Force deletion timed out after 3 minutes")
-			return
+		logger.Info().Msgf("Waiting for %s deletion to complete...", deletion)
+		return false, nil
+	})
+}
+
+// sleepContext waits for d, or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// EvictionResult records how the API server answered each eviction request.
+// Blocked holds pods refused with 429 because a PodDisruptionBudget would
+// have been violated, Failed holds any other error, a 429 from API Priority
+// and Fairness throttling included.
+type EvictionResult struct {
+	Evicted []string
+	Blocked []string
+	Failed  map[string]error
+}
+
+// blockedByPDB tells whether an eviction was refused by a PodDisruptionBudget,
+// the API server then answers 429 with the DisruptionBudget cause
+func blockedByPDB(err error) bool {
+	if !apierrors.IsTooManyRequests(err) {
+		return false
+	}
+	if apierrors.HasStatusCause(err, policyv1.DisruptionBudgetCause) {
+		return true
+	}
+	return strings.Contains(err.Error(), "violate the pod's disruption budget")
+}
+
+// EvictPods disrupts the given pods through the policy/v1 Eviction API so
+// that PodDisruptionBudgets are honoured, unlike a raw pod Delete.
+func EvictPods(ctx context.Context, logger zerolog.Logger, clientset kubernetes.Interface, pods []corev1.Pod) *EvictionResult {
+	result := &EvictionResult{Failed: make(map[string]error)}
+
+	for _, pod := range pods {
+		eviction := &policyv1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+		}
+		err := clientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		switch {
+		case err == nil:
+			logger.Info().Msgf("[Evicted] %s", pod.Name)
+			result.Evicted = append(result.Evicted, pod.Name)
+		case blockedByPDB(err):
+			logger.Info().Msgf("[BlockedByPDB] %s: %v", pod.Name, err)
+			result.Blocked = append(result.Blocked, pod.Name)
+		default:
+			logger.Error().Msgf("[EvictionFailed] %s: %v", pod.Name, err)
+			result.Failed[pod.Name] = err
+		}
+	}
+
+	logger.Info().Msgf("Eviction summary: evicted=%d, blocked by PDB=%d, failed=%d",
+		len(result.Evicted), len(result.Blocked), len(result.Failed))
+	return result
+}
+
+// PodState is the bucket a pod is classified into by ClassifyPod
+type PodState string
+
+const (
+	PodStateReady           PodState = "Ready"
+	PodStateRunningNotReady PodState = "RunningNotReady"
+	PodStatePending         PodState = "Pending"
+	PodStateTerminating     PodState = "Terminating"
+	PodStateFinished        PodState = "Finished"
+	PodStateUnknown         PodState = "Unknown"
+)
+
+// PodClassification is the state of one pod and, when the pod is not
+// healthy, the reason reported by the kubelet or the scheduler
+// (CrashLoopBackOff, ImagePullBackOff, Unschedulable, OOMKilled...).
+type PodClassification struct {
+	Name   string
+	Node   string
+	State  PodState
+	Reason string
+}
+
+// ClassifyPod puts a pod in exactly one state bucket. Terminating wins over
+// the phase, and a running pod is only Ready when its Ready condition is true.
+func ClassifyPod(pod *corev1.Pod) PodClassification {
+	classification := PodClassification{
+		Name:   pod.Name,
+		Node:   pod.Spec.NodeName,
+		Reason: podReason(pod),
+	}
+
+	switch {
+	case pod.DeletionTimestamp != nil:
+		classification.State = PodStateTerminating
+	case pod.Status.Phase == corev1.PodPending:
+		classification.State = PodStatePending
+	case pod.Status.Phase == corev1.PodRunning:
+		classification.State = PodStateRunningNotReady
+		if isPodReady(pod) {
+			classification.State = PodStateReady
+		}
+	case pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed:
+		classification.State = PodStateFinished
+	default:
+		classification.State = PodStateUnknown
+	}
+	return classification
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podReason returns why a pod is not healthy. An OOMKilled container is the
+// root cause of the CrashLoopBackOff that follows it, so it is reported first.
+func podReason(pod *corev1.Pod) string {
+	statuses := append(append([]corev1.ContainerStatus(nil),
+		pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+
+	for _, status := range statuses {
+		for _, state := range []corev1.ContainerState{status.State, status.LastTerminationState} {
+			if state.Terminated != nil && state.Terminated.Reason == "OOMKilled" {
+				return "OOMKilled"
+			}
+		}
+	}
+	for _, status := range statuses {
+		if status.State.Waiting != nil && status.State.Waiting.Reason != "" &&
+			status.State.Waiting.Reason != "ContainerCreating" && status.State.Waiting.Reason != "PodInitializing" {
+			return status.State.Waiting.Reason
+		}
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse {
+			return cond.Reason
+		}
+	}
+	if pod.Status.Phase == corev1.PodFailed {
+		return pod.Status.Reason
+	}
+	return ""
+}
+
+// PodStateSnapshot is the classification of a pod list at one point in time
+type PodStateSnapshot struct {
+	Pods            []PodClassification
+	Ready           int
+	RunningNotReady int
+	Pending         int
+	Terminating     int
+	Finished        int
+	Unknown         int
+	// Reasons counts the pods per unhealthy reason
+	Reasons map[string]int
+}
+
+func NewPodStateSnapshot(pods []corev1.Pod) *PodStateSnapshot {
+	snapshot := &PodStateSnapshot{Reasons: make(map[string]int)}
+	for i := range pods {
+		snapshot.add(&pods[i])
+	}
+	return snapshot
+}
+
+// ListPodStateSnapshot lists the pods matching labelSelector and classifies them
+func ListPodStateSnapshot(ctx context.Context, clientset kubernetes.Interface, namespace, labelSelector string) (*PodStateSnapshot, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labelSelector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods %q in %s: %w", labelSelector, namespace, err)
+	}
+	return NewPodStateSnapshot(pods.Items), nil
+}
+
+func (s *PodStateSnapshot) add(pod *corev1.Pod) {
+	classification := ClassifyPod(pod)
+	s.Pods = append(s.Pods, classification)
+
+	switch classification.State {
+	case PodStateReady:
+		s.Ready++
+	case PodStateRunningNotReady:
+		s.RunningNotReady++
+	case PodStatePending:
+		s.Pending++
+	case PodStateTerminating:
+		s.Terminating++
+	case PodStateFinished:
+		s.Finished++
+	default:
+		s.Unknown++
+	}
+	if classification.Reason != "" {
+		s.Reasons[classification.Reason]++
+	}
+}
+
+// Active is the number of pods a workload controller counts towards its
+// replicas: every pod that is neither terminating nor finished.
+func (s *PodStateSnapshot) Active() int {
+	return s.Ready + s.RunningNotReady + s.Pending + s.Unknown
+}
+
+// Running is the number of active pods in the Running phase, ready or not
+func (s *PodStateSnapshot) Running() int {
+	return s.Ready + s.RunningNotReady
+}
+
+// Surge is the number of active pods above the desired replicas
+func (s *PodStateSnapshot) Surge(replicas int) int {
+	return max(s.Active()-replicas, 0)
+}
+
+// Unavailable is the number of desired replicas without a ready pod
+func (s *PodStateSnapshot) Unavailable(replicas int) int {
+	return max(replicas-s.Ready, 0)
+}
+
+func (s *PodStateSnapshot) String() string {
+	var reasons []string
+	for reason, count := range s.Reasons {
+		reasons = append(reasons, fmt.Sprintf("%s=%d", reason, count))
+	}
+	sort.Strings(reasons)
+
+	return fmt.Sprintf("Active: %d | Ready: %d | RunningNotReady: %d | Pending: %d | Terminating: %d | Finished: %d | Reasons: [%s]",
+		s.Active(), s.Ready, s.RunningNotReady, s.Pending, s.Terminating, s.Finished, strings.Join(reasons, ", "))
+}
+
+// RolloutStatus reports the snapshot against the limits of a workload's update strategy
+func (s *PodStateSnapshot) RolloutStatus(limits RolloutLimits) string {
+	return fmt.Sprintf("Surge Usage: %d/%d | Unavailable: %d/%d | %s",
+		s.Surge(limits.Replicas), limits.MaxSurge,
+		s.Unavailable(limits.Replicas), limits.MaxUnavailable,
+		s)
+}
+
+// RolloutLimits are the desired replicas of a workload and how far its
+// update strategy lets the pods go above or below them.
+type RolloutLimits struct {
+	Replicas       int
+	MaxSurge       int
+	MaxUnavailable int
+}
+
+// DeploymentRolloutLimits resolves the deployment strategy the way the
+// deployment controller does: surge rounds up, unavailability rounds down,
+// and both can not be zero.
+func DeploymentRolloutLimits(deployment *appsv1.Deployment) (RolloutLimits, error) {
+	limits := RolloutLimits{Replicas: 1}
+	if deployment.Spec.Replicas != nil {
+		limits.Replicas = int(*deployment.Spec.Replicas)
+	}
+
+	if deployment.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		limits.MaxUnavailable = limits.Replicas
+		return limits, nil
+	}
+
+	defaultLimit := intstr.FromString("25%")
+	maxSurge, maxUnavailable := &defaultLimit, &defaultLimit
+	if rollingUpdate := deployment.Spec.Strategy.RollingUpdate; rollingUpdate != nil {
+		if rollingUpdate.MaxSurge != nil {
+			maxSurge = rollingUpdate.MaxSurge
 		}
-		logger.Info().Msgf("Waiting for force deletion to complete...")
-		time.Sleep(5 * time.Second)
+		if rollingUpdate.MaxUnavailable != nil {
+			maxUnavailable = rollingUpdate.MaxUnavailable
+		}
+	}
+
+	var err error
+	limits.MaxSurge, err = intstr.GetScaledValueFromIntOrPercent(maxSurge, limits.Replicas, true)
+	if err != nil {
+		return limits, fmt.Errorf("invalid maxSurge of deployment %s: %w", deployment.Name, err)
+	}
+	limits.MaxUnavailable, err = intstr.GetScaledValueFromIntOrPercent(maxUnavailable, limits.Replicas, false)
+	if err != nil {
+		return limits, fmt.Errorf("invalid maxUnavailable of deployment %s: %w", deployment.Name, err)
+	}
+	if limits.MaxSurge == 0 && limits.MaxUnavailable == 0 {
+		limits.MaxUnavailable = 1
+	}
+	return limits, nil
+}
+
+// StatefulSetRolloutLimits resolves the statefulset update strategy. A
+// statefulset never surges and replaces one pod at a time unless
+// maxUnavailable is set.
+func StatefulSetRolloutLimits(sts *appsv1.StatefulSet) (RolloutLimits, error) {
+	limits := RolloutLimits{Replicas: 1, MaxUnavailable: 1}
+	if sts.Spec.Replicas != nil {
+		limits.Replicas = int(*sts.Spec.Replicas)
+	}
+
+	rollingUpdate := sts.Spec.UpdateStrategy.RollingUpdate
+	if rollingUpdate == nil || rollingUpdate.MaxUnavailable == nil {
+		return limits, nil
+	}
+
+	maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(rollingUpdate.MaxUnavailable, limits.Replicas, false)
+	if err != nil {
+		return limits, fmt.Errorf("invalid maxUnavailable of statefulset %s: %w", sts.Name, err)
 	}
+	limits.MaxUnavailable = max(maxUnavailable, 1)
+	return limits, nil
 }